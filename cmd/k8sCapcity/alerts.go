@@ -0,0 +1,311 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"sigs.k8s.io/yaml"
+)
+
+// AlertRules : Contents of the -rules file, yaml or json
+type AlertRules struct {
+	Rules []AlertRule `json:"rules"`
+}
+
+// AlertRule : Fires when expr holds for the for duration, resolves once the metric is back past resolve
+type AlertRule struct {
+	Name     string `json:"name"`
+	Expr     string `json:"expr"`
+	For      string `json:"for,omitempty"`
+	Resolve  string `json:"resolve,omitempty"`
+	Severity string `json:"severity,omitempty"`
+}
+
+// AlertEvent : Json printed when an alert starts firing or resolves
+type AlertEvent struct {
+	ECS
+	EventKind      string  `json:"event.kind"`
+	EventModule    string  `json:"event.module"`
+	EventProvider  string  `json:"event.provider"`
+	EventType      string  `json:"event.type"`
+	EventAction    string  `json:"event.action"`
+	EventVersion   string  `json:"event.version"`
+	AlertName      string  `json:"k8s_quota.alert.name"`
+	AlertExpr      string  `json:"k8s_quota.alert.expr"`
+	AlertSeverity  string  `json:"k8s_quota.alert.severity"`
+	AlertInstance  string  `json:"k8s_quota.alert.instance,omitempty"`
+	AlertValue     float64 `json:"k8s_quota.alert.value"`
+	AlertThreshold float64 `json:"k8s_quota.alert.threshold"`
+	AlertSince     string  `json:"k8s_quota.alert.since"`
+	NodeLabel      string  `json:"k8s_quota.node_label"`
+}
+
+type alertRule struct {
+	AlertRule
+	field     string
+	op        string
+	threshold float64
+	resolve   float64
+	forPeriod time.Duration
+}
+
+type alertState struct {
+	pendingSince time.Time
+	firingSince  time.Time
+	firing       bool
+}
+
+type alertEngine struct {
+	rules  []alertRule
+	states map[string]*alertState
+}
+
+var alertSeverities = map[string]bool{"info": true, "warning": true, "critical": true}
+
+// alertOperators is ordered so two character operators match first
+var alertOperators = []string{">=", "<=", ">", "<"}
+
+func loadAlertRules(path string) ([]alertRule, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	rules := AlertRules{}
+	err = yaml.Unmarshal(data, &rules)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return parseAlertRules(rules)
+}
+
+func parseAlertRules(rules AlertRules) (parsed []alertRule, err error) {
+	names := make(map[string]bool)
+	for i, rule := range rules.Rules {
+		if rule.Name == "" {
+			return nil, fmt.Errorf("rule %d has no name", i)
+		}
+		if names[rule.Name] {
+			return nil, fmt.Errorf("rule %s is defined twice", rule.Name)
+		}
+		names[rule.Name] = true
+		p, err := parseAlertRule(rule)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: %s", rule.Name, err)
+		}
+		parsed = append(parsed, p)
+	}
+	return parsed, nil
+}
+
+func parseAlertRule(rule AlertRule) (p alertRule, err error) {
+	p.AlertRule = rule
+	if p.Severity == "" {
+		p.Severity = "warning"
+	}
+	if !alertSeverities[p.Severity] {
+		return p, fmt.Errorf("unknown severity %q, expected info, warning or critical", p.Severity)
+	}
+	for _, op := range alertOperators {
+		parts := strings.SplitN(rule.Expr, op, 2)
+		if len(parts) == 2 {
+			p.field = strings.TrimSpace(parts[0])
+			p.op = op
+			p.threshold, err = parseAlertValue(parts[1])
+			if err != nil {
+				return p, err
+			}
+			break
+		}
+	}
+	if p.op == "" {
+		return p, fmt.Errorf("expr %q needs one of >, >=, <, <=", rule.Expr)
+	}
+	if _, ok := capcityMetric(Capcity{}, p.field); !ok {
+		return p, fmt.Errorf("unknown metric %q", p.field)
+	}
+	p.resolve = p.threshold
+	if rule.Resolve != "" {
+		p.resolve, err = parseAlertValue(rule.Resolve)
+		if err != nil {
+			return p, err
+		}
+	}
+	// A resolve value on the firing side would never let the alert resolve
+	if (strings.HasPrefix(p.op, ">") && p.resolve > p.threshold) || (strings.HasPrefix(p.op, "<") && p.resolve < p.threshold) {
+		return p, fmt.Errorf("resolve %s is past the threshold of %q, the alert could never resolve", rule.Resolve, rule.Expr)
+	}
+	if rule.For != "" {
+		p.forPeriod, err = parseRetention(rule.For)
+		if err != nil {
+			return p, err
+		}
+	}
+	return p, nil
+}
+
+// parseAlertValue accepts plain numbers and percentages, 95% is 0.95
+func parseAlertValue(value string) (float64, error) {
+	value = strings.TrimSpace(value)
+	if strings.HasSuffix(value, "%") {
+		percent, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+		return percent / 100, err
+	}
+	return strconv.ParseFloat(value, 64)
+}
+
+// capcityMetric finds a Capcity field by go name or json name. Per node
+// maps return one value per node, scalars a single value keyed by ""
+func capcityMetric(capCity Capcity, name string) (map[string]float64, bool) {
+	v := reflect.ValueOf(capCity)
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := strings.Split(field.Tag.Get("json"), ",")[0]
+		if field.Name != name && tag != name && tag != "k8s_quota."+name {
+			continue
+		}
+		value := v.Field(i)
+		switch value.Kind() {
+		case reflect.Float64:
+			return map[string]float64{"": value.Float()}, true
+		case reflect.Int64:
+			return map[string]float64{"": float64(value.Int())}, true
+		case reflect.Map:
+			values := make(map[string]float64)
+			for _, key := range value.MapKeys() {
+				values[key.String()] = value.MapIndex(key).Float()
+			}
+			return values, true
+		}
+	}
+	return nil, false
+}
+
+func compareAlert(op string, value, threshold float64) bool {
+	switch op {
+	case ">":
+		return value > threshold
+	case ">=":
+		return value >= threshold
+	case "<":
+		return value < threshold
+	case "<=":
+		return value <= threshold
+	}
+	return false
+}
+
+func newAlertEngine(rules []alertRule) *alertEngine {
+	return &alertEngine{
+		rules:  rules,
+		states: make(map[string]*alertState),
+	}
+}
+
+// keepStates carries pending and firing alerts over from previous, for the
+// rules that still exist
+func (a *alertEngine) keepStates(previous *alertEngine) {
+	names := make(map[string]bool)
+	for _, rule := range a.rules {
+		names[rule.Name] = true
+	}
+	for key, state := range previous.states {
+		if names[strings.SplitN(key, "/", 2)[0]] {
+			a.states[key] = state
+		}
+	}
+}
+
+// evaluate returns the alerts that started firing or resolved since the last call
+func (a *alertEngine) evaluate(capCity Capcity, now time.Time) (events []AlertEvent) {
+	for _, rule := range a.rules {
+		values, _ := capcityMetric(capCity, rule.field)
+		instances := make([]string, 0, len(values))
+		for instance := range values {
+			instances = append(instances, instance)
+		}
+		sort.Strings(instances)
+		for _, instance := range instances {
+			value := values[instance]
+			key := rule.Name + "/" + instance
+			state, ok := a.states[key]
+			if !ok {
+				state = &alertState{}
+				a.states[key] = state
+			}
+			if state.firing {
+				// Hysteresis, only resolve once past the resolve value
+				if !compareAlert(rule.op, value, rule.resolve) {
+					events = append(events, newAlertEvent(rule, instance, value, state.firingSince, "resolved", capCity, now))
+					delete(a.states, key)
+				}
+				continue
+			}
+			if !compareAlert(rule.op, value, rule.threshold) {
+				delete(a.states, key)
+				continue
+			}
+			if state.pendingSince.IsZero() {
+				state.pendingSince = now
+			}
+			if now.Sub(state.pendingSince) >= rule.forPeriod {
+				state.firing = true
+				state.firingSince = now
+				events = append(events, newAlertEvent(rule, instance, value, now, "firing", capCity, now))
+			}
+		}
+		// Nodes that went away resolve their alerts
+		for key, state := range a.states {
+			if !strings.HasPrefix(key, rule.Name+"/") {
+				continue
+			}
+			instance := strings.TrimPrefix(key, rule.Name+"/")
+			if _, ok := values[instance]; ok {
+				continue
+			}
+			if state.firing {
+				events = append(events, newAlertEvent(rule, instance, 0, state.firingSince, "resolved", capCity, now))
+			}
+			delete(a.states, key)
+		}
+	}
+	return events
+}
+
+func newAlertEvent(rule alertRule, instance string, value float64, since time.Time, action string, capCity Capcity, now time.Time) AlertEvent {
+	eventType := "start"
+	if action == "resolved" {
+		eventType = "end"
+	}
+	return AlertEvent{
+		ECS:            newECS("k8s_quota.alert", capCity.ClusterName, now),
+		EventKind:      "alert",
+		EventModule:    "k8s_quota",
+		EventProvider:  "k8sCapcity",
+		EventType:      eventType,
+		EventAction:    action,
+		EventVersion:   capCity.EventVersion,
+		AlertName:      rule.Name,
+		AlertExpr:      rule.Expr,
+		AlertSeverity:  rule.Severity,
+		AlertInstance:  instance,
+		AlertValue:     value,
+		AlertThreshold: rule.threshold,
+		AlertSince:     since.UTC().Format(time.RFC3339),
+		NodeLabel:      capCity.NodeLabel,
+	}
+}
+
+func printAlertEvents(events []AlertEvent) {
+	for _, event := range events {
+		result, err := json.Marshal(event)
+		check(err)
+		fmt.Println(string(result))
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func mustAlertRules(t *testing.T, rules ...AlertRule) []alertRule {
+	parsed, err := parseAlertRules(AlertRules{Rules: rules})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed
+}
+
+func TestParseAlertRuleErrors(t *testing.T) {
+	bad := []AlertRule{
+		{Name: "no-operator", Expr: "UtilizationFactorPodsTotal 0.9"},
+		{Name: "unknown-metric", Expr: "NotAField > 0.9"},
+		{Name: "bad-severity", Expr: "UtilizationFactorPodsTotal > 0.9", Severity: "page"},
+		{Name: "bad-for", Expr: "UtilizationFactorPodsTotal > 0.9", For: "soon"},
+		{Name: "resolve-above", Expr: "UtilizationFactorPodsTotal > 0.9", Resolve: "0.95"},
+		{Name: "resolve-below", Expr: "AvailablePodsNminusone <= 10", Resolve: "5"},
+		{Expr: "UtilizationFactorPodsTotal > 0.9"},
+	}
+	for _, rule := range bad {
+		_, err := parseAlertRules(AlertRules{Rules: []AlertRule{rule}})
+		if err == nil {
+			t.Errorf("Expected an error for %+v", rule)
+		}
+	}
+}
+
+func TestParseAlertRulePercent(t *testing.T) {
+	rules := mustAlertRules(t, AlertRule{Name: "pods", Expr: "k8s_quota.utilization_factor.pods >= 95%", Resolve: "90%"})
+	if rules[0].field != "k8s_quota.utilization_factor.pods" || rules[0].op != ">=" || rules[0].threshold != 0.95 || rules[0].resolve != 0.9 {
+		t.Errorf("Unexpected parse %+v", rules[0])
+	}
+	if rules[0].Severity != "warning" {
+		t.Errorf("Expected default severity warning, got %s", rules[0].Severity)
+	}
+}
+
+func TestAlertForAndHysteresis(t *testing.T) {
+	rules := mustAlertRules(t, AlertRule{
+		Name:     "memory",
+		Expr:     "UtilizationFactorMemoryRequestsNminusone > 0.9",
+		For:      "10m",
+		Resolve:  "0.85",
+		Severity: "critical",
+	})
+	engine := newAlertEngine(rules)
+	start := time.Date(2020, 3, 2, 0, 0, 0, 0, time.UTC)
+	steps := []struct {
+		value  float64
+		action string
+	}{
+		{0.95, ""},
+		{0.95, ""},
+		{0.95, "firing"},
+		{0.88, ""},
+		{0.95, ""},
+		{0.80, "resolved"},
+		{0.80, ""},
+	}
+	for i, step := range steps {
+		capCity := Capcity{UtilizationFactorMemoryRequestsNminusone: step.value}
+		events := engine.evaluate(capCity, start.Add(time.Duration(i)*5*time.Minute))
+		if step.action == "" {
+			if len(events) != 0 {
+				t.Errorf("Step %d: expected no events, got %+v", i, events)
+			}
+			continue
+		}
+		if len(events) != 1 || events[0].EventAction != step.action {
+			t.Fatalf("Step %d: expected %s, got %+v", i, step.action, events)
+		}
+		if events[0].EventKind != "alert" || events[0].AlertSeverity != "critical" {
+			t.Errorf("Step %d: unexpected event %+v", i, events[0])
+		}
+	}
+}
+
+func TestAlertPerNode(t *testing.T) {
+	engine := newAlertEngine(mustAlertRules(t, AlertRule{Name: "node-pods", Expr: "UtilizationFactorPods > 95%"}))
+	now := time.Date(2020, 3, 2, 0, 0, 0, 0, time.UTC)
+	capCity := Capcity{UtilizationFactorPods: map[string]float64{"node-a": 0.99, "node-b": 0.5}}
+	events := engine.evaluate(capCity, now)
+	if len(events) != 1 || events[0].AlertInstance != "node-a" || events[0].EventAction != "firing" {
+		t.Fatalf("Expected node-a to fire, got %+v", events)
+	}
+	// node-a is gone, its alert resolves
+	capCity = Capcity{UtilizationFactorPods: map[string]float64{"node-b": 0.5}}
+	events = engine.evaluate(capCity, now.Add(5*time.Minute))
+	if len(events) != 1 || events[0].AlertInstance != "node-a" || events[0].EventAction != "resolved" {
+		t.Fatalf("Expected node-a to resolve, got %+v", events)
+	}
+}
+
+func TestAlertKeepStates(t *testing.T) {
+	previous := newAlertEngine(mustAlertRules(t,
+		AlertRule{Name: "node-pods", Expr: "UtilizationFactorPods > 95%"},
+		AlertRule{Name: "memory", Expr: "UtilizationFactorMemoryRequestsNminusone > 0.9"},
+	))
+	now := time.Date(2020, 3, 2, 0, 0, 0, 0, time.UTC)
+	previous.evaluate(Capcity{UtilizationFactorPods: map[string]float64{"node-a": 0.99}, UtilizationFactorMemoryRequestsNminusone: 0.95}, now)
+
+	// memory was dropped from the rules, node-pods keeps firing without firing again
+	engine := newAlertEngine(mustAlertRules(t, AlertRule{Name: "node-pods", Expr: "UtilizationFactorPods > 95%"}))
+	engine.keepStates(previous)
+	if len(engine.states) != 1 || engine.states["node-pods/node-a"] == nil {
+		t.Fatalf("Expected only node-pods/node-a to carry over, got %+v", engine.states)
+	}
+	events := engine.evaluate(Capcity{UtilizationFactorPods: map[string]float64{"node-a": 0.99}}, now.Add(5*time.Minute))
+	if len(events) != 0 {
+		t.Errorf("Expected no new events, got %+v", events)
+	}
+}
+
+func TestLoadAlertRules(t *testing.T) {
+	f, err := ioutil.TempFile("", "k8sCapcity-rules")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`rules:
+- name: quota-oversubscribed
+  expr: SubscriptionFactorCPURequestTotal > 3
+  severity: info
+`)
+	f.Close()
+	rules, err := loadAlertRules(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 1 || rules[0].threshold != 3 || rules[0].Severity != "info" {
+		t.Errorf("Unexpected rules %+v", rules)
+	}
+}
@@ -1,13 +1,28 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
+	"time"
+
 	resource "k8s.io/apimachinery/pkg/api/resource"
 )
 
-func getCapcity(clusterInfo ClusterInfo) {
-	capCity := Capcity{}
+func getCapcity(clusterInfo ClusterInfo) Capcity {
+	capCity := calculateCapcity(clusterInfo)
+	printCapcity(capCity)
+	return capCity
+}
+
+func printCapcity(capCity Capcity) {
+	result, err := marshalEvent(capCity)
+	if err != nil {
+		fmt.Printf("There was an error during json.Marshal, Error: %s\n", err)
+		panic(err)
+	}
+	fmt.Println(string(result))
+}
+
+func calculateCapcity(clusterInfo ClusterInfo) (capCity Capcity) {
 	capCity.UtilizationFactorPods = make(map[string]float64)
 	capCity.UtilizationFactorMemoryRequests = make(map[string]float64)
 	capCity.UtilizationFactorCPURequests = make(map[string]float64)
@@ -27,12 +42,14 @@ func getCapcity(clusterInfo ClusterInfo) {
 		}
 	}
 
+	capCity.ECS = newECS("k8s_quota.cluster", clusterInfo.ClusterName, time.Now())
 	capCity.EventKind = "metric"
 	capCity.EventModule = "k8s_quota"
 	capCity.EventProvider = "k8sCapcity"
 	capCity.EventType = "info"
-	capCity.EventVersion = "03/06/2020-01"
+	capCity.EventVersion = eventVersionV1
 	capCity.NodeLabel = clusterInfo.NodeLabel
+	capCity.ClusterName = clusterInfo.ClusterName
 	capCity.ResourceQuotaCPURequestCores = clusterInfo.RqclusterAllocatedRequestsCPU.Value()
 	capCity.ResourceQuotaCPURequestMilliCores = clusterInfo.RqclusterAllocatedRequestsCPU.ScaledValue(resource.Milli)
 	capCity.ResourceQuotaMemoryRequest = clusterInfo.RqclusterAllocatedRequestsMemory.Value()
@@ -115,10 +132,5 @@ func getCapcity(clusterInfo ClusterInfo) {
 	capCity.AvailableCPURequestNminusone = capCity.AllocatableCPUNminusone - capCity.ContainerResourceCPURequestCores
 	capCity.AvailablePodsTotal = capCity.AllocatablePodsTotal - capCity.ContainerResourcePods
 	capCity.AvailablePodsNminusone = capCity.AllocatablePodsNminusone - capCity.ContainerResourcePods
-	result, err := json.Marshal(capCity)
-	if err != nil {
-		fmt.Printf("There was an error during json.Marshal, Error: %s\n", err)
-		panic(err)
-	}
-	fmt.Println(string(result))
+	return capCity
 }
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// apiCall : One independent api request of a collection
+type apiCall struct {
+	name string
+	run  func(ctx context.Context) error
+}
+
+// runConcurrently runs every call, at most -parallelism at a time, and
+// waits until they are all done or ctx ends. The first failure (a panic from
+// check included) is returned, calls still running stop at their next page
+// once the caller cancels ctx
+func runConcurrently(ctx context.Context, calls ...apiCall) error {
+	slots := collectSlots()
+	errs := make(chan error, len(calls))
+	for _, call := range calls {
+		go func(call apiCall) {
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				errs <- fmt.Errorf("%s: %s", call.name, ctx.Err())
+				return
+			}
+			defer func() { <-slots }()
+			errs <- runCall(ctx, call)
+		}(call)
+	}
+	for range calls {
+		select {
+		case err := <-errs:
+			if err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return fmt.Errorf("collection did not finish: %s", ctx.Err())
+		}
+	}
+	return nil
+}
+
+// collectSlots hands out -parallelism tokens, one per call in flight
+func collectSlots() chan struct{} {
+	parallelism := currentSettings().parallelism
+	if parallelism < 1 {
+		return make(chan struct{}, 1)
+	}
+	return make(chan struct{}, parallelism)
+}
+
+func runCall(ctx context.Context, call apiCall) (err error) {
+	start := time.Now()
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+		if err != nil {
+			err = fmt.Errorf("%s: %s", call.name, err)
+		}
+		log.WithFields(log.Fields{
+			"call":    call.name,
+			"latency": time.Since(start).String(),
+			"failed":  err != nil,
+		}).Debug("api call finished")
+	}()
+	return call.run(ctx)
+}
+
+// collectContext gives a collection its shared deadline, 0 means none but
+// parent's
+func collectContext(parent context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout > 0 {
+		return context.WithTimeout(parent, timeout)
+	}
+	return context.WithCancel(parent)
+}
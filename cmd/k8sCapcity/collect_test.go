@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRunConcurrently(t *testing.T) {
+	var lock sync.Mutex
+	running, most := 0, 0
+	call := func(ctx context.Context) error {
+		lock.Lock()
+		running++
+		if running > most {
+			most = running
+		}
+		lock.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		lock.Lock()
+		running--
+		lock.Unlock()
+		return nil
+	}
+	calls := []apiCall{{"a", call}, {"b", call}, {"c", call}, {"d", call}, {"e", call}, {"f", call}}
+	err := runConcurrently(context.Background(), calls...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parallelism := currentSettings().parallelism; most < 2 || most > parallelism {
+		t.Errorf("Expected between 2 and %d calls in flight, got %d", parallelism, most)
+	}
+}
+
+func TestRunConcurrentlyErrors(t *testing.T) {
+	err := runConcurrently(context.Background(),
+		apiCall{"nodes", func(ctx context.Context) error { return nil }},
+		apiCall{"pods", func(ctx context.Context) error { return errors.New("forbidden") }},
+	)
+	if err == nil || err.Error() != "pods: forbidden" {
+		t.Errorf("Expected the pods error, got %v", err)
+	}
+	err = runConcurrently(context.Background(),
+		apiCall{"nodemetrics", func(ctx context.Context) error { panic("metrics api down") }},
+	)
+	if err == nil || !strings.Contains(err.Error(), "metrics api down") {
+		t.Errorf("Expected the panic as an error, got %v", err)
+	}
+
+	ctx, cancel := collectContext(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	release := make(chan struct{})
+	defer close(release)
+	err = runConcurrently(ctx, apiCall{"slow", func(ctx context.Context) error {
+		<-release
+		return nil
+	}})
+	if err == nil || !strings.Contains(err.Error(), "deadline") {
+		t.Errorf("Expected the deadline to end the collection, got %v", err)
+	}
+}
@@ -0,0 +1,622 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	resource "k8s.io/apimachinery/pkg/api/resource"
+)
+
+// version is set at build time, goreleaser passes -X main.version
+var version = "dev"
+
+// binaryName is what completion scripts are registered for
+const binaryName = "k8sCapcity"
+
+// command : One k8sCapcity subcommand, with its own flags
+type command struct {
+	name  string
+	args  string
+	short string
+	flags *flag.FlagSet
+	run   func(args []string) error
+	// config is -config, applied with the environment after parsing
+	config    *string
+	arguments []string
+}
+
+func newCommand(name, args, short string) *command {
+	c := &command{name: name, args: args, short: short}
+	c.flags = flag.NewFlagSet(name, flag.ContinueOnError)
+	c.flags.Usage = func() {
+		out := c.flags.Output()
+		fmt.Fprintf(out, "Usage: %s %s [flags] %s\n\n%s\n\nFlags:\n", binaryName, name, args, c.short)
+		c.flags.PrintDefaults()
+	}
+	c.config = addConfigFlag(c.flags)
+	return c
+}
+
+// flagError : Arguments the flag package rejected, it has printed why with the usage
+type flagError struct {
+	error
+}
+
+// parse parses args, then fills what they leave out from the K8SCAPCITY_*
+// environment and -config
+func (c *command) parse(args []string) error {
+	c.arguments = args
+	err := c.flags.Parse(args)
+	if err == flag.ErrHelp {
+		return err
+	}
+	if err != nil {
+		return flagError{err}
+	}
+	return c.loadConfig()
+}
+
+func (c *command) loadConfig() error {
+	if c.config == nil {
+		return nil
+	}
+	return loadConfig(c.flags, *c.config)
+}
+
+// flagsSet names the flags given on the command line, as opposed to defaults
+func flagsSet(flags *flag.FlagSet) map[string]bool {
+	set := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	return set
+}
+
+// conflicts complains about the first pair of given flags that cannot be used together
+func conflicts(flags *flag.FlagSet, pairs ...[2]string) error {
+	set := flagsSet(flags)
+	for _, pair := range pairs {
+		if set[pair[0]] && set[pair[1]] {
+			return fmt.Errorf("-%s and -%s cannot be used together", pair[0], pair[1])
+		}
+	}
+	return nil
+}
+
+func expectArgs(args []string, names ...string) error {
+	if len(args) != len(names) {
+		if len(names) == 0 {
+			return fmt.Errorf("unexpected arguments %s", strings.Join(args, " "))
+		}
+		return fmt.Errorf("expected %s", strings.Join(names, " "))
+	}
+	return nil
+}
+
+func printJSON(v interface{}) {
+	result, err := json.Marshal(v)
+	check(err)
+	fmt.Println(string(result))
+}
+
+func clusterCommand() *command {
+	c := newCommand("cluster", "", "Cluster wide allocatable, requested, available and N-1 capacity")
+	cluster := addClusterFlags(c.flags)
+	cluster.addNodeLabelFlag(c.flags)
+	cluster.addEventVersionFlag(c.flags)
+	multi := addMultiClusterFlags(c.flags)
+	jsonMode := c.flags.Bool("json", false, "Output information in json format")
+	output := addOutputFlag(c.flags)
+	historyFile := c.flags.String("history", "", "History file to forecast capacity exhaustion from")
+	forecast := addForecastFlags(c.flags)
+	c.run = func(args []string) error {
+		if err := expectArgs(args); err != nil {
+			return err
+		}
+		if err := conflicts(c.flags, [2]string{"contexts", "history"}, [2]string{"kubeconfig-dir", "history"}, [2]string{"contexts", "context"},
+			[2]string{"json", "o"}, [2]string{"contexts", "o"}, [2]string{"kubeconfig-dir", "o"}); err != nil {
+			return err
+		}
+		if err := checkOutput(*output); err != nil {
+			return err
+		}
+		cluster.setUp()
+		if multi.enabled() {
+			multi.run(cluster, *jsonMode)
+			return nil
+		}
+		clientset, err := cluster.clientset()
+		if err != nil {
+			return err
+		}
+		clusterInfo := cluster.gatherInfo(clientset)
+		if *output != "" {
+			return renderCluster(os.Stdout, *output, clusterInfo, *historyFile, forecast.forecasts(*historyFile))
+		}
+		printCluster(clusterInfo, *jsonMode, *historyFile, forecast.forecasts(*historyFile))
+		return nil
+	}
+	return c
+}
+
+func nodesCommand() *command {
+	c := newCommand("nodes", "", "Allocatable, requested and available resources of every selected node")
+	cluster := addClusterFlags(c.flags)
+	cluster.addNodeLabelFlag(c.flags)
+	jsonMode := c.flags.Bool("json", false, "Output information in json format")
+	wide := c.flags.Bool("wide", false, "Add used and available columns to the table, same as -o wide")
+	output := addOutputFlag(c.flags)
+	eventLabels := addEventLabelFlag(c.flags)
+	c.run = func(args []string) error {
+		if err := expectArgs(args); err != nil {
+			return err
+		}
+		if err := conflicts(c.flags, [2]string{"json", "wide"}, [2]string{"json", "o"}, [2]string{"wide", "o"}); err != nil {
+			return err
+		}
+		if err := checkOutput(*output); err != nil {
+			return err
+		}
+		cluster.setUp()
+		clientset, err := cluster.clientset()
+		if err != nil {
+			return err
+		}
+		clusterInfo := cluster.gatherInfo(clientset)
+		if *jsonMode {
+			for _, node := range nodeEvents(clusterInfo, *eventLabels) {
+				printJSON(node)
+			}
+			return nil
+		}
+		if *wide {
+			*output = "wide"
+		}
+		return render(os.Stdout, *output, nodesView(clusterInfo, *eventLabels))
+	}
+	return c
+}
+
+func nodePoolsCommand() *command {
+	c := newCommand("nodepools", "", "Allocatable, requested and available resources, and N-1, of every node pool")
+	cluster := addClusterFlags(c.flags)
+	cluster.addNodeLabelFlag(c.flags)
+	poolLabel := c.flags.String("pool-label", "", "Node label naming the pool, if blank the first of "+strings.Join(nodePoolLabels, ", ")+" a node has")
+	output := addOutputFlag(c.flags)
+	c.run = func(args []string) error {
+		if err := expectArgs(args); err != nil {
+			return err
+		}
+		if err := checkOutput(*output); err != nil {
+			return err
+		}
+		cluster.setUp()
+		clientset, err := cluster.clientset()
+		if err != nil {
+			return err
+		}
+		return render(os.Stdout, *output, nodePoolsView(cluster.gatherInfo(clientset), *poolLabel))
+	}
+	return c
+}
+
+func namespaceCommand() *command {
+	c := newCommand("namespace", "[NAMESPACE]", "Requests, limits and usage of every container in one namespace, by default the context's")
+	cluster := addClusterFlags(c.flags)
+	jsonMode := c.flags.Bool("json", false, "Output information in json format")
+	output := addOutputFlag(c.flags)
+	c.run = func(args []string) error {
+		if len(args) > 1 {
+			return fmt.Errorf("expected NAMESPACE, or nothing for the namespace of the context")
+		}
+		if len(args) == 1 {
+			cluster.kubeconfig.namespace = args[0]
+		}
+		if err := conflicts(c.flags, [2]string{"json", "o"}); err != nil {
+			return err
+		}
+		if err := checkOutput(*output); err != nil {
+			return err
+		}
+		cluster.setUp()
+		clientset, err := cluster.clientset()
+		if err != nil {
+			return err
+		}
+		namespace, err := cluster.kubeconfig.defaultNamespace()
+		if err != nil {
+			return err
+		}
+		nsInfo := cluster.gatherNamespaceInfo(clientset, namespace)
+		if *jsonMode {
+			printJSON(nsInfo)
+			return nil
+		}
+		if *output != "" {
+			return render(os.Stdout, *output, namespaceView(nsInfo))
+		}
+		for _, line := range namespaceHumanMode(nsInfo) {
+			fmt.Println(line)
+		}
+		return nil
+	}
+	return c
+}
+
+func namespacesCommand() *command {
+	c := newCommand("namespaces", "", "Requests and limits of every namespace on the selected nodes")
+	cluster := addClusterFlags(c.flags)
+	cluster.addNodeLabelFlag(c.flags)
+	jsonMode := c.flags.Bool("json", false, "Output information in json format")
+	output := addOutputFlag(c.flags)
+	c.run = func(args []string) error {
+		if err := expectArgs(args); err != nil {
+			return err
+		}
+		if err := conflicts(c.flags, [2]string{"json", "o"}); err != nil {
+			return err
+		}
+		if err := checkOutput(*output); err != nil {
+			return err
+		}
+		cluster.setUp()
+		clientset, err := cluster.clientset()
+		if err != nil {
+			return err
+		}
+		clusterInfo := cluster.gatherInfo(clientset)
+		if *jsonMode {
+			printJSON(clusterInfo.NamespaceTotals)
+			return nil
+		}
+		return render(os.Stdout, *output, namespacesView(clusterInfo.NamespaceTotals))
+	}
+	return c
+}
+
+func daemonCommand() *command {
+	c, _ := newDaemonCommand()
+	return c
+}
+
+func newDaemonCommand() (*command, *daemonSettings) {
+	c := newCommand("daemon", "", "Print the cluster report as json on a schedule, with history, alerts and notifications. SIGHUP reloads -config and the flags")
+	settings := addDaemonSettingsFlags(c.flags, "File to record every daemon cycle in")
+	c.run = func(args []string) error {
+		if err := expectArgs(args); err != nil {
+			return err
+		}
+		if err := settings.validate(c.flags); err != nil {
+			return err
+		}
+		settings.cluster.setUp()
+		clientset, err := settings.cluster.clientset()
+		if err != nil {
+			return err
+		}
+		return settings.run(clientset, func() (*daemonSettings, error) {
+			reloaded, reloadedSettings := newDaemonCommand()
+			err := reloaded.parse(c.arguments)
+			if err != nil {
+				return nil, err
+			}
+			return reloadedSettings, reloadedSettings.validate(reloaded.flags)
+		})
+	}
+	return c, settings
+}
+
+func serveCommand() *command {
+	c := newCommand("serve", "", "Answer capacity queries over http from a cache refreshed every -refresh, see /v1/cluster")
+	cluster := addClusterFlags(c.flags)
+	cluster.addNodeLabelFlag(c.flags)
+	cluster.addEventVersionFlag(c.flags)
+	eventLabels := addEventLabelFlag(c.flags)
+	listen := c.flags.String("listen", ":8080", "Address to serve the api on")
+	refresh := c.flags.Duration("refresh", time.Minute, "How often to collect the capacity the api answers with")
+	readyIntervals := addReadyIntervalsFlag(c.flags)
+	c.run = func(args []string) error {
+		if err := expectArgs(args); err != nil {
+			return err
+		}
+		if *refresh <= 0 || *readyIntervals < 1 {
+			return fmt.Errorf("-refresh must be positive and -ready-intervals at least 1")
+		}
+		cluster.setUp()
+		clientset, err := cluster.clientset()
+		if err != nil {
+			return err
+		}
+		if cluster.collectTimeout == 0 {
+			cluster.collectTimeout = *refresh
+		}
+		return runAPIServer(*listen, *refresh, *readyIntervals, &apiServer{}, func(ctx context.Context) *apiState {
+			return cluster.gatherAPIState(ctx, clientset, *eventLabels)
+		})
+	}
+	return c
+}
+
+func checkCommand() *command {
+	c := newCommand("check", "", "Check the kubernetes connection, prints ok")
+	kubeconfig := addKubeconfigFlags(c.flags)
+	c.run = func(args []string) error {
+		if err := expectArgs(args); err != nil {
+			return err
+		}
+		cluster := &clusterOptions{kubeconfig: kubeconfig}
+		clientset, err := cluster.clientset()
+		if err != nil {
+			return err
+		}
+		err = checkConnection(clientset)
+		if err != nil {
+			return err
+		}
+		fmt.Println("ok")
+		return nil
+	}
+	return c
+}
+
+func simulateCommand() *command {
+	c := newCommand("simulate", "", "How many replicas of a pod requesting -cpu and -memory fit, in total and N-1")
+	cluster := addClusterFlags(c.flags)
+	cluster.addNodeLabelFlag(c.flags)
+	cpu := c.flags.String("cpu", "", "CPU request of one replica, e.g. 500m")
+	memory := c.flags.String("memory", "", "Memory request of one replica, e.g. 1Gi")
+	replicas := c.flags.Int64("replicas", 1, "How many replicas have to fit")
+	jsonMode := c.flags.Bool("json", false, "Output information in json format")
+	c.run = func(args []string) error {
+		if err := expectArgs(args); err != nil {
+			return err
+		}
+		if *cpu == "" && *memory == "" {
+			return fmt.Errorf("give -cpu, -memory or both")
+		}
+		if *replicas < 1 {
+			return fmt.Errorf("-replicas must be at least 1")
+		}
+		var cpuRequest, memoryRequest resource.Quantity
+		var err error
+		if *cpu != "" {
+			cpuRequest, err = resource.ParseQuantity(*cpu)
+			if err != nil {
+				return fmt.Errorf("-cpu %q: %s", *cpu, err)
+			}
+		}
+		if *memory != "" {
+			memoryRequest, err = resource.ParseQuantity(*memory)
+			if err != nil {
+				return fmt.Errorf("-memory %q: %s", *memory, err)
+			}
+		}
+		cluster.setUp()
+		clientset, err := cluster.clientset()
+		if err != nil {
+			return err
+		}
+		result := simulateFit(cluster.gatherInfo(clientset), cpuRequest, memoryRequest, *replicas)
+		if *jsonMode {
+			printJSON(result)
+			return nil
+		}
+		for _, line := range fitHumanMode(result) {
+			fmt.Println(line)
+		}
+		return nil
+	}
+	return c
+}
+
+func historyCommand() *command {
+	c := newCommand("history", "FIELD", "Trend of a field recorded in -history, e.g. utilization_factor.memory_request.nminusone")
+	historyFile := c.flags.String("history", "", "History file written by the daemon")
+	query := addHistoryQueryFlags(c.flags)
+	c.run = func(args []string) error {
+		if err := expectArgs(args, "FIELD"); err != nil {
+			return err
+		}
+		if *historyFile == "" {
+			return fmt.Errorf("-history is required")
+		}
+		query.run(*historyFile, args[0])
+		return nil
+	}
+	return c
+}
+
+func schemaCommand() *command {
+	c := newCommand("schema", "", "Print the JSON Schema of the capacity or node event, published under docs/schema")
+	event := c.flags.String("event", "capacity", "Event to describe, capacity or node")
+	version := eventVersionFlag(1)
+	c.flags.Var(&version, "event-version", "Version of the event, 1 or 2 for the capacity event")
+	c.run = func(args []string) error {
+		if err := expectArgs(args); err != nil {
+			return err
+		}
+		s, err := eventSchema(*event, int(version))
+		if err != nil {
+			return err
+		}
+		result, err := json.MarshalIndent(s, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(result))
+		return nil
+	}
+	return c
+}
+
+func configCommand() *command {
+	c := newCommand("config", "validate", "Check every key of -config (or K8SCAPCITY_CONFIG) and every K8SCAPCITY_* variable names a flag and parses, prints ok")
+	path := c.config
+	// validate reads -config itself, rather than applying it
+	c.config = nil
+	c.run = func(args []string) error {
+		// config validate -config FILE, flags after the subcommand too
+		if len(args) > 0 && args[0] == "validate" {
+			if err := c.flags.Parse(args[1:]); err != nil {
+				return err
+			}
+			args = append(args[:1], c.flags.Args()...)
+		}
+		if err := expectArgs(args, "validate"); err != nil {
+			return err
+		}
+		if args[0] != "validate" {
+			return fmt.Errorf("unknown subcommand %q, expected validate", args[0])
+		}
+		config, err := loadConfigFile(configPath(*path))
+		if err != nil {
+			return err
+		}
+		problems := validateConfig(config, os.Environ())
+		if len(problems) > 0 {
+			return fmt.Errorf("%s", strings.Join(problems, "\n"))
+		}
+		fmt.Println("ok")
+		return nil
+	}
+	return c
+}
+
+func versionCommand() *command {
+	c := newCommand("version", "", "Print the version")
+	c.run = func(args []string) error {
+		if err := expectArgs(args); err != nil {
+			return err
+		}
+		fmt.Println(version)
+		return nil
+	}
+	return c
+}
+
+func completionCommand() *command {
+	c := newCommand("completion", "bash|zsh", "Print a shell completion script, e.g. source <(k8sCapcity completion bash)")
+	c.run = func(args []string) error {
+		if err := expectArgs(args, "bash|zsh"); err != nil {
+			return err
+		}
+		switch args[0] {
+		case "bash":
+		case "zsh":
+			fmt.Println("autoload -U +X bashcompinit && bashcompinit")
+		default:
+			return fmt.Errorf("no completion for %s, use bash or zsh", args[0])
+		}
+		completionScript(os.Stdout, commands())
+		return nil
+	}
+	return c
+}
+
+// commands builds every subcommand with fresh flags
+func commands() []*command {
+	return []*command{
+		clusterCommand(),
+		nodesCommand(),
+		nodePoolsCommand(),
+		namespaceCommand(),
+		namespacesCommand(),
+		daemonCommand(),
+		serveCommand(),
+		checkCommand(),
+		simulateCommand(),
+		historyCommand(),
+		schemaCommand(),
+		configCommand(),
+		versionCommand(),
+		completionCommand(),
+	}
+}
+
+// completionScript completes subcommand names, then the flags of the subcommand
+func completionScript(out io.Writer, cmds []*command) {
+	var names []string
+	for _, c := range cmds {
+		names = append(names, c.name)
+	}
+	fmt.Fprintf(out, "_%s() {\n", binaryName)
+	fmt.Fprintln(out, "    local cur=\"${COMP_WORDS[COMP_CWORD]}\"")
+	fmt.Fprintln(out, "    if [[ $COMP_CWORD -eq 1 ]]; then")
+	fmt.Fprintf(out, "        COMPREPLY=($(compgen -W \"%s help\" -- \"$cur\"))\n", strings.Join(names, " "))
+	fmt.Fprintln(out, "        return")
+	fmt.Fprintln(out, "    fi")
+	fmt.Fprintln(out, "    case \"${COMP_WORDS[1]}\" in")
+	for _, c := range cmds {
+		var flags []string
+		c.flags.VisitAll(func(f *flag.Flag) {
+			flags = append(flags, "-"+f.Name)
+		})
+		sort.Strings(flags)
+		words := strings.Join(flags, " ")
+		if c.name == "completion" {
+			words = "bash zsh"
+		}
+		if c.name == "config" {
+			words = words + " validate"
+		}
+		fmt.Fprintf(out, "        %s) COMPREPLY=($(compgen -W \"%s\" -- \"$cur\")) ;;\n", c.name, words)
+	}
+	fmt.Fprintln(out, "    esac")
+	fmt.Fprintln(out, "}")
+	fmt.Fprintf(out, "complete -F _%s %s\n", binaryName, binaryName)
+}
+
+func commandsUsage(out io.Writer, cmds []*command) {
+	fmt.Fprintf(out, "Usage: %s <command> [flags]\n\nCommands:\n", binaryName)
+	w := newTabWriter(out)
+	for _, c := range cmds {
+		fmt.Fprintf(w, "  %s %s\t%s\n", c.name, c.args, c.short)
+	}
+	w.Flush()
+	fmt.Fprintf(out, "\nRun '%s <command> -h' for the flags of a command. Flags without a command (%s -json ...) keep working as before\n", binaryName, binaryName)
+}
+
+// runCommand runs `k8sCapcity <command> [flags] [args]` and returns the exit code
+func runCommand(args []string) int {
+	cmds := commands()
+	if args[0] == "help" {
+		if len(args) > 1 {
+			args = []string{args[1], "-h"}
+		} else {
+			commandsUsage(os.Stdout, cmds)
+			return 0
+		}
+	}
+	for _, c := range cmds {
+		if c.name != args[0] {
+			continue
+		}
+		err := c.parse(args[1:])
+		if err == flag.ErrHelp {
+			return 0
+		}
+		if _, ok := err.(flagError); ok {
+			return 2
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s %s\n", c.name, err)
+			return 2
+		}
+		err = c.run(c.flags.Args())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s %s\n", c.name, err)
+			return 1
+		}
+		return 0
+	}
+	fmt.Fprintf(os.Stderr, "error: unknown command %q\n\n", args[0])
+	commandsUsage(os.Stderr, cmds)
+	return 2
+}
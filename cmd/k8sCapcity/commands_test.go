@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRunCommandValidation(t *testing.T) {
+	cases := [][]string{
+		{"namespace", "a", "b"},
+		{"daemon", "-schedule", "*/5 * * * *", "-interval", "1m"},
+		{"daemon", "-notify-dry-run"},
+		{"daemon", "-history-retention", "7d"},
+		{"simulate"},
+		{"simulate", "-cpu", "lots"},
+		{"simulate", "-cpu", "1", "-replicas", "0"},
+		{"nodes", "-json", "-wide"},
+		{"history", "utilization_factor.pods.total"},
+		{"completion", "fish"},
+	}
+	for _, args := range cases {
+		if code := runCommand(args); code != 1 {
+			t.Errorf("%v: expected exit code 1, got %d", args, code)
+		}
+	}
+	if code := runCommand([]string{"bogus"}); code != 2 {
+		t.Errorf("Expected exit code 2 for an unknown command, got %d", code)
+	}
+	if code := runCommand([]string{"namespace", "-nodelabel", "a=b", "kube-system"}); code != 2 {
+		t.Errorf("Expected -nodelabel to be unknown to namespace, got %d", code)
+	}
+	if code := runCommand([]string{"version"}); code != 0 {
+		t.Errorf("Expected version to succeed, got %d", code)
+	}
+}
+
+func TestCompletionScript(t *testing.T) {
+	var out bytes.Buffer
+	completionScript(&out, commands())
+	script := out.String()
+	for _, expected := range []string{
+		"cluster nodes nodepools namespace namespaces daemon serve check simulate history schema config version completion help",
+		"simulate) COMPREPLY=($(compgen -W \"-as -as-group -cluster -cluster-name -collect-timeout -config -context -cpu",
+		"completion) COMPREPLY=($(compgen -W \"bash zsh\"",
+		"config) COMPREPLY=($(compgen -W \"-config validate\"",
+		"complete -F _k8sCapcity k8sCapcity",
+	} {
+		if !strings.Contains(script, expected) {
+			t.Errorf("Expected %q in\n%s", expected, script)
+		}
+	}
+}
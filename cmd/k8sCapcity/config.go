@@ -0,0 +1,217 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// configEnvPrefix : K8SCAPCITY_NODELABEL sets -nodelabel, K8SCAPCITY_HISTORY_RETENTION -history-retention
+const configEnvPrefix = "K8SCAPCITY_"
+
+// configFile : Flag name to value, read from a yaml or json file
+type configFile map[string]interface{}
+
+func addConfigFlag(flags *flag.FlagSet) *string {
+	return flags.String("config", "", "Yaml or json file of flag values, e.g. nodelabel: role=compute. Flags and K8SCAPCITY_* environment variables win over it")
+}
+
+// configPath is -config, or K8SCAPCITY_CONFIG when it is not given
+func configPath(path string) string {
+	if path != "" {
+		return path
+	}
+	return os.Getenv(envName("config"))
+}
+
+func envName(flagName string) string {
+	return configEnvPrefix + strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(flagName))
+}
+
+func loadConfigFile(path string) (configFile, error) {
+	config := configFile{}
+	if path == "" {
+		return config, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	err = yaml.Unmarshal(data, &config)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %s", path, err)
+	}
+	return config, nil
+}
+
+// configValues turns a yaml scalar or list into flag values
+func configValues(value interface{}) ([]string, error) {
+	switch v := value.(type) {
+	case string:
+		return []string{v}, nil
+	case bool:
+		return []string{strconv.FormatBool(v)}, nil
+	case float64:
+		return []string{strconv.FormatFloat(v, 'f', -1, 64)}, nil
+	case []interface{}:
+		var values []string
+		for _, item := range v {
+			itemValues, err := configValues(item)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, itemValues...)
+		}
+		return values, nil
+	}
+	return nil, fmt.Errorf("expected a string, number, boolean or list, got %T", value)
+}
+
+// setFlag sets a repeatable flag once per value, any other flag to the values comma separated
+func setFlag(flags *flag.FlagSet, name string, values []string) error {
+	f := flags.Lookup(name)
+	if _, ok := f.Value.(*stringList); ok {
+		for _, value := range values {
+			if err := f.Value.Set(value); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return f.Value.Set(strings.Join(values, ","))
+}
+
+// applyConfig fills every flag not given on the command line from its
+// K8SCAPCITY_* environment variable, else from the config file
+func applyConfig(flags *flag.FlagSet, config configFile) error {
+	set := flagsSet(flags)
+	var err error
+	flags.VisitAll(func(f *flag.Flag) {
+		if err != nil || set[f.Name] || f.Name == "config" {
+			return
+		}
+		if value, ok := os.LookupEnv(envName(f.Name)); ok {
+			values := []string{value}
+			if _, repeatable := f.Value.(*stringList); repeatable {
+				values = strings.Split(value, ",")
+			}
+			if setErr := setFlag(flags, f.Name, values); setErr != nil {
+				err = fmt.Errorf("%s: %s", envName(f.Name), setErr)
+			}
+			return
+		}
+		value, ok := config[f.Name]
+		if !ok {
+			return
+		}
+		values, valueErr := configValues(value)
+		if valueErr == nil {
+			valueErr = setFlag(flags, f.Name, values)
+		}
+		if valueErr != nil {
+			err = fmt.Errorf("config %s: %s", f.Name, valueErr)
+		}
+	})
+	return err
+}
+
+// loadConfig reads -config (or K8SCAPCITY_CONFIG) and applies it and the
+// environment to flags. Keys no command knows are an error
+func loadConfig(flags *flag.FlagSet, path string) error {
+	config, err := loadConfigFile(configPath(path))
+	if err != nil {
+		return err
+	}
+	if problems := unknownConfigKeys(config); len(problems) > 0 {
+		return fmt.Errorf("%s", strings.Join(problems, ", "))
+	}
+	return applyConfig(flags, config)
+}
+
+// allFlagSets is every command's flags plus the original flag mode
+func allFlagSets() []*flag.FlagSet {
+	var sets []*flag.FlagSet
+	for _, c := range commands() {
+		sets = append(sets, c.flags)
+	}
+	legacy := flag.NewFlagSet(binaryName, flag.ContinueOnError)
+	addLegacyFlags(legacy)
+	return append(sets, legacy)
+}
+
+func unknownConfigKeys(config configFile) (problems []string) {
+	known := make(map[string]bool)
+	for _, flags := range allFlagSets() {
+		flags.VisitAll(func(f *flag.Flag) {
+			known[f.Name] = true
+		})
+	}
+	for key := range config {
+		if !known[key] || key == "config" {
+			problems = append(problems, fmt.Sprintf("unknown config key %q", key))
+		}
+	}
+	sort.Strings(problems)
+	return problems
+}
+
+// validateConfig checks every key names a flag and every value parses for it,
+// and that K8SCAPCITY_* variables name flags too
+func validateConfig(config configFile, environ []string) (problems []string) {
+	problems = unknownConfigKeys(config)
+	keys := make([]string, 0, len(config))
+	for key := range config {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		values, err := configValues(config[key])
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("config %s: %s", key, err))
+			continue
+		}
+		for _, flags := range allFlagSets() {
+			if flags.Lookup(key) == nil {
+				continue
+			}
+			if err := setFlag(flags, key, values); err != nil {
+				problems = append(problems, fmt.Sprintf("config %s: %s", key, err))
+			}
+			break
+		}
+	}
+
+	envFlags := make(map[string]string)
+	for _, flags := range allFlagSets() {
+		flags.VisitAll(func(f *flag.Flag) {
+			envFlags[envName(f.Name)] = f.Name
+		})
+	}
+	for _, env := range environ {
+		parts := strings.SplitN(env, "=", 2)
+		if !strings.HasPrefix(parts[0], configEnvPrefix) || len(parts) != 2 {
+			continue
+		}
+		name, ok := envFlags[parts[0]]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("unknown environment variable %s", parts[0]))
+			continue
+		}
+		for _, flags := range allFlagSets() {
+			if flags.Lookup(name) == nil {
+				continue
+			}
+			if err := setFlag(flags, name, strings.Split(parts[1], ",")); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %s", parts[0], err))
+			}
+			break
+		}
+	}
+	return problems
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEnvName(t *testing.T) {
+	compareString(envName("nodelabel"), "K8SCAPCITY_NODELABEL", t)
+	compareString(envName("history-retention"), "K8SCAPCITY_HISTORY_RETENTION", t)
+	compareString(envName("k8s.quota"), "K8SCAPCITY_K8S_QUOTA", t)
+}
+
+func TestApplyConfigPrecedence(t *testing.T) {
+	flags := flag.NewFlagSet("daemon", flag.ContinueOnError)
+	settings := addDaemonSettingsFlags(flags, "")
+	err := flags.Parse([]string{"-nodelabel", "role=flag"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("K8SCAPCITY_INTERVAL", "1m")
+	os.Setenv("K8SCAPCITY_AS_GROUP", "env-a,env-b")
+	defer os.Unsetenv("K8SCAPCITY_INTERVAL")
+	defer os.Unsetenv("K8SCAPCITY_AS_GROUP")
+	config := configFile{
+		"nodelabel":          "role=file",
+		"interval":           "2m",
+		"jitter":             "5s",
+		"watch":              false,
+		"forecast-threshold": 0.25,
+		"as-group":           []interface{}{"file-a"},
+	}
+	err = applyConfig(flags, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareString(settings.cluster.nodeLabel, "role=flag", t)
+	compareString(settings.daemon.interval.String(), time.Minute.String(), t)
+	compareString(settings.daemon.jitter.String(), (5 * time.Second).String(), t)
+	compareString(strings.Join(settings.cluster.kubeconfig.asGroups, ","), "env-a,env-b", t)
+	if settings.daemon.watch || settings.forecast.threshold != 0.25 {
+		t.Errorf("Expected watch false and threshold 0.25 from the file, got %t and %v", settings.daemon.watch, settings.forecast.threshold)
+	}
+	// Defaults stay where nothing is set
+	compareString(settings.daemon.historyRetention, "30d", t)
+}
+
+func TestApplyConfigBadValue(t *testing.T) {
+	flags := flag.NewFlagSet("daemon", flag.ContinueOnError)
+	addDaemonSettingsFlags(flags, "")
+	err := applyConfig(flags, configFile{"interval": "often"})
+	if err == nil || !strings.Contains(err.Error(), "config interval") {
+		t.Errorf("Expected an interval error, got %v", err)
+	}
+	err = applyConfig(flags, configFile{"nodelabel": map[string]interface{}{"role": "compute"}})
+	if err == nil {
+		t.Error("Expected an error for a nested value")
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	file, err := ioutil.TempFile("", "k8sCapcity-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString("nodelabel: role=compute\nwide: true\ncontexts: [prod-*, staging]\n")
+	file.Close()
+
+	// wide and contexts belong to other commands, and are left alone
+	c, settings := newDaemonCommand()
+	err = c.parse([]string{"-config", file.Name()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareString(settings.cluster.nodeLabel, "role=compute", t)
+
+	os.Setenv("K8SCAPCITY_CONFIG", file.Name())
+	defer os.Unsetenv("K8SCAPCITY_CONFIG")
+	c, settings = newDaemonCommand()
+	err = c.parse(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareString(settings.cluster.nodeLabel, "role=compute", t)
+
+	ioutil.WriteFile(file.Name(), []byte("node-label: role=compute\n"), 0644)
+	err = daemonCommand().parse(nil)
+	if err == nil || !strings.Contains(err.Error(), `unknown config key "node-label"`) {
+		t.Errorf("Expected an unknown key error, got %v", err)
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	config := configFile{
+		"nodelabel":   "role=compute",
+		"interval":    "often",
+		"replicas":    float64(3),
+		"node-label":  "role=compute",
+		"parallelism": "four",
+	}
+	problems := validateConfig(config, []string{"K8SCAPCITY_NODELABEL=role=compute", "K8SCAPCITY_JITTER=soon", "K8SCAPCITY_NODE_LABEL=x", "HOME=/root"})
+	expected := []string{
+		`unknown config key "node-label"`,
+		"config interval: ",
+		"config parallelism: ",
+		"K8SCAPCITY_JITTER: ",
+		"unknown environment variable K8SCAPCITY_NODE_LABEL",
+	}
+	if len(problems) != len(expected) {
+		t.Fatalf("Expected %d problems, got %q", len(expected), problems)
+	}
+	for i, problem := range problems {
+		if !strings.HasPrefix(problem, expected[i]) {
+			t.Errorf("Expected %q, got %q", expected[i], problem)
+		}
+	}
+	if problems := validateConfig(configFile{"nodelabel": "role=compute", "contexts": "prod-*"}, nil); len(problems) != 0 {
+		t.Errorf("Expected no problems, got %q", problems)
+	}
+}
+
+func TestConfigValidateCommand(t *testing.T) {
+	file, err := ioutil.TempFile("", "k8sCapcity-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString("nodelabel: role=compute\ninterval: 10m\n")
+	file.Close()
+	if code := runCommand([]string{"config", "validate", "-config", file.Name()}); code != 0 {
+		t.Errorf("Expected a valid config, got %d", code)
+	}
+	ioutil.WriteFile(file.Name(), []byte("interval: 10\n"), 0644)
+	if code := runCommand([]string{"config", "validate", "-config", file.Name()}); code != 1 {
+		t.Errorf("Expected an invalid config, got %d", code)
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// daemon holds what carries over from one daemon cycle to the next
+type daemon struct {
+	collect   func(ctx context.Context) ClusterInfo
+	nodeLabel *string
+	poolLabel string
+	forecasts func() []Forecast
+	history   *historyStore
+	alerts    *alertEngine
+	notify    *notifier
+	// watch is nil with -watch=false
+	watch *watchCache
+
+	sched        schedule
+	jitter       time.Duration
+	cycleTimeout time.Duration
+	nodeEvents   bool
+	eventLabels  []string
+
+	elasticsearch *bulkSink
+	metricSinks   []metricSink
+	reports       *capacityReporter
+	health        *healthState
+	api           *apiServer
+	// leader is nil without -leader-elect, every replica then leads
+	leader *leaderElection
+}
+
+// replace stops what next no longer uses
+func (d *daemon) replace(next *daemon) {
+	if d.watch != nil && next.watch == nil {
+		d.watch.stop()
+	}
+	if d.notify != nil && d.notify != next.notify {
+		d.notify.stop()
+	}
+}
+
+// cycle gives up before emitting anything once ctx is done
+func (d *daemon) cycle(ctx context.Context) {
+	if d.leader != nil && !d.leader.leading() {
+		d.follow()
+		return
+	}
+	clusterInfo := d.health.track(func() ClusterInfo {
+		return d.collect(ctx)
+	})
+	check(ctx.Err())
+	capCity := calculateCapcity(clusterInfo)
+	if d.history != nil {
+		err := d.history.record(newHistoryRecord(clusterInfo, capCity, d.poolLabel, time.Now()))
+		if err != nil {
+			log.Errorf("Unable to record history to %s, Error: %s", d.history.path, err)
+		}
+		applyForecast(&capCity, d.forecasts(), *d.nodeLabel)
+	}
+	printCapcity(capCity)
+	events := []interface{}{eventObject(capCity)}
+	if d.nodeEvents {
+		for _, node := range nodeEvents(clusterInfo, d.eventLabels) {
+			printJSON(node)
+			events = append(events, node)
+		}
+	}
+	now := time.Now()
+	state := newAPIState(clusterInfo, capCity, nil, d.eventLabels, now)
+	d.api.set(state)
+	if d.leader != nil {
+		if err := d.leader.writeSnapshot(state); err != nil {
+			log.Errorf("Unable to write the snapshot for standbys to %s/%s, Error: %s", d.leader.namespace, d.leader.snapshot, err)
+		}
+	}
+	var alertEvents []AlertEvent
+	if d.alerts != nil {
+		alertEvents = d.alerts.evaluate(capCity, now)
+		printAlertEvents(alertEvents)
+		for _, event := range alertEvents {
+			events = append(events, event)
+		}
+	}
+	if d.elasticsearch != nil {
+		if err := d.elasticsearch.ship(events); err != nil {
+			log.Errorf("Unable to ship events to %s, Error: %s", d.elasticsearch.endpoint, err)
+		}
+	}
+	if len(d.metricSinks) > 0 {
+		groups := capacityMetrics(clusterInfo, capCity)
+		for _, sink := range d.metricSinks {
+			if err := sink.write(groups, now); err != nil {
+				log.Errorf("Unable to write metrics to %s, Error: %s", sink, err)
+			}
+		}
+	}
+	if d.reports != nil {
+		if err := d.reports.write(clusterInfo, now); err != nil {
+			log.Errorf("Unable to write capacity reports, Error: %s", err)
+		}
+	}
+	if d.notify != nil {
+		d.notify.notifyAlerts(alertEvents, now)
+		d.notify.notifySummary(capCity, now)
+	}
+}
+
+// leaderElected fires when this replica starts leading, never without -leader-elect
+func (d *daemon) leaderElected() <-chan struct{} {
+	if d.leader == nil {
+		return nil
+	}
+	return d.leader.started
+}
+
+// follow is a standby's cycle, it emits nothing and serves what the leader
+// last collected. Reading the snapshot counts as the collection for /readyz
+func (d *daemon) follow() {
+	var state *apiState
+	d.health.track(func() ClusterInfo {
+		var err error
+		state, err = d.leader.readSnapshot(d.eventLabels)
+		check(err)
+		return state.clusterInfo
+	})
+	d.api.set(state)
+}
+
+// finish waits for the cycle in progress and for queued notifications
+func (d *daemon) finish(runner *cycleRunner) {
+	runner.wait()
+	if d.notify != nil {
+		d.notify.flush()
+	}
+}
+
+// runDaemon runs a cycle now and then whenever the schedule says, until
+// SIGTERM or SIGINT. A cycle in progress and queued notifications are
+// allowed to finish before returning. SIGHUP swaps in the daemon reload builds, keeping the old one
+// when that fails
+func runDaemon(d *daemon, reload func(previous *daemon) (*daemon, error)) {
+	random := rand.New(rand.NewSource(time.Now().UnixNano()))
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGTERM, os.Interrupt)
+	defer signal.Stop(stop)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	runner := newCycleRunner(d.cycleTimeout)
+	start := time.Now()
+	for {
+		err := runner.run(d.cycle)
+		if err != nil {
+			log.Errorf("Daemon cycle started at %s: %s", start.Format(time.RFC3339), err)
+		}
+		for {
+			next := d.sched.next(start)
+			if next.IsZero() {
+				log.Error("Schedule has no further runs, exiting")
+				d.finish(runner)
+				return
+			}
+			// Skip runs we are already late for rather than bunching them up
+			for !next.After(time.Now()) {
+				next = d.sched.next(next)
+			}
+			timer := time.NewTimer(time.Until(next) + jitterDelay(random, d.jitter))
+			select {
+			case sig := <-stop:
+				timer.Stop()
+				log.Infof("Received %s, waiting for the current cycle and notifications to finish", sig)
+				d.finish(runner)
+				os.Stdout.Sync()
+				return
+			case <-hup:
+				// Wait again, on the new schedule. An overrunning cycle
+				// finishes first, it still uses the alert states
+				timer.Stop()
+				runner.wait()
+				reloaded, err := reload(d)
+				if err != nil {
+					log.Errorf("Unable to reload, keeping the current settings, Error: %s", err)
+					continue
+				}
+				log.Info("Reloaded settings")
+				d.replace(reloaded)
+				d = reloaded
+				runner.timeout = d.cycleTimeout
+				continue
+			case <-d.leaderElected():
+				timer.Stop()
+				start = time.Now()
+			case <-timer.C:
+				start = next
+			}
+			break
+		}
+	}
+}
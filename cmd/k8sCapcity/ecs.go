@@ -0,0 +1,31 @@
+package main
+
+import (
+	"time"
+)
+
+// ecsVersion is the Elastic Common Schema version the events follow
+const ecsVersion = "1.12.0"
+
+// ECS : The Elastic Common Schema fields every flat event starts with
+type ECS struct {
+	Timestamp               string `json:"@timestamp" description:"RFC3339 time the figures were collected"`
+	ECSVersion              string `json:"ecs.version" description:"Elastic Common Schema version the event follows"`
+	EventDataset            string `json:"event.dataset" description:"k8s_quota.cluster, k8s_quota.node or k8s_quota.alert"`
+	OrchestratorType        string `json:"orchestrator.type" description:"Always kubernetes"`
+	OrchestratorClusterName string `json:"orchestrator.cluster.name,omitempty" description:"-cluster-name, else the kubeconfig context with -contexts"`
+}
+
+func newECS(dataset, clusterName string, now time.Time) ECS {
+	// -cluster-name when the event has no cluster name of its own
+	if clusterName == "" {
+		clusterName = currentSettings().clusterName
+	}
+	return ECS{
+		Timestamp:               now.UTC().Format(time.RFC3339Nano),
+		ECSVersion:              ecsVersion,
+		EventDataset:            dataset,
+		OrchestratorType:        "kubernetes",
+		OrchestratorClusterName: clusterName,
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// elasticsearchOptions : Where and how the daemon ships its events with _bulk
+type elasticsearchOptions struct {
+	url       string
+	index     string
+	username  string
+	password  string
+	apiKey    string
+	batchSize int
+	retries   int
+	timeout   time.Duration
+}
+
+func addElasticsearchFlags(flags *flag.FlagSet) *elasticsearchOptions {
+	o := &elasticsearchOptions{}
+	flags.StringVar(&o.url, "elasticsearch-url", "", "In daemon mode, also send every event to the _bulk endpoint of this Elasticsearch compatible url, e.g. http://elasticsearch:9200")
+	flags.StringVar(&o.index, "elasticsearch-index", "k8scapcity", "Index or data stream events are created in")
+	flags.StringVar(&o.username, "elasticsearch-username", "", "Basic auth username for -elasticsearch-url")
+	flags.StringVar(&o.password, "elasticsearch-password", "", "Basic auth password for -elasticsearch-url, K8SCAPCITY_ELASTICSEARCH_PASSWORD keeps it off the command line")
+	flags.StringVar(&o.apiKey, "elasticsearch-api-key", "", "Base64 api key for -elasticsearch-url, instead of basic auth")
+	flags.IntVar(&o.batchSize, "elasticsearch-batch-size", 500, "Most events in one _bulk request")
+	flags.IntVar(&o.retries, "elasticsearch-retries", 3, "Retries of a _bulk request, or of the events in it, rejected with 429 or 5xx")
+	flags.DurationVar(&o.timeout, "elasticsearch-timeout", 30*time.Second, "Give up on a _bulk request after this long")
+	return o
+}
+
+func (o *elasticsearchOptions) validate() error {
+	if o.url == "" {
+		return nil
+	}
+	u, err := url.Parse(o.url)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("-elasticsearch-url %q is not an http or https url", o.url)
+	}
+	if o.batchSize < 1 || o.retries < 0 {
+		return fmt.Errorf("-elasticsearch-batch-size must be at least 1 and -elasticsearch-retries cannot be negative")
+	}
+	if o.apiKey != "" && o.username != "" {
+		return fmt.Errorf("-elasticsearch-api-key and -elasticsearch-username cannot be used together")
+	}
+	return nil
+}
+
+// bulkSink : Ships events to an Elasticsearch compatible _bulk endpoint in batches
+type bulkSink struct {
+	elasticsearchOptions
+	endpoint string
+	backoff  time.Duration
+	client   *http.Client
+	sleep    func(time.Duration)
+}
+
+func newBulkSink(o elasticsearchOptions) *bulkSink {
+	return &bulkSink{
+		elasticsearchOptions: o,
+		endpoint:             strings.TrimSuffix(o.url, "/") + "/_bulk",
+		backoff:              time.Second,
+		client:               &http.Client{Timeout: o.timeout},
+		sleep:                time.Sleep,
+	}
+}
+
+// bulkResponse : The parts of a _bulk response that say which items failed
+type bulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		Status int             `json:"status"`
+		Error  json.RawMessage `json:"error,omitempty"`
+	} `json:"items"`
+}
+
+// ship sends events batchSize at a time. Rejected requests, and events
+// rejected with 429 or 5xx, are retried with backoff. Events that still
+// fail, or are rejected otherwise (a 400 mapping conflict), are dropped and
+// counted in the error
+func (s *bulkSink) ship(events []interface{}) error {
+	var documents [][]byte
+	for _, event := range events {
+		document, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		documents = append(documents, document)
+	}
+	dropped := 0
+	var lastErr error
+	for start := 0; start < len(documents); start += s.batchSize {
+		end := start + s.batchSize
+		if end > len(documents) {
+			end = len(documents)
+		}
+		n, err := s.sendBatch(documents[start:end])
+		if err != nil {
+			dropped += n
+			lastErr = err
+		}
+	}
+	if lastErr != nil {
+		return fmt.Errorf("dropped %d of %d events, %s", dropped, len(documents), lastErr)
+	}
+	return nil
+}
+
+// sendBatch returns how many documents could not be indexed, those
+// Elasticsearch rejected for good included
+func (s *bulkSink) sendBatch(documents [][]byte) (int, error) {
+	backoff := s.backoff
+	rejected := 0
+	for attempt := 0; ; attempt++ {
+		retry, n, err := s.post(documents)
+		rejected += n
+		if len(retry) == 0 {
+			if err != nil {
+				return rejected + len(documents), err
+			}
+			if rejected > 0 {
+				return rejected, fmt.Errorf("%d events rejected", rejected)
+			}
+			return 0, nil
+		}
+		if err == nil {
+			err = fmt.Errorf("%d events rejected", len(retry))
+		}
+		documents = retry
+		if attempt >= s.retries {
+			return rejected + len(documents), err
+		}
+		log.Warnf("Elasticsearch _bulk failed (attempt %d), retrying %d events in %s, Error: %s", attempt+1, len(documents), backoff, err)
+		s.sleep(backoff)
+		backoff = backoff * 2
+	}
+}
+
+func bulkBody(index string, documents [][]byte) []byte {
+	action, _ := json.Marshal(map[string]map[string]string{"create": {"_index": index}})
+	body := &bytes.Buffer{}
+	for _, document := range documents {
+		body.Write(action)
+		body.WriteByte('\n')
+		body.Write(document)
+		body.WriteByte('\n')
+	}
+	return body.Bytes()
+}
+
+// post sends documents in one request and returns those worth sending again,
+// and how many were rejected in a way sending again does not help. An error
+// means the whole request failed
+func (s *bulkSink) post(documents [][]byte) (retry [][]byte, rejected int, err error) {
+	req, err := http.NewRequest(http.MethodPost, s.endpoint, bytes.NewReader(bulkBody(s.index, documents)))
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	req.Header.Set("User-Agent", "k8sCapcity")
+	if s.apiKey != "" {
+		req.Header.Set("Authorization", "ApiKey "+s.apiKey)
+	} else if s.username != "" {
+		req.SetBasicAuth(s.username, s.password)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return documents, 0, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return documents, 0, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		err = fmt.Errorf("%s returned %s", s.endpoint, resp.Status)
+		if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+			return documents, 0, err
+		}
+		return nil, 0, err
+	}
+	var result bulkResponse
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, 0, fmt.Errorf("unable to parse the _bulk response, %s", err)
+	}
+	if !result.Errors {
+		return nil, 0, nil
+	}
+	for i, item := range result.Items {
+		for _, status := range item {
+			switch {
+			case status.Status < 300:
+			case (status.Status >= 500 || status.Status == http.StatusTooManyRequests) && i < len(documents):
+				retry = append(retry, documents[i])
+			default:
+				rejected++
+				log.Errorf("Elasticsearch rejected an event with %d, Error: %s", status.Status, status.Error)
+			}
+		}
+	}
+	return retry, rejected, nil
+}
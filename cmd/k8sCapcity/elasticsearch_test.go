@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// bulkStandIn answers _bulk requests with the next of responses, then with success
+type bulkStandIn struct {
+	sync.Mutex
+	responses []func(w http.ResponseWriter, lines []string)
+	requests  [][]string
+	auth      string
+	server    *httptest.Server
+}
+
+func newBulkStandIn(responses ...func(w http.ResponseWriter, lines []string)) *bulkStandIn {
+	s := &bulkStandIn{responses: responses}
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.Lock()
+		defer s.Unlock()
+		body, _ := ioutil.ReadAll(r.Body)
+		lines := strings.Split(strings.TrimSuffix(string(body), "\n"), "\n")
+		s.requests = append(s.requests, lines)
+		s.auth = r.Header.Get("Authorization")
+		if r.URL.Path != "/_bulk" || r.Header.Get("Content-Type") != "application/x-ndjson" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if len(s.responses) > 0 {
+			respond := s.responses[0]
+			s.responses = s.responses[1:]
+			respond(w, lines)
+			return
+		}
+		fmt.Fprint(w, `{"errors":false,"items":[]}`)
+	}))
+	return s
+}
+
+func testBulkSink(url string, batchSize int) *bulkSink {
+	s := newBulkSink(elasticsearchOptions{url: url + "/", index: "capacity", batchSize: batchSize, retries: 2, timeout: time.Second})
+	s.sleep = func(time.Duration) {}
+	return s
+}
+
+func TestBulkSinkBatches(t *testing.T) {
+	standIn := newBulkStandIn()
+	defer standIn.server.Close()
+	s := testBulkSink(standIn.server.URL, 2)
+	s.username, s.password = "elastic", "changeme"
+	err := s.ship([]interface{}{map[string]int{"n": 1}, map[string]int{"n": 2}, map[string]int{"n": 3}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(standIn.requests) != 2 || len(standIn.requests[0]) != 4 || len(standIn.requests[1]) != 2 {
+		t.Fatalf("Expected batches of 2 and 1 events, got %q", standIn.requests)
+	}
+	compareString(standIn.requests[0][0], `{"create":{"_index":"capacity"}}`, t)
+	compareString(standIn.requests[1][1], `{"n":3}`, t)
+	compareString(standIn.auth, "Basic ZWxhc3RpYzpjaGFuZ2VtZQ==", t)
+}
+
+func TestBulkSinkRetries(t *testing.T) {
+	unavailable := func(w http.ResponseWriter, lines []string) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	// The second event is rejected with 429, the first one indexed
+	partial := func(w http.ResponseWriter, lines []string) {
+		fmt.Fprint(w, `{"errors":true,"items":[{"create":{"status":201}},{"create":{"status":429,"error":{"type":"es_rejected_execution_exception"}}}]}`)
+	}
+	standIn := newBulkStandIn(unavailable, partial)
+	defer standIn.server.Close()
+	s := testBulkSink(standIn.server.URL, 10)
+	err := s.ship([]interface{}{map[string]int{"n": 1}, map[string]int{"n": 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(standIn.requests) != 3 || len(standIn.requests[2]) != 2 || standIn.requests[2][1] != `{"n":2}` {
+		t.Fatalf("Expected the whole batch twice, then the rejected event, got %q", standIn.requests)
+	}
+
+	standIn = newBulkStandIn(unavailable, unavailable, unavailable)
+	defer standIn.server.Close()
+	err = testBulkSink(standIn.server.URL, 10).ship([]interface{}{1, 2})
+	if err == nil || !strings.HasPrefix(err.Error(), "dropped 2 of 2 events") || len(standIn.requests) != 3 {
+		t.Errorf("Expected 2 dropped events after 3 attempts, got %v after %d", err, len(standIn.requests))
+	}
+
+	// A mapping conflict is dropped without a retry, and counted
+	standIn = newBulkStandIn(func(w http.ResponseWriter, lines []string) {
+		fmt.Fprint(w, `{"errors":true,"items":[{"create":{"status":400,"error":{"type":"mapper_parsing_exception"}}},{"create":{"status":201}}]}`)
+	})
+	defer standIn.server.Close()
+	err = testBulkSink(standIn.server.URL, 10).ship([]interface{}{1, 2})
+	if err == nil || !strings.HasPrefix(err.Error(), "dropped 1 of 2 events") || len(standIn.requests) != 1 {
+		t.Errorf("Expected 1 dropped event after 1 attempt, got %v after %d", err, len(standIn.requests))
+	}
+
+	standIn = newBulkStandIn(func(w http.ResponseWriter, lines []string) {
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+	defer standIn.server.Close()
+	err = testBulkSink(standIn.server.URL, 10).ship([]interface{}{1})
+	if err == nil || len(standIn.requests) != 1 {
+		t.Errorf("Expected no retry of a 401, got %v after %d", err, len(standIn.requests))
+	}
+}
+
+func TestECSFields(t *testing.T) {
+	defer setSettings(setSettings(runSettings{parallelism: 4, eventVersion: 1, clusterName: "prod"}))
+	capCity := calculateCapcity(schemaTestCluster())
+	data, _ := json.Marshal(capCity)
+	var event map[string]interface{}
+	json.Unmarshal(data, &event)
+	for field, expected := range map[string]string{"ecs.version": ecsVersion, "event.dataset": "k8s_quota.cluster",
+		"orchestrator.type": "kubernetes", "orchestrator.cluster.name": "prod"} {
+		compareString(fmt.Sprint(event[field]), expected, t)
+	}
+	if _, err := time.Parse(time.RFC3339Nano, capCity.Timestamp); err != nil {
+		t.Errorf("Expected an RFC3339 @timestamp, got %q", capCity.Timestamp)
+	}
+	compareString(capacityEvent(capCity).Orchestrator.Cluster.Name, "prod", t)
+	node := nodeEvents(schemaTestCluster(), nil)[0]
+	compareString(node.HostName+" "+node.EventDataset, "node-a k8s_quota.node", t)
+}
+
+func TestElasticsearchOptionsValidate(t *testing.T) {
+	for _, o := range []elasticsearchOptions{
+		{url: "elasticsearch:9200", batchSize: 1},
+		{url: "http://elasticsearch:9200", batchSize: 0},
+		{url: "http://elasticsearch:9200", batchSize: 1, apiKey: "key", username: "elastic"},
+	} {
+		if o.validate() == nil {
+			t.Errorf("Expected %+v to be invalid", o)
+		}
+	}
+	o := elasticsearchOptions{url: "http://elasticsearch:9200", batchSize: 1}
+	compareString(fmt.Sprint(o.validate()), "<nil>", t)
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// eventVersionV1 is the event.version of Capcity, the flat dotted event.
+// 03/06/2020-02 added the ECS fields
+const eventVersionV1 = "03/06/2020-02"
+
+// eventVersionV2 is the event.version of CapacityEvent, the nested event.
+// 2.1 added the ECS fields
+const eventVersionV2 = "2.1"
+
+// eventVersionFlag : -event-version, 1 for Capcity or 2 for CapacityEvent
+type eventVersionFlag int
+
+func (v *eventVersionFlag) String() string {
+	return strconv.Itoa(int(*v))
+}
+
+func (v *eventVersionFlag) Set(value string) error {
+	switch value {
+	case "1":
+		*v = 1
+	case "2":
+		*v = 2
+	default:
+		return fmt.Errorf("unknown event version %s, use 1 or 2", value)
+	}
+	return nil
+}
+
+// CapacityEvent : The v2 capacity event, the Capcity fields nested by what
+// they measure with the units in their names
+type CapacityEvent struct {
+	Timestamp    string        `json:"@timestamp" description:"RFC3339 time the figures were collected"`
+	ECS          ECSMeta       `json:"ecs" description:"Elastic Common Schema the event follows"`
+	Event        EventMeta     `json:"event" description:"What kind of event this is"`
+	Orchestrator Orchestrator  `json:"orchestrator" description:"The cluster the figures are from"`
+	K8sQuota     CapacityQuota `json:"k8s_quota" description:"Capacity of the selected nodes"`
+}
+
+// ECSMeta : The ecs field set
+type ECSMeta struct {
+	Version string `json:"version" description:"Elastic Common Schema version the event follows"`
+}
+
+// Orchestrator : The orchestrator field set
+type Orchestrator struct {
+	Type    string               `json:"type" description:"Always kubernetes"`
+	Cluster *OrchestratorCluster `json:"cluster,omitempty" description:"Only when the cluster has a name"`
+}
+
+// OrchestratorCluster : The orchestrator.cluster field set
+type OrchestratorCluster struct {
+	Name string `json:"name" description:"-cluster-name, else the kubeconfig context with -contexts"`
+}
+
+// EventMeta : The event fields every k8sCapcity event starts with
+type EventMeta struct {
+	Kind     string `json:"kind" description:"Always metric"`
+	Module   string `json:"module" description:"Always k8s_quota"`
+	Dataset  string `json:"dataset" description:"Always k8s_quota.cluster"`
+	Provider string `json:"provider" description:"Always k8sCapcity"`
+	Type     string `json:"type" description:"Always info"`
+	Version  string `json:"version" description:"Version of this event's fields, see the schema $id"`
+}
+
+// CapacityQuota : Everything the v2 event reports about the selected nodes
+type CapacityQuota struct {
+	NodeLabel          string          `json:"node_label" description:"The -nodelabel the nodes were selected with, blank for every node"`
+	ClusterName        string          `json:"cluster_name,omitempty" description:"Kubeconfig context the event was collected from, fleet for the roll-up"`
+	Fleet              *FleetMembers   `json:"fleet,omitempty" description:"Fleet roll-up only, which clusters it adds up"`
+	Allocatable        ResourceTotals  `json:"allocatable" description:"What kubernetes schedules pods into"`
+	ResourceQuota      ResourceAmounts `json:"resource_quota" description:"Handed out by every ResourceQuota"`
+	ContainerResource  ResourceAmounts `json:"container_resource" description:"Requested by non-terminated pods on the selected nodes"`
+	UtilizationFactor  FactorTotals    `json:"utilization_factor" description:"Pod requests / allocatable, 0-1"`
+	SubscriptionFactor FactorTotals    `json:"subscription_factor" description:"ResourceQuota / allocatable, 2 is a full blue/green cluster"`
+	Available          ResourceTotals  `json:"available" description:"Allocatable not requested by pods"`
+	Forecast           *ForecastTotals `json:"forecast,omitempty" description:"When available N-1 is projected to run out, only with -history"`
+}
+
+// FleetMembers : The clusters a fleet roll-up adds up
+type FleetMembers struct {
+	Clusters       []string `json:"clusters,omitempty" description:"Clusters added up into the roll-up"`
+	FailedClusters []string `json:"failed_clusters,omitempty" description:"Clusters that could not be collected and are left out"`
+}
+
+// ResourceTotals : Cpu, memory and pods, cluster wide and N-1
+type ResourceTotals struct {
+	CPUCores    TotalAndNminusone `json:"cpu_cores" description:"Cores of cpu"`
+	MemoryBytes TotalAndNminusone `json:"memory_bytes" description:"Bytes of memory"`
+	Pods        TotalAndNminusone `json:"pods" description:"Pods"`
+}
+
+// TotalAndNminusone : One amount on every selected node and without the largest one
+type TotalAndNminusone struct {
+	Total     int64 `json:"total" description:"On every selected node"`
+	Nminusone int64 `json:"nminusone" description:"Without the largest node"`
+}
+
+// ResourceAmounts : Requests and limits added up
+type ResourceAmounts struct {
+	CPURequestCores      int64 `json:"cpu_request_cores" description:"Cores of requests.cpu"`
+	CPURequestMilliCores int64 `json:"cpu_request_millicores" description:"Millicores of requests.cpu"`
+	MemoryRequestBytes   int64 `json:"memory_request_bytes" description:"Bytes of requests.memory"`
+	MemoryLimitBytes     int64 `json:"memory_limit_bytes" description:"Bytes of limits.memory"`
+	Pods                 int64 `json:"pods" description:"Pods"`
+}
+
+// FactorTotals : A ratio for cpu requests, memory requests and pods
+type FactorTotals struct {
+	CPURequest    Factor `json:"cpu_request" description:"Of cpu requests"`
+	MemoryRequest Factor `json:"memory_request" description:"Of memory requests"`
+	Pods          Factor `json:"pods" description:"Of pods"`
+}
+
+// Factor : One ratio cluster wide, N-1 and per node
+type Factor struct {
+	Total     float64            `json:"total" description:"Against every selected node"`
+	Nminusone float64            `json:"nminusone" description:"Against every selected node but the largest"`
+	Nodes     map[string]float64 `json:"nodes,omitempty" description:"Against each node, by node name"`
+}
+
+// ForecastTotals : Projected N-1 exhaustion of cpu requests, memory requests and pods
+type ForecastTotals struct {
+	CPURequest    *ForecastNminusone `json:"cpu_request,omitempty" description:"Of cpu requests"`
+	MemoryRequest *ForecastNminusone `json:"memory_request,omitempty" description:"Of memory requests"`
+	Pods          *ForecastNminusone `json:"pods,omitempty" description:"Of pods"`
+}
+
+// ForecastNminusone : When available N-1 is projected to cross -forecast-threshold
+type ForecastNminusone struct {
+	Exhaustion string   `json:"exhaustion" description:"RFC3339 projected date"`
+	Earliest   string   `json:"earliest,omitempty" description:"RFC3339 earliest date within the confidence range"`
+	Latest     string   `json:"latest,omitempty" description:"RFC3339 latest date within the confidence range, absent when it may never cross"`
+	Days       *float64 `json:"days,omitempty" description:"Days from the last sample to the projected exhaustion"`
+}
+
+func forecastNminusone(exhaustion, earliest, latest string, days *float64) *ForecastNminusone {
+	if exhaustion == "" {
+		return nil
+	}
+	return &ForecastNminusone{Exhaustion: exhaustion, Earliest: earliest, Latest: latest, Days: days}
+}
+
+// capacityEvent is capCity as a v2 event
+func capacityEvent(capCity Capcity) CapacityEvent {
+	quota := CapacityQuota{
+		NodeLabel:   capCity.NodeLabel,
+		ClusterName: capCity.ClusterName,
+		Allocatable: ResourceTotals{
+			CPUCores:    TotalAndNminusone{capCity.AllocatableCPUTotal, capCity.AllocatableCPUNminusone},
+			MemoryBytes: TotalAndNminusone{capCity.AllocatableMemoryTotal, capCity.AllocatableMemoryNminusone},
+			Pods:        TotalAndNminusone{capCity.AllocatablePodsTotal, capCity.AllocatablePodsNminusone},
+		},
+		ResourceQuota: ResourceAmounts{
+			CPURequestCores:      capCity.ResourceQuotaCPURequestCores,
+			CPURequestMilliCores: capCity.ResourceQuotaCPURequestMilliCores,
+			MemoryRequestBytes:   capCity.ResourceQuotaMemoryRequest,
+			MemoryLimitBytes:     capCity.ResourceQuotaMemoryLimit,
+			Pods:                 capCity.ResourceQuotaPods,
+		},
+		ContainerResource: ResourceAmounts{
+			CPURequestCores:      capCity.ContainerResourceCPURequestCores,
+			CPURequestMilliCores: capCity.ContainerResourceCPURequestMilliCores,
+			MemoryRequestBytes:   capCity.ContainerResourceMemoryRequest,
+			MemoryLimitBytes:     capCity.ContainerResourceMemoryLimit,
+			Pods:                 capCity.ContainerResourcePods,
+		},
+		UtilizationFactor: FactorTotals{
+			CPURequest:    Factor{capCity.UtilizationFactorCPURequestsTotal, capCity.UtilizationFactorCPURequestsNminusone, capCity.UtilizationFactorCPURequests},
+			MemoryRequest: Factor{capCity.UtilizationFactorMemoryRequestsTotal, capCity.UtilizationFactorMemoryRequestsNminusone, capCity.UtilizationFactorMemoryRequests},
+			Pods:          Factor{capCity.UtilizationFactorPodsTotal, capCity.UtilizationFactorPodsNminusone, capCity.UtilizationFactorPods},
+		},
+		SubscriptionFactor: FactorTotals{
+			CPURequest:    Factor{Total: capCity.SubscriptionFactorCPURequestTotal, Nminusone: capCity.SubscriptionFactorCPURequestNminusone},
+			MemoryRequest: Factor{Total: capCity.SubscriptionFactorMemoryRequestTotal, Nminusone: capCity.SubscriptionFactorMemoryRequestNminusone},
+			Pods:          Factor{Total: capCity.SubscriptionFactorPodsTotal, Nminusone: capCity.SubscriptionFactorPodsNminusone},
+		},
+		Available: ResourceTotals{
+			CPUCores:    TotalAndNminusone{capCity.AvailableCPURequestTotal, capCity.AvailableCPURequestNminusone},
+			MemoryBytes: TotalAndNminusone{capCity.AvailableMemoryRequestTotal, capCity.AvailableMemoryRequestNminusone},
+			Pods:        TotalAndNminusone{capCity.AvailablePodsTotal, capCity.AvailablePodsNminusone},
+		},
+	}
+	if len(capCity.FleetClusters) > 0 || len(capCity.FleetFailedClusters) > 0 {
+		quota.Fleet = &FleetMembers{Clusters: capCity.FleetClusters, FailedClusters: capCity.FleetFailedClusters}
+	}
+	forecast := ForecastTotals{
+		CPURequest: forecastNminusone(capCity.ForecastCPURequestNminusoneExhaustion, capCity.ForecastCPURequestNminusoneEarliest,
+			capCity.ForecastCPURequestNminusoneLatest, capCity.ForecastCPURequestNminusoneDays),
+		MemoryRequest: forecastNminusone(capCity.ForecastMemoryRequestNminusoneExhaustion, capCity.ForecastMemoryRequestNminusoneEarliest,
+			capCity.ForecastMemoryRequestNminusoneLatest, capCity.ForecastMemoryRequestNminusoneDays),
+		Pods: forecastNminusone(capCity.ForecastPodsNminusoneExhaustion, capCity.ForecastPodsNminusoneEarliest,
+			capCity.ForecastPodsNminusoneLatest, capCity.ForecastPodsNminusoneDays),
+	}
+	if forecast.CPURequest != nil || forecast.MemoryRequest != nil || forecast.Pods != nil {
+		quota.Forecast = &forecast
+	}
+	event := CapacityEvent{
+		Timestamp: capCity.Timestamp,
+		ECS:       ECSMeta{Version: capCity.ECSVersion},
+		Event: EventMeta{Kind: capCity.EventKind, Module: capCity.EventModule, Dataset: capCity.EventDataset,
+			Provider: capCity.EventProvider, Type: capCity.EventType, Version: eventVersionV2},
+		Orchestrator: Orchestrator{Type: capCity.OrchestratorType},
+		K8sQuota:     quota,
+	}
+	if capCity.OrchestratorClusterName != "" {
+		event.Orchestrator.Cluster = &OrchestratorCluster{Name: capCity.OrchestratorClusterName}
+	}
+	return event
+}
+
+// eventObject is capCity the way -event-version asks for it
+func eventObject(capCity Capcity) interface{} {
+	if currentSettings().eventVersion == 2 {
+		return capacityEvent(capCity)
+	}
+	return capCity
+}
+
+func marshalEvent(capCity Capcity) ([]byte, error) {
+	return json.Marshal(eventObject(capCity))
+}
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// Forecast : Projected exhaustion of available N-1 capacity for one resource
+// of the selected nodes, or of one node pool among them
+type Forecast struct {
+	NodeSelector string
+	// NodePool is empty for the forecast of all selected nodes
+	NodePool    string
+	Resource    string
+	Unit        string
+	Samples     int
+	Seasonality string
+	Current     float64
+	Threshold   float64
+	SlopePerDay float64
+	Exhaustion  time.Time
+	Earliest    time.Time
+	Latest      time.Time
+	LastSample  time.Time
+}
+
+// forecastResources maps a resource to its available N-1 figure in a history
+// record, for the selected nodes and for a node pool
+var forecastResources = []struct {
+	name            string
+	unit            string
+	available       func(capCity Capcity) float64
+	allocatable     func(capCity Capcity) float64
+	poolAvailable   func(pool NodePoolCapacity) float64
+	poolAllocatable func(pool NodePoolCapacity) float64
+}{
+	{
+		name: "cpu_request",
+		unit: "millicores",
+		available: func(c Capcity) float64 {
+			return float64(c.AllocatableCPUNminusone*1000 - c.ContainerResourceCPURequestMilliCores)
+		},
+		allocatable:   func(c Capcity) float64 { return float64(c.AllocatableCPUNminusone * 1000) },
+		poolAvailable: func(p NodePoolCapacity) float64 { return float64(p.AvailableCPURequestNminusoneMilliCores) },
+		poolAllocatable: func(p NodePoolCapacity) float64 {
+			return float64(p.AvailableCPURequestNminusoneMilliCores + p.CPURequestMilliCores)
+		},
+	},
+	{
+		name: "memory_request",
+		unit: "bytes",
+		available: func(c Capcity) float64 {
+			return float64(c.AllocatableMemoryNminusone - c.ContainerResourceMemoryRequest)
+		},
+		allocatable:   func(c Capcity) float64 { return float64(c.AllocatableMemoryNminusone) },
+		poolAvailable: func(p NodePoolCapacity) float64 { return float64(p.AvailableMemoryRequestNminusone) },
+		poolAllocatable: func(p NodePoolCapacity) float64 {
+			return float64(p.AvailableMemoryRequestNminusone + p.MemoryRequest)
+		},
+	},
+	{
+		name:            "pods",
+		unit:            "pods",
+		available:       func(c Capcity) float64 { return float64(c.AllocatablePodsNminusone - c.ContainerResourcePods) },
+		allocatable:     func(c Capcity) float64 { return float64(c.AllocatablePodsNminusone) },
+		poolAvailable:   func(p NodePoolCapacity) float64 { return float64(p.AvailablePodsNminusone) },
+		poolAllocatable: func(p NodePoolCapacity) float64 { return float64(p.AvailablePodsNminusone + p.Pods) },
+	},
+}
+
+// forecastSeries is one history record's figures for the selected nodes,
+// capCity, or for one node pool among them
+type forecastSeries struct {
+	timestamp time.Time
+	capCity   *Capcity
+	pool      NodePoolCapacity
+}
+
+func (s forecastSeries) value(selected func(Capcity) float64, inPool func(NodePoolCapacity) float64) float64 {
+	if s.capCity != nil {
+		return selected(*s.capCity)
+	}
+	return inPool(s.pool)
+}
+
+const (
+	forecastMinSamples = 3
+	// z value for a 95% confidence range on the fitted slope
+	forecastZ = 1.96
+)
+
+// forecastHistory fits a trend per resource for the selected nodes of every
+// -nodelabel in the history, and for every node pool among them.
+// thresholdFactor is the share of allocatable N-1 capacity at which the nodes
+// count as exhausted
+func forecastHistory(records []HistoryRecord, thresholdFactor float64) (forecasts []Forecast) {
+	type group struct{ selector, pool string }
+	groups := make(map[group][]forecastSeries)
+	for i := range records {
+		record := &records[i]
+		selected := group{selector: record.NodeSelector}
+		groups[selected] = append(groups[selected], forecastSeries{timestamp: record.Timestamp, capCity: &record.Capcity})
+		for name, pool := range record.NodePools {
+			inPool := group{selector: record.NodeSelector, pool: name}
+			groups[inPool] = append(groups[inPool], forecastSeries{timestamp: record.Timestamp, pool: pool})
+		}
+	}
+	keys := make([]group, 0, len(groups))
+	for key := range groups {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].selector != keys[j].selector {
+			return keys[i].selector < keys[j].selector
+		}
+		return keys[i].pool < keys[j].pool
+	})
+
+	for _, key := range keys {
+		series := groups[key]
+		sort.Slice(series, func(i, j int) bool { return series[i].timestamp.Before(series[j].timestamp) })
+		for _, res := range forecastResources {
+			times := make([]time.Time, len(series))
+			values := make([]float64, len(series))
+			for i, s := range series {
+				times[i] = s.timestamp
+				values[i] = s.value(res.available, res.poolAvailable)
+			}
+			last := series[len(series)-1]
+			forecast := fitForecast(times, values, thresholdFactor*last.value(res.allocatable, res.poolAllocatable))
+			forecast.NodeSelector = key.selector
+			forecast.NodePool = key.pool
+			forecast.Resource = res.name
+			forecast.Unit = res.unit
+			forecasts = append(forecasts, forecast)
+		}
+	}
+	return forecasts
+}
+
+// fitForecast fits a linear trend on the deseasonalised series and projects
+// when it crosses threshold. The confidence range widens the slope by its
+// standard error and shifts the level by the seasonal trough and peak.
+func fitForecast(times []time.Time, values []float64, threshold float64) (forecast Forecast) {
+	forecast.Samples = len(values)
+	forecast.Threshold = threshold
+	if len(values) == 0 {
+		return forecast
+	}
+	forecast.Current = values[len(values)-1]
+	forecast.LastSample = times[len(times)-1]
+	if len(values) < forecastMinSamples || !times[len(times)-1].After(times[0]) {
+		return forecast
+	}
+
+	origin := times[0]
+	days := make([]float64, len(times))
+	for i, t := range times {
+		days[i] = t.Sub(origin).Hours() / 24
+	}
+
+	seasonal, period := seasonalIndex(times, days, values)
+	forecast.Seasonality = period
+	adjusted := make([]float64, len(values))
+	for i := range values {
+		adjusted[i] = values[i] - seasonal[i]
+	}
+	intercept, slope, slopeErr := linearFit(days, adjusted)
+	forecast.SlopePerDay = slope
+
+	trough, peak := 0.0, 0.0
+	for _, s := range seasonal {
+		trough = math.Min(trough, s)
+		peak = math.Max(peak, s)
+	}
+
+	now := days[len(days)-1]
+	if forecast.Current <= threshold {
+		forecast.Exhaustion = forecast.LastSample
+		forecast.Earliest = forecast.LastSample
+		forecast.Latest = forecast.LastSample
+		return forecast
+	}
+	at := func(day float64) time.Time {
+		if day < now {
+			day = now
+		}
+		return origin.Add(time.Duration(day * 24 * float64(time.Hour)))
+	}
+	if day, ok := crossing(intercept, slope, threshold); ok {
+		forecast.Exhaustion = at(day)
+	}
+	if day, ok := crossing(intercept+trough, slope-forecastZ*slopeErr, threshold); ok {
+		forecast.Earliest = at(day)
+	}
+	if day, ok := crossing(intercept+peak, slope+forecastZ*slopeErr, threshold); ok {
+		forecast.Latest = at(day)
+	}
+	return forecast
+}
+
+func crossing(intercept, slope, threshold float64) (float64, bool) {
+	if slope >= 0 {
+		return 0, false
+	}
+	return (threshold - intercept) / slope, true
+}
+
+// linearFit is an ordinary least squares fit returning the standard error of the slope
+func linearFit(x, y []float64) (intercept, slope, slopeErr float64) {
+	n := float64(len(x))
+	var sumX, sumY float64
+	for i := range x {
+		sumX += x[i]
+		sumY += y[i]
+	}
+	meanX, meanY := sumX/n, sumY/n
+	var sxx, sxy float64
+	for i := range x {
+		sxx += (x[i] - meanX) * (x[i] - meanX)
+		sxy += (x[i] - meanX) * (y[i] - meanY)
+	}
+	if sxx == 0 {
+		return meanY, 0, 0
+	}
+	slope = sxy / sxx
+	intercept = meanY - slope*meanX
+	if len(x) > 2 {
+		var sse float64
+		for i := range x {
+			residual := y[i] - (intercept + slope*x[i])
+			sse += residual * residual
+		}
+		slopeErr = math.Sqrt(sse / (n - 2) / sxx)
+	}
+	return intercept, slope, slopeErr
+}
+
+// seasonalIndex returns the mean detrended residual per hour of week once
+// two weeks are recorded, per hour of day once two days are, else zeros
+func seasonalIndex(times []time.Time, days, values []float64) (seasonal []float64, period string) {
+	seasonal = make([]float64, len(values))
+	span := days[len(days)-1] - days[0]
+	var bucketOf func(t time.Time) int
+	switch {
+	case span >= 14:
+		period = "weekly"
+		bucketOf = func(t time.Time) int { return int(t.UTC().Weekday())*24 + t.UTC().Hour() }
+	case span >= 2:
+		period = "daily"
+		bucketOf = func(t time.Time) int { return t.UTC().Hour() }
+	default:
+		return seasonal, "none"
+	}
+	intercept, slope, _ := linearFit(days, values)
+	sums := make(map[int]float64)
+	counts := make(map[int]int)
+	for i, t := range times {
+		bucket := bucketOf(t)
+		sums[bucket] += values[i] - (intercept + slope*days[i])
+		counts[bucket]++
+	}
+	for i, t := range times {
+		bucket := bucketOf(t)
+		seasonal[i] = sums[bucket] / float64(counts[bucket])
+	}
+	return seasonal, period
+}
+
+// applyForecast fills the forecast fields from the forecast of all nodes
+// selected by nodeSelector, node pool forecasts are only in the report
+func applyForecast(capCity *Capcity, forecasts []Forecast, nodeSelector string) {
+	for _, forecast := range forecasts {
+		if forecast.NodeSelector != nodeSelector || forecast.NodePool != "" || forecast.Exhaustion.IsZero() {
+			continue
+		}
+		exhaustion := forecast.Exhaustion.Format(time.RFC3339)
+		earliest := formatForecastTime(forecast.Earliest)
+		latest := formatForecastTime(forecast.Latest)
+		days := forecast.Exhaustion.Sub(forecast.LastSample).Hours() / 24
+		switch forecast.Resource {
+		case "cpu_request":
+			capCity.ForecastCPURequestNminusoneExhaustion = exhaustion
+			capCity.ForecastCPURequestNminusoneEarliest = earliest
+			capCity.ForecastCPURequestNminusoneLatest = latest
+			capCity.ForecastCPURequestNminusoneDays = &days
+		case "memory_request":
+			capCity.ForecastMemoryRequestNminusoneExhaustion = exhaustion
+			capCity.ForecastMemoryRequestNminusoneEarliest = earliest
+			capCity.ForecastMemoryRequestNminusoneLatest = latest
+			capCity.ForecastMemoryRequestNminusoneDays = &days
+		case "pods":
+			capCity.ForecastPodsNminusoneExhaustion = exhaustion
+			capCity.ForecastPodsNminusoneEarliest = earliest
+			capCity.ForecastPodsNminusoneLatest = latest
+			capCity.ForecastPodsNminusoneDays = &days
+		}
+	}
+}
+
+func formatForecastTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+func forecastHumanMode(forecasts []Forecast) (output []string) {
+	output = append(output, fmt.Sprintf("================"))
+	output = append(output, fmt.Sprintf("Capacity Forecast (available N-1)"))
+	for _, forecast := range forecasts {
+		nodes := forecast.NodeSelector
+		if nodes == "" {
+			nodes = "all nodes"
+		}
+		output = append(output, fmt.Sprintf("----------------"))
+		if forecast.NodePool != "" {
+			output = append(output, fmt.Sprintf("Node Pool: %s of %s, Resource: %s", forecast.NodePool, nodes, forecast.Resource))
+		} else {
+			output = append(output, fmt.Sprintf("Nodes: %s, Resource: %s", nodes, forecast.Resource))
+		}
+		if forecast.Samples < forecastMinSamples {
+			output = append(output, fmt.Sprintf("Not enough history to forecast (%d samples)", forecast.Samples))
+			continue
+		}
+		output = append(output, fmt.Sprintf("Current Available: %s, Threshold: %s", formatForecastValue(forecast.Current, forecast.Unit), formatForecastValue(forecast.Threshold, forecast.Unit)))
+		output = append(output, fmt.Sprintf("Trend per day: %s (%d samples, seasonality: %s)", formatForecastValue(forecast.SlopePerDay, forecast.Unit), forecast.Samples, forecast.Seasonality))
+		if forecast.Exhaustion.IsZero() {
+			output = append(output, fmt.Sprintf("Projected Exhaustion: not trending towards threshold"))
+			continue
+		}
+		output = append(output, fmt.Sprintf("Projected Exhaustion: %s (earliest %s, latest %s)", forecast.Exhaustion.Format("2006-01-02"), formatForecastDay(forecast.Earliest), formatForecastDay(forecast.Latest)))
+	}
+	return output
+}
+
+func formatForecastDay(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return t.Format("2006-01-02")
+}
+
+func formatForecastValue(value float64, unit string) string {
+	switch unit {
+	case "bytes":
+		return fmt.Sprintf("%.1fGiB", value/1024/1024/1024)
+	case "millicores":
+		return fmt.Sprintf("%.0fm", value)
+	}
+	return fmt.Sprintf("%.0f", value)
+}
+
+func loadForecasts(historyFile string, since time.Duration, thresholdFactor float64, now time.Time) ([]Forecast, error) {
+	records, err := readHistory(historyFile, now.Add(-since))
+	if err != nil {
+		return nil, err
+	}
+	return forecastHistory(records, thresholdFactor), nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func decliningHistory(start time.Time, samples int, memoryRequestPerDay int64) (records []HistoryRecord) {
+	gib := int64(1024 * 1024 * 1024)
+	for i := 0; i < samples; i++ {
+		requested := int64(i) * memoryRequestPerDay * gib / 24
+		records = append(records, HistoryRecord{
+			Timestamp:    start.Add(time.Duration(i) * time.Hour),
+			NodeSelector: "pool=a",
+			Capcity: Capcity{
+				AllocatableMemoryNminusone:     100 * gib,
+				ContainerResourceMemoryRequest: requested,
+				AllocatableCPUNminusone:        10,
+				AllocatablePodsNminusone:       100,
+				ContainerResourcePods:          10,
+			},
+			// The general pool is half the memory, and gets all the requests
+			NodePools: map[string]NodePoolCapacity{"general": {
+				Name:                            "general",
+				MemoryRequest:                   requested,
+				AvailableMemoryRequestNminusone: 50*gib - requested,
+				AvailablePodsNminusone:          50,
+			}},
+		})
+	}
+	return records
+}
+
+func findForecast(forecasts []Forecast, resource string) Forecast {
+	for _, forecast := range forecasts {
+		if forecast.Resource == resource {
+			return forecast
+		}
+	}
+	return Forecast{}
+}
+
+func TestForecastLinearDecline(t *testing.T) {
+	start := time.Date(2020, 3, 2, 0, 0, 0, 0, time.UTC)
+	// 10GiB more requested every day, 100GiB available: exhausted 10 days after start
+	forecasts := forecastHistory(decliningHistory(start, 48, 10), 0)
+	if len(forecasts) != 2*len(forecastResources) {
+		t.Fatalf("Expected %d forecasts, got %d", 2*len(forecastResources), len(forecasts))
+	}
+	memory := findForecast(forecasts, "memory_request")
+	expected := start.Add(10 * 24 * time.Hour)
+	if math.Abs(memory.Exhaustion.Sub(expected).Hours()) > 1 {
+		t.Errorf("Expected exhaustion around %s, got %s", expected, memory.Exhaustion)
+	}
+	if memory.Earliest.After(memory.Exhaustion) || memory.Latest.Before(memory.Exhaustion) {
+		t.Errorf("Expected %s to lie within %s and %s", memory.Exhaustion, memory.Earliest, memory.Latest)
+	}
+	pods := findForecast(forecasts, "pods")
+	if !pods.Exhaustion.IsZero() {
+		t.Errorf("Expected no exhaustion for flat pods, got %s", pods.Exhaustion)
+	}
+
+	// The general pool runs out in 5 days, not with the selected nodes
+	general := findForecast(forecasts[len(forecastResources):], "memory_request")
+	compareString(general.NodeSelector+" "+general.NodePool, "pool=a general", t)
+	expected = start.Add(5 * 24 * time.Hour)
+	if math.Abs(general.Exhaustion.Sub(expected).Hours()) > 1 {
+		t.Errorf("Expected the general pool exhausted around %s, got %s", expected, general.Exhaustion)
+	}
+}
+
+func TestForecastThreshold(t *testing.T) {
+	start := time.Date(2020, 3, 2, 0, 0, 0, 0, time.UTC)
+	// Exhausted once only 20% of N-1 memory is left, after 8 days
+	forecasts := forecastHistory(decliningHistory(start, 48, 10), 0.2)
+	memory := findForecast(forecasts, "memory_request")
+	expected := start.Add(8 * 24 * time.Hour)
+	if math.Abs(memory.Exhaustion.Sub(expected).Hours()) > 1 {
+		t.Errorf("Expected exhaustion around %s, got %s", expected, memory.Exhaustion)
+	}
+}
+
+func TestForecastAlreadyExhausted(t *testing.T) {
+	start := time.Date(2020, 3, 2, 0, 0, 0, 0, time.UTC)
+	times := []time.Time{start, start.Add(time.Hour), start.Add(2 * time.Hour)}
+	forecast := fitForecast(times, []float64{10, 5, -1}, 0)
+	if !forecast.Exhaustion.Equal(times[2]) {
+		t.Errorf("Expected exhaustion at the last sample, got %s", forecast.Exhaustion)
+	}
+}
+
+func TestForecastNotEnoughHistory(t *testing.T) {
+	start := time.Date(2020, 3, 2, 0, 0, 0, 0, time.UTC)
+	forecasts := forecastHistory(decliningHistory(start, 2, 10), 0)
+	output := forecastHumanMode(forecasts)
+	compareString(output[1], "Capacity Forecast (available N-1)", t)
+	compareString(output[3], "Nodes: pool=a, Resource: cpu_request", t)
+	compareString(output[4], "Not enough history to forecast (2 samples)", t)
+}
+
+func TestApplyForecast(t *testing.T) {
+	start := time.Date(2020, 3, 2, 0, 0, 0, 0, time.UTC)
+	forecasts := forecastHistory(decliningHistory(start, 48, 10), 0)
+	capCity := Capcity{}
+	applyForecast(&capCity, forecasts, "pool=b")
+	if capCity.ForecastMemoryRequestNminusoneExhaustion != "" {
+		t.Errorf("Expected no forecast for another node pool")
+	}
+	applyForecast(&capCity, forecasts, "pool=a")
+	if capCity.ForecastMemoryRequestNminusoneExhaustion == "" || capCity.ForecastMemoryRequestNminusoneDays == nil {
+		t.Errorf("Expected a memory forecast for pool=a")
+	}
+	if days := *capCity.ForecastMemoryRequestNminusoneDays; days < 8 {
+		t.Errorf("Expected the forecast of all selected nodes, not of a pool, got %v days", days)
+	}
+	if capCity.ForecastPodsNminusoneExhaustion != "" {
+		t.Errorf("Expected no pods forecast, got %s", capCity.ForecastPodsNminusoneExhaustion)
+	}
+}
@@ -1,142 +1,263 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	corev1 "k8s.io/api/core/v1"
 	resource "k8s.io/apimachinery/pkg/api/resource"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/client-go/kubernetes"
 	metricsv1b1 "k8s.io/metrics/pkg/apis/metrics/v1beta1"
 	"strings"
+	"sync"
 )
 
-func getNodeMetrics(clientset *kubernetes.Clientset) (nodeMetricList *metricsv1b1.NodeMetricsList) {
-	data, err := clientset.RESTClient().Get().AbsPath("apis/metrics.k8s.io/v1beta1/nodes").DoRaw()
+func getNodeMetrics(ctx context.Context, clientset *kubernetes.Clientset) (nodeMetricList *metricsv1b1.NodeMetricsList) {
+	data, err := clientset.RESTClient().Get().AbsPath("apis/metrics.k8s.io/v1beta1/nodes").Context(ctx).DoRaw()
 	check(err)
 	err = json.Unmarshal(data, &nodeMetricList)
 	check(err)
 	return nodeMetricList
 }
 
-func gatherInfo(clientset *kubernetes.Clientset, nodeLabel *string) (clusterInfo ClusterInfo) {
-	nodeInfo := make(map[string]NodeInfo)
-	labelSlice := strings.Split(*nodeLabel, "=")
-	nodeLabelKey := labelSlice[0]
-	nodeLabelValue := ""
-	if nodeLabelKey != "" {
-		nodeLabelValue = labelSlice[1]
+func addNodeMetrics(nodeInfo map[string]NodeInfo, nodeMetricList *metricsv1b1.NodeMetricsList) {
+	for _, metricNode := range nodeMetricList.Items {
+		cpuUsed := metricNode.Usage.Cpu()
+		memUsed := metricNode.Usage.Memory()
+		node := nodeInfo[metricNode.Name]
+		node.UsedCPU = *cpuUsed
+		node.UsedMemory = *memUsed
+		nodeInfo[metricNode.Name] = node
 	}
+}
 
-	// List all nodes
-	nodes, err := clientset.CoreV1().Nodes().List(metav1.ListOptions{})
+// parseNodeSelector parses a -nodelabel, a label selector such as pool=a or
+// 'pool in (a,b),!spot'. Blank means every node
+func parseNodeSelector(nodeLabel string) (labels.Selector, error) {
+	selector, err := labels.Parse(nodeLabel)
 	if err != nil {
-		panic(err.Error())
-	}
-	if nodeLabelKey != "" {
-		clusterInfo.NodeLabel = nodeLabelKey
-		for _, v := range nodes.Items {
-			if !v.Spec.Unschedulable {
-				for label, value := range v.ObjectMeta.Labels {
-					if label == nodeLabelKey {
-						if value == nodeLabelValue {
-							node := nodeInfo[v.Name]
-							node.PrintOutput = true
-							nodeInfo[v.Name] = node
-						}
-					}
-				}
-			}
+		return nil, fmt.Errorf("node label selector %q: %s", nodeLabel, err)
+	}
+	return selector, nil
+}
+
+// selectorKeys are the label keys selector looks at, for k8s_quota.node_label
+func selectorKeys(selector labels.Selector) string {
+	requirements, _ := selector.Requirements()
+	keys := make([]string, 0, len(requirements))
+	for _, requirement := range requirements {
+		keys = append(keys, requirement.Key())
+	}
+	return strings.Join(keys, ",")
+}
+
+// nodeSelected reports whether a schedulable node matches the -nodelabel selector
+func nodeSelected(node corev1.Node, selector labels.Selector) bool {
+	if node.Spec.Unschedulable {
+		return false
+	}
+	return selector.Matches(labels.Set(node.ObjectMeta.Labels))
+}
+
+// gatherInfo lists nodes, quotas, node metrics and pods concurrently. With a
+// -nodelabel the pods are listed once the selected nodes are known
+func gatherInfo(ctx context.Context, clientset *kubernetes.Clientset, nodeLabel *string) (clusterInfo ClusterInfo) {
+	nodeInfo := make(map[string]NodeInfo)
+	clusterInfo.NodeSelector = *nodeLabel
+	selector, err := parseNodeSelector(*nodeLabel)
+	check(err)
+
+	var nodes []corev1.Node
+	var quotas []corev1.ResourceQuota
+	var nodeMetricList *metricsv1b1.NodeMetricsList
+	// Each pod is added to its node and namespace as its page arrives, so
+	// only the current page of pods is ever held
+	var podsLock sync.Mutex
+	namespaceUsage := make(map[string]map[string]NamespaceTotals)
+	addPod := func(pod *corev1.Pod) {
+		usage, ok := podUsageOf(pod)
+		if !ok {
+			return
 		}
-	} else {
-		for _, v := range nodes.Items {
-			if !v.Spec.Unschedulable {
-				node := nodeInfo[v.Name]
-				node.PrintOutput = true
-				nodeInfo[v.Name] = node
-			}
+		podsLock.Lock()
+		defer podsLock.Unlock()
+		node := nodeInfo[usage.nodeName]
+		byNamespace, ok := namespaceUsage[usage.nodeName]
+		if !ok {
+			byNamespace = make(map[string]NamespaceTotals)
+			namespaceUsage[usage.nodeName] = byNamespace
 		}
+		nsTotals := byNamespace[usage.namespace]
+		usage.addTo(&node, &nsTotals)
+		nodeInfo[usage.nodeName] = node
+		byNamespace[usage.namespace] = nsTotals
 	}
 
-	for _, v := range nodes.Items {
-		if nodeInfo[v.Name].PrintOutput {
-			cpu := v.Status.Allocatable.Cpu()
-			mem := v.Status.Allocatable.Memory()
-			pods := v.Status.Allocatable.Pods()
-			if cpu.Value() > clusterInfo.NminusCPU.Value() {
-				clusterInfo.NminusCPU = *cpu
-				clusterInfo.NminusMemory = *mem
-				clusterInfo.NminusPods = *pods
-			}
-			clusterInfo.ClusterAllocatableMemory.Add(*mem)
-			clusterInfo.ClusterAllocatableCPU.Add(*cpu)
-			clusterInfo.ClusterAllocatablePods.Add(*pods)
+	// List the selected nodes, the api server does the label matching
+	labelSelector := ""
+	if !selector.Empty() {
+		clusterInfo.NodeLabel = selectorKeys(selector)
+		labelSelector = selector.String()
+	}
+	calls := []apiCall{
+		{"nodes", func(ctx context.Context) error {
+			return listNodes(ctx, clientset, labelSelector, func(v corev1.Node) {
+				nodes = append(nodes, v)
+			})
+		}},
+		{"resourcequotas", func(ctx context.Context) error {
+			return listResourceQuotas(ctx, clientset, func(v corev1.ResourceQuota) {
+				quotas = append(quotas, v)
+			})
+		}},
+		{"nodemetrics", func(ctx context.Context) error {
+			nodeMetricList = getNodeMetrics(ctx, clientset)
+			return nil
+		}},
+	}
+	// Without a node label every node is wanted, so list pods cluster wide
+	if selector.Empty() {
+		calls = append(calls, apiCall{"pods", func(ctx context.Context) error {
+			return listSelectedPods(ctx, clientset, nil, addPod)
+		}})
+	}
+	check(runConcurrently(ctx, calls...))
+
+	selectedNodes := []string{}
+	for _, v := range nodes {
+		if nodeSelected(v, selector) {
 			node := nodeInfo[v.Name]
-			node.AllocatableCPU = *v.Status.Allocatable.Cpu()
-			node.AllocatableMemory = *v.Status.Allocatable.Memory()
-			node.AllocatablePods = *v.Status.Allocatable.Pods()
+			node.PrintOutput = true
 			nodeInfo[v.Name] = node
+			addNodeAllocatable(&clusterInfo, nodeInfo, v)
+			selectedNodes = append(selectedNodes, v.Name)
 		}
-
 	}
-
-	// List quotas
-	quotas, err := clientset.CoreV1().ResourceQuotas("").List(metav1.ListOptions{})
-	if err != nil {
-		panic(err.Error())
+	if !selector.Empty() {
+		check(listSelectedPods(ctx, clientset, selectedNodes, addPod))
 	}
+
 	// Add all the quotas up
-	for _, v := range quotas.Items {
-		limitmem := v.Spec.Hard[corev1.ResourceLimitsMemory]
-		limitcpu := v.Spec.Hard[corev1.ResourceLimitsCPU]
-		requestmem := v.Spec.Hard[corev1.ResourceRequestsMemory]
-		requestcpu := v.Spec.Hard[corev1.ResourceRequestsCPU]
-		pods := v.Spec.Hard[corev1.ResourcePods]
-		clusterInfo.RqclusterAllocatedLimitsMemory.Add(limitmem)
-		clusterInfo.RqclusterAllocatedLimitsCPU.Add(limitcpu)
-		clusterInfo.RqclusterAllocatedPods.Add(pods)
-		clusterInfo.RqclusterAllocatedRequestsMemory.Add(requestmem)
-		clusterInfo.RqclusterAllocatedRequestsCPU.Add(requestcpu)
-	}
-
-	nodeMetricList := getNodeMetrics(clientset)
-	for _, metricNode := range nodeMetricList.Items {
-		cpuUsed := metricNode.Usage.Cpu()
-		memUsed := metricNode.Usage.Memory()
-		node := nodeInfo[metricNode.Name]
-		node.UsedCPU = *cpuUsed
-		node.UsedMemory = *memUsed
-		nodeInfo[metricNode.Name] = node
+	for _, v := range quotas {
+		addResourceQuota(&clusterInfo, v)
 	}
 
-	pods, err := clientset.CoreV1().Pods("").List(metav1.ListOptions{})
-	check(err)
-	for _, pod := range pods.Items {
-		node := nodeInfo[pod.Spec.NodeName]
-		if pod.Status.Phase != "Failed" {
-			if pod.Status.Phase != "Succeeded" {
-				for _, container := range pod.Spec.Containers {
-					crrm := container.Resources.Requests.Memory()
-					crlm := container.Resources.Limits.Memory()
-					crrc := container.Resources.Requests.Cpu()
-					UsedMemRequests := &resource.Quantity{}
-					UsedMemLimits := &resource.Quantity{}
-					UsedCPURequests := &resource.Quantity{}
-					UsedMemRequests.Add(node.UsedMemoryRequests)
-					UsedMemRequests.Add(*crrm)
-					UsedMemLimits.Add(node.UsedMemoryLimits)
-					UsedMemLimits.Add(*crlm)
-					UsedCPURequests.Add(node.UsedCPURequests)
-					UsedCPURequests.Add(*crrc)
-					node.UsedMemoryRequests = *UsedMemRequests
-					node.UsedMemoryLimits = *UsedMemLimits
-					node.UsedCPURequests = *UsedCPURequests
-				}
-				node.UsedPods++
-			}
-		}
-		nodeInfo[pod.Spec.NodeName] = node
-	}
+	addNodeMetrics(nodeInfo, nodeMetricList)
+
 	clusterInfo.NodeInfo = nodeInfo
+	clusterInfo.NamespaceTotals = selectedNamespaceTotals(nodeInfo, namespaceUsage)
 	return clusterInfo
+}
+
+// selectedNamespaceTotals sums what every namespace uses of the selected
+// nodes, from what it uses per node
+func selectedNamespaceTotals(nodeInfo map[string]NodeInfo, namespaceUsage map[string]map[string]NamespaceTotals) map[string]NamespaceTotals {
+	namespaceTotals := make(map[string]NamespaceTotals)
+	for nodeName, byNamespace := range namespaceUsage {
+		if !nodeInfo[nodeName].PrintOutput {
+			continue
+		}
+		for namespace, usage := range byNamespace {
+			nsTotals := namespaceTotals[namespace]
+			nsTotals.CPURequestsMilliCores = nsTotals.CPURequestsMilliCores + usage.CPURequestsMilliCores
+			nsTotals.CPULimitsMilliCores = nsTotals.CPULimitsMilliCores + usage.CPULimitsMilliCores
+			nsTotals.MemoryRequests = nsTotals.MemoryRequests + usage.MemoryRequests
+			nsTotals.MemoryLimits = nsTotals.MemoryLimits + usage.MemoryLimits
+			nsTotals.Pods = nsTotals.Pods + usage.Pods
+			namespaceTotals[namespace] = nsTotals
+		}
+	}
+	return namespaceTotals
+
+}
+
+// podUsage : What one non-terminated pod requests from its node
+type podUsage struct {
+	nodeName            string
+	namespace           string
+	memoryRequests      resource.Quantity
+	memoryLimits        resource.Quantity
+	cpuRequests         resource.Quantity
+	cpuLimitsMilliCores int64
+}
+
+// podUsageOf sums the container resources of a pod, Failed and Succeeded pods use nothing
+func podUsageOf(pod *corev1.Pod) (usage podUsage, ok bool) {
+	if pod.Status.Phase == corev1.PodFailed || pod.Status.Phase == corev1.PodSucceeded {
+		return usage, false
+	}
+	usage.nodeName = pod.Spec.NodeName
+	usage.namespace = pod.Namespace
+	for _, container := range pod.Spec.Containers {
+		usage.memoryRequests.Add(*container.Resources.Requests.Memory())
+		usage.memoryLimits.Add(*container.Resources.Limits.Memory())
+		usage.cpuRequests.Add(*container.Resources.Requests.Cpu())
+		usage.cpuLimitsMilliCores = usage.cpuLimitsMilliCores + container.Resources.Limits.Cpu().MilliValue()
+	}
+	return usage, true
+}
+
+func (u podUsage) addTo(node *NodeInfo, nsTotals *NamespaceTotals) {
+	node.UsedMemoryRequests.Add(u.memoryRequests)
+	node.UsedMemoryLimits.Add(u.memoryLimits)
+	node.UsedCPURequests.Add(u.cpuRequests)
+	node.UsedPods++
+	nsTotals.MemoryRequests = nsTotals.MemoryRequests + u.memoryRequests.Value()
+	nsTotals.MemoryLimits = nsTotals.MemoryLimits + u.memoryLimits.Value()
+	nsTotals.CPURequestsMilliCores = nsTotals.CPURequestsMilliCores + u.cpuRequests.MilliValue()
+	nsTotals.CPULimitsMilliCores = nsTotals.CPULimitsMilliCores + u.cpuLimitsMilliCores
+	nsTotals.Pods++
+}
+
+func (u podUsage) subtractFrom(node *NodeInfo, nsTotals *NamespaceTotals) {
+	node.UsedMemoryRequests.Sub(u.memoryRequests)
+	node.UsedMemoryLimits.Sub(u.memoryLimits)
+	node.UsedCPURequests.Sub(u.cpuRequests)
+	node.UsedPods--
+	nsTotals.MemoryRequests = nsTotals.MemoryRequests - u.memoryRequests.Value()
+	nsTotals.MemoryLimits = nsTotals.MemoryLimits - u.memoryLimits.Value()
+	nsTotals.CPURequestsMilliCores = nsTotals.CPURequestsMilliCores - u.cpuRequests.MilliValue()
+	nsTotals.CPULimitsMilliCores = nsTotals.CPULimitsMilliCores - u.cpuLimitsMilliCores
+	nsTotals.Pods--
+}
+
+// addNodeAllocatable adds a selected node to the cluster totals, the node
+// with the most cpu is the one N-1 figures leave out
+func addNodeAllocatable(clusterInfo *ClusterInfo, nodeInfo map[string]NodeInfo, v corev1.Node) {
+	cpu := v.Status.Allocatable.Cpu()
+	mem := v.Status.Allocatable.Memory()
+	pods := v.Status.Allocatable.Pods()
+	if cpu.Value() > clusterInfo.NminusCPU.Value() {
+		clusterInfo.NminusCPU = *cpu
+		clusterInfo.NminusMemory = *mem
+		clusterInfo.NminusPods = *pods
+	}
+	clusterInfo.ClusterAllocatableMemory.Add(*mem)
+	clusterInfo.ClusterAllocatableCPU.Add(*cpu)
+	clusterInfo.ClusterAllocatablePods.Add(*pods)
+	node := nodeInfo[v.Name]
+	node.AllocatableCPU = *cpu
+	node.AllocatableMemory = *mem
+	node.AllocatablePods = *pods
+	node.Labels = v.Labels
+	node.Conditions = make(map[string]string)
+	for _, condition := range v.Status.Conditions {
+		node.Conditions[string(condition.Type)] = string(condition.Status)
+	}
+	node.Unschedulable = v.Spec.Unschedulable
+	nodeInfo[v.Name] = node
+}
 
+func addResourceQuota(clusterInfo *ClusterInfo, v corev1.ResourceQuota) {
+	limitmem := v.Spec.Hard[corev1.ResourceLimitsMemory]
+	limitcpu := v.Spec.Hard[corev1.ResourceLimitsCPU]
+	requestmem := v.Spec.Hard[corev1.ResourceRequestsMemory]
+	requestcpu := v.Spec.Hard[corev1.ResourceRequestsCPU]
+	pods := v.Spec.Hard[corev1.ResourcePods]
+	clusterInfo.RqclusterAllocatedLimitsMemory.Add(limitmem)
+	clusterInfo.RqclusterAllocatedLimitsCPU.Add(limitcpu)
+	clusterInfo.RqclusterAllocatedPods.Add(pods)
+	clusterInfo.RqclusterAllocatedRequestsMemory.Add(requestmem)
+	clusterInfo.RqclusterAllocatedRequestsCPU.Add(requestcpu)
 }
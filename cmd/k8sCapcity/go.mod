@@ -4,9 +4,12 @@ go 1.12
 
 require (
 	github.com/sirupsen/logrus v1.4.2
+	github.com/spf13/pflag v1.0.3
 	golang.org/x/crypto v0.0.0-20191011191535-87dc89f01550 // indirect
+	golang.org/x/net v0.0.0-20190812203447-cdfb69ac37fc
 	k8s.io/api v0.0.0-20191010143144-fbf594f18f80
 	k8s.io/apimachinery v0.0.0-20191014065749-fb3eea214746
 	k8s.io/client-go v0.0.0-20191014070654-bd505ee787b2
 	k8s.io/metrics v0.0.0-20191014074242-8b0351268f72
+	sigs.k8s.io/yaml v1.1.0
 )
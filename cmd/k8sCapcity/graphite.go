@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+)
+
+// graphiteOptions : Where the daemon sends its gauges as Graphite plaintext over TCP
+type graphiteOptions struct {
+	address string
+	prefix  string
+	timeout time.Duration
+}
+
+func addGraphiteFlags(flags *flag.FlagSet) *graphiteOptions {
+	o := &graphiteOptions{}
+	flags.StringVar(&o.address, "graphite-address", "", "In daemon mode, also send the capacity gauges to this Graphite (carbon plaintext) host:port over TCP")
+	flags.StringVar(&o.prefix, "graphite-prefix", "k8scapcity", "First segment of every Graphite path")
+	flags.DurationVar(&o.timeout, "graphite-timeout", 10*time.Second, "Give up on sending to -graphite-address after this long")
+	return o
+}
+
+func (o *graphiteOptions) validate() error {
+	if o.address == "" {
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(o.address); err != nil {
+		return fmt.Errorf("-graphite-address %q is not host:port", o.address)
+	}
+	return nil
+}
+
+// graphiteSink : Sends every gauge as a path, value and timestamp line
+type graphiteSink struct {
+	graphiteOptions
+}
+
+func (s *graphiteSink) String() string {
+	return s.address
+}
+
+func (s *graphiteSink) write(groups []metricGroup, now time.Time) error {
+	conn, err := net.DialTimeout("tcp", s.address, s.timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetWriteDeadline(time.Now().Add(s.timeout))
+	_, err = conn.Write(graphiteLines(s.prefix, groups, now))
+	return err
+}
+
+// graphiteLines puts the cluster, node and namespace names in the path, e.g.
+// k8scapcity.prod.namespace.default.pods 12 1583452800
+func graphiteLines(prefix string, groups []metricGroup, now time.Time) []byte {
+	body := &bytes.Buffer{}
+	for _, group := range groups {
+		for _, gauge := range group.gauges {
+			fmt.Fprintf(body, "%s %s %d\n", metricPath(prefix, group, gauge, true), strconv.FormatFloat(gauge.value, 'f', -1, 64), now.Unix())
+		}
+	}
+	return body.Bytes()
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// healthState : When collections last started and succeeded, what /healthz
+// and /readyz answer from
+type healthState struct {
+	sync.Mutex
+	started   time.Time
+	succeeded time.Time
+	// due is when, N intervals after t, another collection should have
+	// happened. Zero means never
+	due func(t time.Time) time.Time
+}
+
+func addReadyIntervalsFlag(flags *flag.FlagSet) *int {
+	return flags.Int("ready-intervals", 3, "/readyz fails when no collection succeeded, and /healthz when none started, within this many intervals")
+}
+
+// scheduleDue is due for a schedule, the intervals'th run after t
+func scheduleDue(sched schedule, intervals int) func(t time.Time) time.Time {
+	return func(t time.Time) time.Time {
+		for i := 0; i < intervals && !t.IsZero(); i++ {
+			t = sched.next(t)
+		}
+		return t
+	}
+}
+
+func (h *healthState) setDue(due func(t time.Time) time.Time) {
+	h.Lock()
+	defer h.Unlock()
+	h.due = due
+}
+
+// track runs collect, recording when it started, when it succeeded and what
+// it took in selfMetrics. A panic in collect is passed on
+func (h *healthState) track(collect func() ClusterInfo) ClusterInfo {
+	start := time.Now()
+	h.Lock()
+	h.started = start
+	h.Unlock()
+	var collected *ClusterInfo
+	defer func() {
+		finished := time.Now()
+		selfMetrics.observeCollection(finished.Sub(start), collected, finished)
+		if collected != nil {
+			h.Lock()
+			h.succeeded = finished
+			h.Unlock()
+		}
+	}()
+	clusterInfo := collect()
+	collected = &clusterInfo
+	return clusterInfo
+}
+
+// overdue says why t is too long ago, blank when it is recent enough
+func (h *healthState) overdue(what string, t, now time.Time) string {
+	if h.due == nil {
+		return ""
+	}
+	if due := h.due(t); !due.IsZero() && now.After(due) {
+		return fmt.Sprintf("%s %s, another was due by %s", what, t.Format(time.RFC3339), due.Format(time.RFC3339))
+	}
+	return ""
+}
+
+// live fails once no collection has started for too long, a collection hung
+// on an api call or a loop that stopped. Failing collections are still live
+func (h *healthState) live(now time.Time) error {
+	h.Lock()
+	defer h.Unlock()
+	if h.started.IsZero() {
+		return nil
+	}
+	if problem := h.overdue("last collection started", h.started, now); problem != "" {
+		return fmt.Errorf("%s", problem)
+	}
+	return nil
+}
+
+// ready needs a successful collection within the last intervals
+func (h *healthState) ready(now time.Time) error {
+	h.Lock()
+	defer h.Unlock()
+	if h.succeeded.IsZero() {
+		return fmt.Errorf("no successful collection yet")
+	}
+	if problem := h.overdue("last successful collection", h.succeeded, now); problem != "" {
+		return fmt.Errorf("%s", problem)
+	}
+	return nil
+}
+
+// routes adds /healthz, /readyz and /metrics to mux
+func (h *healthState) routes(mux *http.ServeMux) {
+	probe := func(check func(now time.Time) error) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			if err := check(time.Now()); err != nil {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				fmt.Fprintln(w, err)
+				return
+			}
+			fmt.Fprintln(w, "ok")
+		}
+	}
+	mux.HandleFunc("/healthz", probe(h.live))
+	mux.HandleFunc("/readyz", probe(h.ready))
+	mux.Handle("/metrics", selfMetrics)
+}
+
+// serveDaemon serves /healthz, /readyz, /metrics and the /v1 capacity api
+// of the last cycle on listen in the background. Only listening can fail
+// here, serving errors are logged
+func serveDaemon(listen string, h *healthState, api *apiServer) error {
+	listener, err := net.Listen("tcp", listen)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/v1/", api.handler())
+	h.routes(mux)
+	log.Infof("Serving /healthz, /readyz, /metrics and /v1 on %s", listener.Addr())
+	go func() {
+		log.Errorf("Stopped serving on %s, Error: %s", listener.Addr(), http.Serve(listener, mux))
+	}()
+	return nil
+}
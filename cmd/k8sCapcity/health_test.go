@@ -0,0 +1,94 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHealthState(t *testing.T) {
+	sched, _ := newIntervalSchedule(time.Minute, false)
+	h := &healthState{}
+	h.setDue(scheduleDue(sched, 3))
+	now := time.Date(2020, 3, 6, 12, 0, 0, 0, time.UTC)
+	if h.live(now) != nil {
+		t.Error("Expected a daemon that has not started a collection yet to be live")
+	}
+	if err := h.ready(now); err == nil || err.Error() != "no successful collection yet" {
+		t.Errorf("Expected not ready before the first collection, got %v", err)
+	}
+
+	h.track(func() ClusterInfo { return schemaTestCluster() })
+	if h.live(time.Now()) != nil || h.ready(time.Now()) != nil {
+		t.Error("Expected live and ready after a successful collection")
+	}
+	if h.ready(time.Now().Add(2*time.Minute)) != nil {
+		t.Error("Expected ready two intervals after the last success")
+	}
+	if err := h.ready(time.Now().Add(4 * time.Minute)); err == nil || !strings.HasPrefix(err.Error(), "last successful collection") {
+		t.Errorf("Expected not ready four intervals after the last success, got %v", err)
+	}
+
+	// A failed collection is still live, but does not make it ready
+	h.succeeded = h.succeeded.Add(-time.Hour)
+	func() {
+		defer func() { recover() }()
+		h.track(func() ClusterInfo { panic("nodes: the server is unavailable") })
+	}()
+	if h.live(time.Now()) != nil {
+		t.Error("Expected failing collections to be live")
+	}
+	if h.ready(time.Now()) == nil {
+		t.Error("Expected failing collections not to be ready")
+	}
+	// A collection hung on an api call starts no more
+	if err := h.live(time.Now().Add(4 * time.Minute)); err == nil || !strings.HasPrefix(err.Error(), "last collection started") {
+		t.Errorf("Expected not live once no collection started for four intervals, got %v", err)
+	}
+}
+
+func TestHealthRoutes(t *testing.T) {
+	h := &healthState{due: func(t time.Time) time.Time { return t.Add(time.Minute) }}
+	mux := http.NewServeMux()
+	h.routes(mux)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	get := func(path string) (int, string) {
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+	if code, body := get("/healthz"); code != http.StatusOK || body != "ok\n" {
+		t.Errorf("Expected /healthz ok, got %d %s", code, body)
+	}
+	if code, _ := get("/readyz"); code != http.StatusServiceUnavailable {
+		t.Errorf("Expected /readyz 503 before a collection, got %d", code)
+	}
+	h.track(func() ClusterInfo { return schemaTestCluster() })
+	if code, _ := get("/readyz"); code != http.StatusOK {
+		t.Errorf("Expected /readyz 200 after a collection, got %d", code)
+	}
+	if _, body := get("/metrics"); !strings.Contains(body, `k8scapcity_collections_total{result="success"}`) {
+		t.Errorf("Expected /metrics to count the collection, got %s", body)
+	}
+}
+
+func TestServeDaemonListenError(t *testing.T) {
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+	err = serveDaemon(taken.Addr().String(), &healthState{}, &apiServer{})
+	if err == nil || !strings.Contains(err.Error(), "address already in use") {
+		t.Errorf("Expected the taken address to fail at once, got %v", err)
+	}
+}
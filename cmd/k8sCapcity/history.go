@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// HistoryRecord : One daemon cycle as persisted to the history file
+type HistoryRecord struct {
+	Timestamp time.Time `json:"timestamp"`
+	// NodeSelector is -nodelabel, under the json name older history files use
+	NodeSelector string                      `json:"node_pool,omitempty"`
+	Capcity      Capcity                     `json:"capcity"`
+	Namespaces   map[string]NamespaceTotals  `json:"namespaces,omitempty"`
+	NodePools    map[string]NodePoolCapacity `json:"node_pools,omitempty"`
+}
+
+// HistoryPoint : One aggregated bucket returned by a history query
+type HistoryPoint struct {
+	Start   time.Time
+	Value   float64
+	Samples int
+}
+
+// historyStore keeps one JSON record per line in a single file, pruning
+// records older than retention at most once per pruneEvery.
+type historyStore struct {
+	path       string
+	retention  time.Duration
+	pruneEvery time.Duration
+	lastPrune  time.Time
+}
+
+func newHistoryStore(path string, retention time.Duration) *historyStore {
+	return &historyStore{
+		path:       path,
+		retention:  retention,
+		pruneEvery: time.Hour,
+	}
+}
+
+// newHistoryRecord groups the node pools by poolLabel, or by the first of
+// nodePoolLabels the nodes have when it is empty
+func newHistoryRecord(clusterInfo ClusterInfo, capCity Capcity, poolLabel string, now time.Time) HistoryRecord {
+	// Per node factors are dropped, the history tracks cluster, node pool and
+	// namespace trends
+	capCity.UtilizationFactorPods = nil
+	capCity.UtilizationFactorMemoryRequests = nil
+	capCity.UtilizationFactorCPURequests = nil
+	pools := make(map[string]NodePoolCapacity)
+	for _, pool := range nodePoolCapacities(clusterInfo, poolLabel) {
+		pools[pool.Name] = pool
+	}
+	return HistoryRecord{
+		Timestamp:    now.UTC(),
+		NodeSelector: clusterInfo.NodeSelector,
+		Capcity:      capCity,
+		Namespaces:   clusterInfo.NamespaceTotals,
+		NodePools:    pools,
+	}
+}
+
+func (h *historyStore) record(record HistoryRecord) error {
+	err := appendHistory(h.path, record)
+	if err != nil {
+		return err
+	}
+	if h.retention > 0 && record.Timestamp.Sub(h.lastPrune) >= h.pruneEvery {
+		h.lastPrune = record.Timestamp
+		return pruneHistory(h.path, record.Timestamp.Add(-h.retention))
+	}
+	return nil
+}
+
+func appendHistory(path string, record HistoryRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(line, '\n'))
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func readHistory(path string, since time.Time) (records []HistoryRecord, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		record := HistoryRecord{}
+		err := json.Unmarshal(scanner.Bytes(), &record)
+		if err != nil {
+			log.Warnf("Skipping unreadable history record %s:%d, Error: %s", path, lineNumber, err)
+			continue
+		}
+		if record.Timestamp.Before(since) {
+			continue
+		}
+		records = append(records, record)
+	}
+	return records, scanner.Err()
+}
+
+// pruneHistory rewrites the history file without records older than cutoff
+func pruneHistory(path string, cutoff time.Time) error {
+	records, err := readHistory(path, cutoff)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".prune")
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(tmp)
+	for _, record := range records {
+		line, err := json.Marshal(record)
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+			return err
+		}
+		writer.Write(append(line, '\n'))
+	}
+	err = writer.Flush()
+	if err == nil {
+		err = tmp.Close()
+	} else {
+		tmp.Close()
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// parseRetention accepts go durations plus a d (day) and w (week) suffix
+func parseRetention(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if value == "" || value == "0" {
+		return 0, nil
+	}
+	unit := time.Duration(0)
+	switch {
+	case strings.HasSuffix(value, "d"):
+		unit = 24 * time.Hour
+	case strings.HasSuffix(value, "w"):
+		unit = 7 * 24 * time.Hour
+	}
+	if unit != 0 {
+		count, err := strconv.ParseFloat(value[:len(value)-1], 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", value)
+		}
+		return time.Duration(count * float64(unit)), nil
+	}
+	return time.ParseDuration(value)
+}
+
+// historyValue looks a field up by its json name, with or without the
+// k8s_quota. prefix. Namespace figures are addressed as
+// namespace.<name>.<field>, for example namespace.default.memory_requests.bytes,
+// node pool figures as node_pool.<name>.<field>, for example
+// node_pool.general.allocatable.memory.bytes
+func historyValue(record HistoryRecord, field string) (float64, bool) {
+	field = strings.TrimPrefix(field, "k8s_quota.")
+	if strings.HasPrefix(field, "node_pool.") {
+		// Pool names can hold dots (m5.xlarge), match them whole
+		field = strings.TrimPrefix(field, "node_pool.")
+		for name, pool := range record.NodePools {
+			if strings.HasPrefix(field, name+".") {
+				return lookupJSONNumber(pool, "k8s_quota.node_pool."+strings.TrimPrefix(field, name+"."))
+			}
+		}
+		return 0, false
+	}
+	if strings.HasPrefix(field, "namespace.") {
+		parts := strings.SplitN(strings.TrimPrefix(field, "namespace."), ".", 2)
+		if len(parts) != 2 {
+			return 0, false
+		}
+		totals, ok := record.Namespaces[parts[0]]
+		if !ok {
+			return 0, false
+		}
+		return lookupJSONNumber(totals, parts[1])
+	}
+	return lookupJSONNumber(record.Capcity, "k8s_quota."+field)
+}
+
+func lookupJSONNumber(v interface{}, key string) (float64, bool) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return 0, false
+	}
+	fields := make(map[string]interface{})
+	err = json.Unmarshal(data, &fields)
+	if err != nil {
+		return 0, false
+	}
+	value, ok := fields[key].(float64)
+	return value, ok
+}
+
+func queryHistory(records []HistoryRecord, field string, bucket time.Duration, agg string) (points []HistoryPoint, err error) {
+	switch agg {
+	case "min", "max", "avg", "last":
+	default:
+		return nil, fmt.Errorf("unknown aggregation %q, expected one of min, max, avg, last", agg)
+	}
+	if bucket <= 0 {
+		return nil, fmt.Errorf("bucket must be greater than zero")
+	}
+	buckets := make(map[time.Time]*HistoryPoint)
+	for _, record := range records {
+		value, ok := historyValue(record, field)
+		if !ok {
+			continue
+		}
+		start := record.Timestamp.Truncate(bucket)
+		point, ok := buckets[start]
+		if !ok {
+			point = &HistoryPoint{Start: start, Value: value}
+			buckets[start] = point
+		} else {
+			switch agg {
+			case "min":
+				point.Value = math.Min(point.Value, value)
+			case "max":
+				point.Value = math.Max(point.Value, value)
+			case "avg":
+				point.Value = point.Value + value
+			case "last":
+				point.Value = value
+			}
+		}
+		point.Samples++
+	}
+	for _, point := range buckets {
+		if agg == "avg" {
+			point.Value = point.Value / float64(point.Samples)
+		}
+		points = append(points, *point)
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i].Start.Before(points[j].Start) })
+	return points, nil
+}
+
+func historyHumanMode(points []HistoryPoint, field, agg string) (output []string) {
+	output = append(output, fmt.Sprintf("%s (%s)", field, agg))
+	output = append(output, fmt.Sprintf("================"))
+	if len(points) == 0 {
+		output = append(output, fmt.Sprintf("No history recorded for %s", field))
+		return output
+	}
+	first := points[0].Value
+	for _, point := range points {
+		output = append(output, fmt.Sprintf("%s  %-14s  samples: %d", point.Start.Format(time.RFC3339), strconv.FormatFloat(point.Value, 'f', -1, 64), point.Samples))
+	}
+	output = append(output, fmt.Sprintf("----------------"))
+	output = append(output, fmt.Sprintf("Change over period: %s", strconv.FormatFloat(points[len(points)-1].Value-first, 'f', -1, 64)))
+	return output
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func tempHistoryFile(t *testing.T) (path string, cleanup func()) {
+	dir, err := ioutil.TempDir("", "k8sCapcity-history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(dir, "history.jsonl"), func() { os.RemoveAll(dir) }
+}
+
+func historyRecordAt(ts time.Time, memoryFactor float64, nsMemory int64) HistoryRecord {
+	return HistoryRecord{
+		Timestamp: ts,
+		Capcity: Capcity{
+			UtilizationFactorMemoryRequestsNminusone: memoryFactor,
+		},
+		Namespaces: map[string]NamespaceTotals{
+			"default": {MemoryRequests: nsMemory},
+		},
+	}
+}
+
+func TestAppendAndReadHistory(t *testing.T) {
+	path, cleanup := tempHistoryFile(t)
+	defer cleanup()
+	start := time.Date(2020, 3, 2, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		err := appendHistory(path, historyRecordAt(start.Add(time.Duration(i)*time.Hour), 0.5, 1024))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	records, err := readHistory(path, start.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Errorf("Expected 2 records, got %d", len(records))
+	}
+}
+
+func TestHistoryStorePrunes(t *testing.T) {
+	path, cleanup := tempHistoryFile(t)
+	defer cleanup()
+	start := time.Date(2020, 3, 2, 0, 0, 0, 0, time.UTC)
+	err := appendHistory(path, historyRecordAt(start, 0.1, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := newHistoryStore(path, 24*time.Hour)
+	err = store.record(historyRecordAt(start.Add(48*time.Hour), 0.2, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := readHistory(path, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 {
+		t.Fatalf("Expected 1 record after pruning, got %d", len(records))
+	}
+	if records[0].Capcity.UtilizationFactorMemoryRequestsNminusone != 0.2 {
+		t.Errorf("Expected the newest record to survive, got %v", records[0].Capcity.UtilizationFactorMemoryRequestsNminusone)
+	}
+}
+
+func TestParseRetention(t *testing.T) {
+	cases := map[string]time.Duration{
+		"30d": 30 * 24 * time.Hour,
+		"2w":  14 * 24 * time.Hour,
+		"90m": 90 * time.Minute,
+		"0":   0,
+	}
+	for value, expected := range cases {
+		actual, err := parseRetention(value)
+		if err != nil {
+			t.Errorf("Unexpected error for %s: %s", value, err)
+		}
+		if actual != expected {
+			t.Errorf("Expected %s for %s, got %s", expected, value, actual)
+		}
+	}
+	_, err := parseRetention("xd")
+	if err == nil {
+		t.Errorf("Expected an error for xd")
+	}
+}
+
+func TestQueryHistory(t *testing.T) {
+	start := time.Date(2020, 3, 2, 0, 0, 0, 0, time.UTC)
+	records := []HistoryRecord{
+		historyRecordAt(start, 0.2, 100),
+		historyRecordAt(start.Add(time.Hour), 0.4, 300),
+		historyRecordAt(start.Add(24*time.Hour), 0.6, 500),
+	}
+	points, err := queryHistory(records, "k8s_quota.utilization_factor.memory_request.nminusone", 24*time.Hour, "avg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 2 {
+		t.Fatalf("Expected 2 buckets, got %d", len(points))
+	}
+	if points[0].Value < 0.299 || points[0].Value > 0.301 || points[0].Samples != 2 {
+		t.Errorf("Expected avg 0.3 over 2 samples, got %v over %d", points[0].Value, points[0].Samples)
+	}
+	points, err = queryHistory(records, "namespace.default.memory_requests.bytes", 7*24*time.Hour, "min")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 1 || points[0].Value != 100 {
+		t.Errorf("Expected a single weekly min of 100, got %v", points)
+	}
+	_, err = queryHistory(records, "utilization_factor.pods.total", time.Hour, "median")
+	if err == nil {
+		t.Errorf("Expected an error for an unknown aggregation")
+	}
+}
+
+func TestHistoryHumanModeEmpty(t *testing.T) {
+	output := historyHumanMode(nil, "utilization_factor.pods.total", "avg")
+	compareString(output[0], "utilization_factor.pods.total (avg)", t)
+	compareString(output[2], "No history recorded for utilization_factor.pods.total", t)
+}
+
+func TestHistoryRecordNodePools(t *testing.T) {
+	clusterInfo := ClusterInfo{NodeInfo: map[string]NodeInfo{
+		"node-a": {PrintOutput: true, AllocatableCPU: resource.MustParse("8"), AllocatablePods: resource.MustParse("110"),
+			Labels: map[string]string{"cloud.google.com/gke-nodepool": "compute"}},
+		"node-b": {PrintOutput: true, AllocatableCPU: resource.MustParse("4"), AllocatablePods: resource.MustParse("110")},
+	}}
+	start := time.Date(2020, 3, 2, 0, 0, 0, 0, time.UTC)
+	record := newHistoryRecord(clusterInfo, calculateCapcity(clusterInfo), "", start)
+	if len(record.NodePools) != 2 {
+		t.Fatalf("Expected the compute and <none> pools, got %v", record.NodePools)
+	}
+	record.NodePools["m5.xlarge"] = NodePoolCapacity{Name: "m5.xlarge", AllocatablePods: 58}
+	points, err := queryHistory([]HistoryRecord{record}, "node_pool.compute.allocatable.cpu.millicores", time.Hour, "last")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 1 || points[0].Value != 8000 {
+		t.Errorf("Expected 8000 allocatable millicores in compute, got %v", points)
+	}
+	if value, ok := historyValue(record, "k8s_quota.node_pool.m5.xlarge.allocatable.pods"); !ok || value != 58 {
+		t.Errorf("Expected the pods of a pool with a dotted name, got %v", value)
+	}
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// htmlTrendWindow is how much history the report's sparklines cover
+const htmlTrendWindow = 30 * 24 * time.Hour
+
+// htmlTopNamespaces is how many namespaces the report lists
+const htmlTopNamespaces = 10
+
+// htmlReport : Everything the static html capacity report shows
+type htmlReport struct {
+	Title      string
+	Generated  string
+	Capcity    Capcity
+	Cards      []htmlCard
+	Nodes      []htmlNode
+	Headroom   []htmlHeadroom
+	Namespaces []htmlNamespace
+	Quota      []htmlQuota
+	Trends     []htmlTrend
+}
+
+type htmlCard struct {
+	Label   string
+	Value   string
+	Detail  string
+	Percent float64
+}
+
+// htmlBar : Requests and usage as a share of allocatable
+type htmlBar struct {
+	Requests float64
+	Used     float64
+	Label    string
+}
+
+// htmlNode : Cpu, memory and pods bars of one node
+type htmlNode struct {
+	Name string
+	Bars []htmlBar
+}
+
+type htmlHeadroom struct {
+	Resource  string
+	Total     string
+	Nminusone string
+	Percent   float64
+}
+
+type htmlNamespace struct {
+	Name           string
+	Pods           int64
+	CPURequests    string
+	MemoryRequests string
+	MemoryShare    float64
+}
+
+type htmlQuota struct {
+	Resource  string
+	Quota     string
+	Total     float64
+	Nminusone float64
+}
+
+type htmlTrend struct {
+	Label     string
+	Last      string
+	Sparkline template.HTML
+}
+
+func percentOf(part, whole int64) float64 {
+	if whole == 0 {
+		return 0
+	}
+	return float64(part) * 100 / float64(whole)
+}
+
+// clamp keeps bar widths inside their box
+func clamp(percent float64) float64 {
+	return math.Max(0, math.Min(100, percent))
+}
+
+func newHTMLReport(clusterInfo ClusterInfo, capCity Capcity, now time.Time) *htmlReport {
+	report := &htmlReport{
+		Title:     "Capacity report",
+		Generated: now.UTC().Format(time.RFC1123),
+		Capcity:   capCity,
+	}
+	if capCity.ClusterName != "" {
+		report.Title = "Capacity report for " + capCity.ClusterName
+	}
+	if clusterInfo.NodeSelector != "" {
+		report.Title = report.Title + " (" + clusterInfo.NodeSelector + ")"
+	}
+
+	nodes := nodeCapacities(clusterInfo)
+	report.Cards = []htmlCard{
+		{Label: "Nodes", Value: fmt.Sprintf("%d", len(nodes))},
+		{Label: "CPU requested", Value: fmt.Sprintf("%.0f%%", capCity.UtilizationFactorCPURequestsTotal*100),
+			Detail: fmt.Sprintf("%.0f%% of N-1", capCity.UtilizationFactorCPURequestsNminusone*100), Percent: clamp(capCity.UtilizationFactorCPURequestsTotal * 100)},
+		{Label: "Memory requested", Value: fmt.Sprintf("%.0f%%", capCity.UtilizationFactorMemoryRequestsTotal*100),
+			Detail: fmt.Sprintf("%.0f%% of N-1", capCity.UtilizationFactorMemoryRequestsNminusone*100), Percent: clamp(capCity.UtilizationFactorMemoryRequestsTotal * 100)},
+		{Label: "Pods", Value: fmt.Sprintf("%.0f%%", capCity.UtilizationFactorPodsTotal*100),
+			Detail: fmt.Sprintf("%d of %d", capCity.ContainerResourcePods, capCity.AllocatablePodsTotal), Percent: clamp(capCity.UtilizationFactorPodsTotal * 100)},
+	}
+
+	for _, node := range nodes {
+		cpu := htmlBar{
+			Requests: clamp(percentOf(node.CPURequestMilliCores, node.AllocatableCPUMilliCores)),
+			Used:     clamp(percentOf(node.UsedCPUMilliCores, node.AllocatableCPUMilliCores)),
+			Label:    fmt.Sprintf("%dm requested, %dm used of %dm", node.CPURequestMilliCores, node.UsedCPUMilliCores, node.AllocatableCPUMilliCores),
+		}
+		memory := htmlBar{
+			Requests: clamp(percentOf(node.MemoryRequest, node.AllocatableMemory)),
+			Used:     clamp(percentOf(node.UsedMemory, node.AllocatableMemory)),
+			Label:    fmt.Sprintf("%.1fGi requested, %.1fGi used of %.1fGi", toGibFromByte(node.MemoryRequest), toGibFromByte(node.UsedMemory), toGibFromByte(node.AllocatableMemory)),
+		}
+		pods := htmlBar{
+			Requests: clamp(percentOf(node.Pods, node.AllocatablePods)),
+			Label:    fmt.Sprintf("%d of %d pods", node.Pods, node.AllocatablePods),
+		}
+		report.Nodes = append(report.Nodes, htmlNode{Name: node.Name, Bars: []htmlBar{cpu, memory, pods}})
+	}
+
+	report.Headroom = []htmlHeadroom{
+		{Resource: "CPU", Total: fmt.Sprintf("%d cores", capCity.AvailableCPURequestTotal), Nminusone: fmt.Sprintf("%d cores", capCity.AvailableCPURequestNminusone),
+			Percent: clamp(percentOf(capCity.AvailableCPURequestNminusone, capCity.AllocatableCPUNminusone))},
+		{Resource: "Memory", Total: fmt.Sprintf("%.1fGi", toGibFromByte(capCity.AvailableMemoryRequestTotal)), Nminusone: fmt.Sprintf("%.1fGi", toGibFromByte(capCity.AvailableMemoryRequestNminusone)),
+			Percent: clamp(percentOf(capCity.AvailableMemoryRequestNminusone, capCity.AllocatableMemoryNminusone))},
+		{Resource: "Pods", Total: fmt.Sprintf("%d", capCity.AvailablePodsTotal), Nminusone: fmt.Sprintf("%d", capCity.AvailablePodsNminusone),
+			Percent: clamp(percentOf(capCity.AvailablePodsNminusone, capCity.AllocatablePodsNminusone))},
+	}
+
+	var names []string
+	for name := range clusterInfo.NamespaceTotals {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		a, b := clusterInfo.NamespaceTotals[names[i]], clusterInfo.NamespaceTotals[names[j]]
+		if a.MemoryRequests != b.MemoryRequests {
+			return a.MemoryRequests > b.MemoryRequests
+		}
+		return names[i] < names[j]
+	})
+	if len(names) > htmlTopNamespaces {
+		names = names[:htmlTopNamespaces]
+	}
+	for _, name := range names {
+		totals := clusterInfo.NamespaceTotals[name]
+		report.Namespaces = append(report.Namespaces, htmlNamespace{
+			Name:           name,
+			Pods:           totals.Pods,
+			CPURequests:    fmt.Sprintf("%dm", totals.CPURequestsMilliCores),
+			MemoryRequests: fmt.Sprintf("%.1fGi", toGibFromByte(totals.MemoryRequests)),
+			MemoryShare:    clamp(percentOf(totals.MemoryRequests, capCity.AllocatableMemoryTotal)),
+		})
+	}
+
+	report.Quota = []htmlQuota{
+		{Resource: "CPU requests", Quota: fmt.Sprintf("%dm", capCity.ResourceQuotaCPURequestMilliCores),
+			Total: capCity.SubscriptionFactorCPURequestTotal * 100, Nminusone: capCity.SubscriptionFactorCPURequestNminusone * 100},
+		{Resource: "Memory requests", Quota: fmt.Sprintf("%.1fGi", toGibFromByte(capCity.ResourceQuotaMemoryRequest)),
+			Total: capCity.SubscriptionFactorMemoryRequestTotal * 100, Nminusone: capCity.SubscriptionFactorMemoryRequestNminusone * 100},
+		{Resource: "Pods", Quota: fmt.Sprintf("%d", capCity.ResourceQuotaPods),
+			Total: capCity.SubscriptionFactorPodsTotal * 100, Nminusone: capCity.SubscriptionFactorPodsNminusone * 100},
+	}
+	return report
+}
+
+// htmlTrendFields are the history fields the report draws sparklines of
+var htmlTrendFields = []struct {
+	label, field string
+	percent      bool
+}{
+	{"CPU requested of N-1", "utilization_factor.cpu_request.nminusone", true},
+	{"Memory requested of N-1", "utilization_factor.memory_request.nminusone", true},
+	{"Pods of N-1", "utilization_factor.pods.nminusone", true},
+	{"Available memory N-1 (GiB)", "available.memory_request.nminusone", false},
+}
+
+// addTrends draws a sparkline per trend field from the records of these selected nodes
+func (r *htmlReport) addTrends(records []HistoryRecord, nodeSelector string) {
+	for _, trend := range htmlTrendFields {
+		var values []float64
+		for _, record := range records {
+			if record.NodeSelector != nodeSelector {
+				continue
+			}
+			if value, ok := historyValue(record, trend.field); ok {
+				if !trend.percent {
+					value = toGibFromByte(int64(value))
+				}
+				values = append(values, value)
+			}
+		}
+		if len(values) < 2 {
+			continue
+		}
+		last := fmt.Sprintf("%.1f", values[len(values)-1])
+		if trend.percent {
+			last = fmt.Sprintf("%.0f%%", values[len(values)-1]*100)
+		}
+		r.Trends = append(r.Trends, htmlTrend{Label: trend.label, Last: last, Sparkline: sparkline(values, 160, 32)})
+	}
+}
+
+// sparkline is an inline svg polyline of values scaled to width by height
+func sparkline(values []float64, width, height float64) template.HTML {
+	low, high := values[0], values[0]
+	for _, value := range values {
+		low = math.Min(low, value)
+		high = math.Max(high, value)
+	}
+	points := make([]string, len(values))
+	for i, value := range values {
+		x := width * float64(i) / float64(len(values)-1)
+		y := height / 2
+		if high > low {
+			y = height - 2 - (height-4)*(value-low)/(high-low)
+		}
+		points[i] = fmt.Sprintf("%.1f,%.1f", x, y)
+	}
+	return template.HTML(fmt.Sprintf(`<svg width="%.0f" height="%.0f" viewBox="0 0 %.0f %.0f"><polyline fill="none" stroke="#2563eb" stroke-width="1.5" points="%s"/></svg>`,
+		width, height, width, height, strings.Join(points, " ")))
+}
+
+var htmlFuncs = template.FuncMap{
+	"percent": func(value float64) string { return fmt.Sprintf("%.0f%%", value) },
+	"level": func(value float64) string {
+		switch {
+		case value >= 90:
+			return "critical"
+		case value >= 75:
+			return "warning"
+		}
+		return "ok"
+	},
+}
+
+var htmlReportTemplate = template.Must(template.New("report").Funcs(htmlFuncs).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{ .Title }}</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; color: #1f2937; margin: 2em auto; max-width: 1100px; padding: 0 1em; }
+h1 { margin-bottom: 0; }
+h2 { margin-top: 2em; border-bottom: 1px solid #e5e7eb; padding-bottom: .3em; }
+.generated { color: #6b7280; }
+.cards { display: flex; flex-wrap: wrap; gap: 1em; }
+.card { border: 1px solid #e5e7eb; border-radius: 6px; padding: 1em; min-width: 200px; flex: 1; }
+.card .value { font-size: 2em; font-weight: 600; }
+.card .label, .card .detail { color: #6b7280; }
+table { border-collapse: collapse; width: 100%; }
+th, td { text-align: left; padding: .4em .6em; border-bottom: 1px solid #f3f4f6; vertical-align: middle; }
+th { color: #6b7280; font-weight: 600; }
+.bar { position: relative; background: #f3f4f6; border-radius: 3px; height: 12px; min-width: 120px; }
+.bar .requests { position: absolute; left: 0; top: 0; bottom: 0; border-radius: 3px; }
+.bar .used { position: absolute; top: -3px; bottom: -3px; width: 2px; background: #111827; }
+.ok { background: #16a34a; }
+.warning { background: #d97706; }
+.critical { background: #dc2626; }
+.legend { color: #6b7280; font-size: .9em; }
+</style>
+</head>
+<body>
+<h1>{{ .Title }}</h1>
+<p class="generated">Generated {{ .Generated }}</p>
+
+<div class="cards">
+{{- range .Cards }}
+<div class="card"><div class="label">{{ .Label }}</div><div class="value">{{ .Value }}</div>
+{{- if .Percent }}<div class="bar"><div class="requests {{ level .Percent }}" style="width: {{ .Percent }}%"></div></div>{{ end }}
+<div class="detail">{{ .Detail }}</div></div>
+{{- end }}
+</div>
+
+<h2>N-1 headroom</h2>
+<p class="legend">What is left to request with the largest node gone</p>
+<table>
+<tr><th>Resource</th><th>Available</th><th>Available N-1</th><th>Share of N-1 left</th></tr>
+{{- range .Headroom }}
+<tr><td>{{ .Resource }}</td><td>{{ .Total }}</td><td>{{ .Nminusone }}</td><td>{{ percent .Percent }}</td></tr>
+{{- end }}
+</table>
+
+<h2>Nodes</h2>
+<p class="legend">Bars are requests as a share of allocatable, the dark mark is actual usage</p>
+<table>
+<tr><th>Node</th><th>CPU</th><th>Memory</th><th>Pods</th></tr>
+{{- range .Nodes }}
+<tr><td>{{ .Name }}</td>
+{{- range $bar := .Bars }}
+<td title="{{ $bar.Label }}"><div class="bar"><div class="requests {{ level $bar.Requests }}" style="width: {{ $bar.Requests }}%"></div>{{ if $bar.Used }}<div class="used" style="left: {{ $bar.Used }}%"></div>{{ end }}</div></td>
+{{- end }}
+</tr>
+{{- end }}
+</table>
+
+{{- if .Namespaces }}
+<h2>Top namespaces</h2>
+<table>
+<tr><th>Namespace</th><th>Pods</th><th>CPU requests</th><th>Memory requests</th><th>Share of memory</th></tr>
+{{- range .Namespaces }}
+<tr><td>{{ .Name }}</td><td>{{ .Pods }}</td><td>{{ .CPURequests }}</td><td>{{ .MemoryRequests }}</td>
+<td><div class="bar"><div class="requests ok" style="width: {{ .MemoryShare }}%"></div></div></td></tr>
+{{- end }}
+</table>
+{{- end }}
+
+<h2>Quota subscription</h2>
+<p class="legend">ResourceQuota hard limits as a share of allocatable, above 100% the quotas promise more than the nodes have</p>
+<table>
+<tr><th>Resource</th><th>Quota</th><th>Total</th><th>N-1</th></tr>
+{{- range .Quota }}
+<tr><td>{{ .Resource }}</td><td>{{ .Quota }}</td><td>{{ percent .Total }}</td><td>{{ percent .Nminusone }}</td></tr>
+{{- end }}
+</table>
+
+{{- if .Trends }}
+<h2>Trends</h2>
+<table>
+<tr><th>Figure</th><th>Last</th><th>Last 30 days</th></tr>
+{{- range .Trends }}
+<tr><td>{{ .Label }}</td><td>{{ .Last }}</td><td>{{ .Sparkline }}</td></tr>
+{{- end }}
+</table>
+{{- end }}
+</body>
+</html>
+`))
+
+// htmlTablesTemplate is the html output of views without a report of their own
+var htmlTablesTemplate = template.Must(template.New("tables").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>k8sCapcity</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; color: #1f2937; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { text-align: left; padding: .3em .8em; border-bottom: 1px solid #e5e7eb; }
+th { color: #6b7280; }
+</style>
+</head>
+<body>
+{{- range . }}
+<table>
+<tr>{{ range .Columns }}<th>{{ . }}</th>{{ end }}</tr>
+{{- range .Rows }}
+<tr>{{ range . }}<td>{{ . }}</td>{{ end }}</tr>
+{{- end }}
+</table>
+{{- end }}
+</body>
+</html>
+`))
+
+// renderHTML writes a single html file with inline css and svg, no external assets
+func renderHTML(out io.Writer, v view) error {
+	if v.html != nil {
+		return htmlReportTemplate.Execute(out, v.html)
+	}
+	var tables []struct {
+		Columns []string
+		Rows    [][]string
+	}
+	for _, t := range v.tables {
+		columns, rows := t.visible(true)
+		tables = append(tables, struct {
+			Columns []string
+			Rows    [][]string
+		}{columns, rows})
+	}
+	return htmlTablesTemplate.Execute(out, tables)
+}
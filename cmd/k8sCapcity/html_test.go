@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestHTMLReport(t *testing.T) {
+	clusterInfo := ClusterInfo{
+		NodeInfo: map[string]NodeInfo{
+			"node-a": {PrintOutput: true, UsedCPURequests: resource.MustParse("3"), UsedCPU: resource.MustParse("1"), UsedPods: 11},
+			"node-b": {PrintOutput: true},
+		},
+		NamespaceTotals: map[string]NamespaceTotals{
+			"<script>": {Pods: 1, MemoryRequests: 1 << 30},
+			"web":      {Pods: 10, MemoryRequests: 4 << 30},
+		},
+	}
+	addNodeAllocatable(&clusterInfo, clusterInfo.NodeInfo, *testNode("node-a", nil, "4", "16Gi"))
+	addNodeAllocatable(&clusterInfo, clusterInfo.NodeInfo, *testNode("node-b", nil, "4", "16Gi"))
+	capCity := calculateCapcity(clusterInfo)
+	capCity.ClusterName = "prod"
+	v := clusterView(clusterInfo, capCity)
+
+	now := time.Date(2020, 3, 2, 0, 0, 0, 0, time.UTC)
+	var records []HistoryRecord
+	for i, factor := range []float64{0.5, 0.6, 0.7} {
+		records = append(records, HistoryRecord{Timestamp: now.Add(time.Duration(i) * time.Hour),
+			Capcity: Capcity{UtilizationFactorMemoryRequestsNminusone: factor}})
+	}
+	records = append(records, HistoryRecord{Timestamp: now, NodeSelector: "role=other", Capcity: Capcity{UtilizationFactorMemoryRequestsNminusone: 0.1}})
+	v.html.addTrends(records, "")
+
+	var out bytes.Buffer
+	err := render(&out, "html", v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := out.String()
+	for _, expected := range []string{
+		"<title>Capacity report for prod</title>",
+		`<td title="3000m requested, 1000m used of 4000m"><div class="bar"><div class="requests warning" style="width: 75%"></div><div class="used" style="left: 25%"></div></div></td>`,
+		"<td>web</td><td>10</td>",
+		"&lt;script&gt;",
+		"<td>Memory requested of N-1</td><td>70%</td><td><svg",
+		`points="0.0,30.0 80.0,16.0 160.0,2.0"`,
+	} {
+		if !strings.Contains(report, expected) {
+			t.Errorf("Expected %q in\n%s", expected, report)
+		}
+	}
+	// Web comes first, it requests the most memory
+	if strings.Index(report, "<td>web</td>") > strings.Index(report, "&lt;script&gt;") {
+		t.Errorf("Expected namespaces by memory requests")
+	}
+	// The role=other record is left out, flat trends draw a level line
+	if strings.Count(report, "<svg") != 4 || !strings.Contains(report, `points="0.0,16.0 80.0,16.0 160.0,16.0"`) {
+		t.Errorf("Expected four sparklines, got %d", strings.Count(report, "<svg"))
+	}
+	if strings.Contains(report, "src=") || strings.Contains(report, "href=") {
+		t.Errorf("Expected no external assets")
+	}
+}
+
+func TestHTMLTables(t *testing.T) {
+	var out bytes.Buffer
+	err := render(&out, "html", namespacesView(map[string]NamespaceTotals{"web": {Pods: 2}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "<tr><th>NAMESPACE</th><th>PODS</th>") || !strings.Contains(out.String(), "<tr><td>web</td><td>2</td>") {
+		t.Errorf("Expected the namespaces table, got %s", out.String())
+	}
+}
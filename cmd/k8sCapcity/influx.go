@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// influxOptions : Where the daemon writes its gauges as Influx line protocol
+type influxOptions struct {
+	output  string
+	token   string
+	timeout time.Duration
+}
+
+func addInfluxFlags(flags *flag.FlagSet) *influxOptions {
+	o := &influxOptions{}
+	flags.StringVar(&o.output, "influx-output", "", "In daemon mode, also write the capacity gauges as Influx line protocol to - for stdout, a file it is appended to, or a write endpoint url, e.g. http://influxdb:8086/api/v2/write?org=ops&bucket=capacity")
+	flags.StringVar(&o.token, "influx-token", "", "Token for an -influx-output url, K8SCAPCITY_INFLUX_TOKEN keeps it off the command line")
+	flags.DurationVar(&o.timeout, "influx-timeout", 10*time.Second, "Give up on a write to an -influx-output url after this long")
+	return o
+}
+
+func (o *influxOptions) validate() error {
+	if !strings.Contains(o.output, "://") {
+		return nil
+	}
+	u, err := url.Parse(o.output)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("-influx-output %q is not an http or https url", o.output)
+	}
+	return nil
+}
+
+// influxSink : Writes line protocol, one line per group, to stdout, a file or a write endpoint
+type influxSink struct {
+	influxOptions
+	stdout io.Writer
+	client *http.Client
+}
+
+func newInfluxSink(o influxOptions) *influxSink {
+	return &influxSink{influxOptions: o, stdout: os.Stdout, client: &http.Client{Timeout: o.timeout}}
+}
+
+func (s *influxSink) String() string {
+	return s.output
+}
+
+func (s *influxSink) write(groups []metricGroup, now time.Time) error {
+	body := influxLines(groups, now)
+	switch {
+	case s.output == "-":
+		_, err := s.stdout.Write(body)
+		return err
+	case strings.Contains(s.output, "://"):
+		return s.post(body)
+	}
+	f, err := os.OpenFile(s.output, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(body)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (s *influxSink) post(body []byte) error {
+	u, _ := url.Parse(s.output)
+	query := u.Query()
+	if query.Get("precision") == "" {
+		query.Set("precision", "ns")
+		u.RawQuery = query.Encode()
+	}
+	req, err := http.NewRequest(http.MethodPost, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	req.Header.Set("User-Agent", "k8sCapcity")
+	if s.token != "" {
+		req.Header.Set("Authorization", "Token "+s.token)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("%s returned %s %s", u.Host, resp.Status, strings.TrimSpace(string(data)))
+	}
+	return nil
+}
+
+var (
+	influxMeasurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	influxKeyEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+)
+
+// influxLines is a line per group: the group's prefix is the measurement,
+// its attributes the tags and its gauges the fields, e.g.
+// k8s_quota.node,k8s.cluster.name=prod,k8s.node.name=node-a allocatable.pods=110 1583452800000000000
+func influxLines(groups []metricGroup, now time.Time) []byte {
+	body := &bytes.Buffer{}
+	for _, group := range groups {
+		if len(group.gauges) == 0 {
+			continue
+		}
+		body.WriteString(influxMeasurementEscaper.Replace(strings.TrimSuffix(group.prefix, ".")))
+		attributes := append([]metricAttribute{}, group.attributes...)
+		sort.Slice(attributes, func(i, j int) bool { return attributes[i].key < attributes[j].key })
+		for _, attribute := range attributes {
+			if attribute.value != "" {
+				fmt.Fprintf(body, ",%s=%s", influxKeyEscaper.Replace(attribute.key), influxKeyEscaper.Replace(attribute.value))
+			}
+		}
+		for i, gauge := range group.gauges {
+			separator := ","
+			if i == 0 {
+				separator = " "
+			}
+			fmt.Fprintf(body, "%s%s=%s", separator, influxKeyEscaper.Replace(gauge.name), strconv.FormatFloat(gauge.value, 'f', -1, 64))
+		}
+		fmt.Fprintf(body, " %d\n", now.UnixNano())
+	}
+	return body.Bytes()
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// cacheSyncTimeout bounds the initial list of nodes, pods and quotas
+const cacheSyncTimeout = 10 * time.Minute
+
+// clusterCache keeps node and namespace aggregates up to date from watch
+// events, so a daemon cycle reads them instead of listing every pod again.
+// Nodes are read from the informer's own store rather than copied. It holds
+// every node and every non-terminated pod of the cluster whatever -nodelabel
+// selects, the selector only applies when clusterInfo reads it
+type clusterCache struct {
+	sync.Mutex
+	nodes          corelisters.NodeLister
+	pods           map[types.UID]podUsage
+	nodeUsage      map[string]NodeInfo
+	namespaceUsage map[string]map[string]NamespaceTotals
+	factories      []informers.SharedInformerFactory
+	quotas         corelisters.ResourceQuotaLister
+}
+
+func newClusterCache(clientset kubernetes.Interface) *clusterCache {
+	c := &clusterCache{
+		pods:           make(map[types.UID]podUsage),
+		nodeUsage:      make(map[string]NodeInfo),
+		namespaceUsage: make(map[string]map[string]NamespaceTotals),
+	}
+	factory := informers.NewSharedInformerFactory(clientset, 0)
+	podFactory := informers.NewSharedInformerFactoryWithOptions(clientset, 0, informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+		options.FieldSelector = nonTerminatedPodSelector
+	}))
+	c.factories = []informers.SharedInformerFactory{factory, podFactory}
+
+	factory.Core().V1().Nodes().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		DeleteFunc: c.onNodeDeleted,
+	})
+	c.nodes = factory.Core().V1().Nodes().Lister()
+	podFactory.Core().V1().Pods().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { c.onPod(obj, false) },
+		UpdateFunc: func(_, obj interface{}) { c.onPod(obj, false) },
+		DeleteFunc: func(obj interface{}) { c.onPod(obj, true) },
+	})
+	c.quotas = factory.Core().V1().ResourceQuotas().Lister()
+	return c
+}
+
+// start runs the informers until stop is closed and waits for the initial list
+func (c *clusterCache) start(stop <-chan struct{}, timeout time.Duration) error {
+	synced := make(chan bool, 1)
+	go func() {
+		ok := true
+		for _, factory := range c.factories {
+			factory.Start(stop)
+			for _, result := range factory.WaitForCacheSync(stop) {
+				ok = ok && result
+			}
+		}
+		synced <- ok
+	}()
+	select {
+	case ok := <-synced:
+		if !ok {
+			return fmt.Errorf("informer caches did not sync")
+		}
+		return nil
+	case <-time.After(timeout):
+		return fmt.Errorf("informer caches did not sync within %s", timeout)
+	}
+}
+
+// watchCache : The clusterCache of a daemon, started by the first cycle that
+// collects and stopped when this replica stops leading, so standbys watch
+// nothing. Reloads keep it
+type watchCache struct {
+	sync.Mutex
+	clientset kubernetes.Interface
+	cache     *clusterCache
+	stopCache chan struct{}
+}
+
+func newWatchCache(clientset kubernetes.Interface) *watchCache {
+	return &watchCache{clientset: clientset}
+}
+
+// get returns the running cache, starting it and waiting for the initial
+// list when there is none
+func (w *watchCache) get() (*clusterCache, error) {
+	w.Lock()
+	defer w.Unlock()
+	if w.cache != nil {
+		return w.cache, nil
+	}
+	log.Info("Starting the watch cache of nodes, pods and quotas")
+	cache, stop := newClusterCache(w.clientset), make(chan struct{})
+	err := cache.start(stop, cacheSyncTimeout)
+	if err != nil {
+		close(stop)
+		return nil, err
+	}
+	w.cache, w.stopCache = cache, stop
+	return cache, nil
+}
+
+// stop ends the watches, the next get starts them again
+func (w *watchCache) stop() {
+	w.Lock()
+	defer w.Unlock()
+	if w.cache == nil {
+		return
+	}
+	log.Info("Stopping the watch cache")
+	close(w.stopCache)
+	w.cache, w.stopCache = nil, nil
+}
+
+func deletedObject(obj interface{}) interface{} {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		return tombstone.Obj
+	}
+	return obj
+}
+
+// onNodeDeleted forgets the node's usage, or leaves that to onPod when
+// the node still has pods
+func (c *clusterCache) onNodeDeleted(obj interface{}) {
+	node, ok := deletedObject(obj).(*corev1.Node)
+	if !ok {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	if c.nodeUsage[node.Name].UsedPods == 0 {
+		delete(c.nodeUsage, node.Name)
+	}
+}
+
+// onPod takes back whatever the pod added last time and adds its current usage
+func (c *clusterCache) onPod(obj interface{}, deleted bool) {
+	pod, ok := deletedObject(obj).(*corev1.Pod)
+	if !ok {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	if old, ok := c.pods[pod.UID]; ok {
+		node := c.nodeUsage[old.nodeName]
+		nsTotals := c.namespaceUsage[old.nodeName][old.namespace]
+		old.subtractFrom(&node, &nsTotals)
+		c.nodeUsage[old.nodeName] = node
+		c.setNamespaceUsage(old.nodeName, old.namespace, nsTotals)
+		delete(c.pods, pod.UID)
+		// The last pod of a node deleted before its pods
+		if node.UsedPods == 0 {
+			if _, err := c.nodes.Get(old.nodeName); errors.IsNotFound(err) {
+				delete(c.nodeUsage, old.nodeName)
+			}
+		}
+	}
+	if deleted || pod.Spec.NodeName == "" {
+		return
+	}
+	usage, ok := podUsageOf(pod)
+	if !ok {
+		return
+	}
+	node := c.nodeUsage[usage.nodeName]
+	nsTotals := c.namespaceUsage[usage.nodeName][usage.namespace]
+	usage.addTo(&node, &nsTotals)
+	c.nodeUsage[usage.nodeName] = node
+	c.setNamespaceUsage(usage.nodeName, usage.namespace, nsTotals)
+	c.pods[pod.UID] = usage
+}
+
+func (c *clusterCache) setNamespaceUsage(nodeName, namespace string, nsTotals NamespaceTotals) {
+	byNamespace, ok := c.namespaceUsage[nodeName]
+	if !ok {
+		byNamespace = make(map[string]NamespaceTotals)
+		c.namespaceUsage[nodeName] = byNamespace
+	}
+	if nsTotals.Pods == 0 {
+		delete(byNamespace, namespace)
+		if len(byNamespace) == 0 {
+			delete(c.namespaceUsage, nodeName)
+		}
+		return
+	}
+	byNamespace[namespace] = nsTotals
+}
+
+// clusterInfo assembles the same ClusterInfo gatherInfo builds for the nodes
+// nodeLabel selects, less node metrics, from the cached aggregates
+func (c *clusterCache) clusterInfo(nodeLabel string) (clusterInfo ClusterInfo) {
+	// -nodelabel was parsed when it was set
+	selector, err := parseNodeSelector(nodeLabel)
+	check(err)
+	c.Lock()
+	defer c.Unlock()
+	clusterInfo.NodeSelector = nodeLabel
+	clusterInfo.NodeLabel = selectorKeys(selector)
+	nodeInfo := make(map[string]NodeInfo)
+
+	nodes, err := c.nodes.List(labels.Everything())
+	check(err)
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Name < nodes[j].Name })
+	for _, v := range nodes {
+		name := v.Name
+		node := c.nodeUsage[name]
+		node.UsedMemoryRequests = node.UsedMemoryRequests.DeepCopy()
+		node.UsedMemoryLimits = node.UsedMemoryLimits.DeepCopy()
+		node.UsedCPURequests = node.UsedCPURequests.DeepCopy()
+		node.PrintOutput = nodeSelected(*v, selector)
+		nodeInfo[name] = node
+		if node.PrintOutput {
+			addNodeAllocatable(&clusterInfo, nodeInfo, *v)
+		}
+	}
+
+	quotas, err := c.quotas.List(labels.Everything())
+	check(err)
+	for _, quota := range quotas {
+		addResourceQuota(&clusterInfo, *quota)
+	}
+
+	clusterInfo.NodeInfo = nodeInfo
+	clusterInfo.NamespaceTotals = selectedNamespaceTotals(nodeInfo, c.namespaceUsage)
+	return clusterInfo
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func testNode(name string, labels map[string]string, cpu, memory string) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels},
+		Status: corev1.NodeStatus{
+			Allocatable: corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse(cpu),
+				corev1.ResourceMemory: resource.MustParse(memory),
+				corev1.ResourcePods:   resource.MustParse("110"),
+			},
+		},
+	}
+}
+
+func testPod(namespace, name, nodeName, cpu, memory string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, UID: types.UID(namespace + "/" + name)},
+		Spec: corev1.PodSpec{
+			NodeName: nodeName,
+			Containers: []corev1.Container{{
+				Name: "app",
+				Resources: corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{
+						corev1.ResourceCPU:    resource.MustParse(cpu),
+						corev1.ResourceMemory: resource.MustParse(memory),
+					},
+				},
+			}},
+		},
+		Status: corev1.PodStatus{Phase: corev1.PodRunning},
+	}
+}
+
+func TestClusterCacheHandlers(t *testing.T) {
+	c := newClusterCache(fake.NewSimpleClientset())
+	nodes := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	nodes.Add(testNode("node-a", map[string]string{"pool": "a"}, "4", "16Gi"))
+	nodes.Add(testNode("node-b", map[string]string{"pool": "b"}, "8", "32Gi"))
+	c.nodes = corelisters.NewNodeLister(nodes)
+	pod := testPod("default", "web", "node-a", "500m", "1Gi")
+	c.onPod(pod, false)
+	c.onPod(testPod("default", "db", "node-b", "1", "2Gi"), false)
+
+	clusterInfo := c.clusterInfo("pool=a")
+	nodeA := clusterInfo.NodeInfo["node-a"]
+	if !nodeA.PrintOutput || nodeA.UsedPods != 1 || nodeA.UsedCPURequests.MilliValue() != 500 {
+		t.Errorf("Unexpected node-a %+v", nodeA)
+	}
+	if clusterInfo.NodeInfo["node-b"].PrintOutput {
+		t.Errorf("Expected node-b to be outside pool=a")
+	}
+	if clusterInfo.ClusterAllocatableCPU.Value() != 4 {
+		t.Errorf("Expected only node-a allocatable, got %s", &clusterInfo.ClusterAllocatableCPU)
+	}
+	if clusterInfo.NamespaceTotals["default"].Pods != 1 {
+		t.Errorf("Expected the db pod on node-b to be left out, got %+v", clusterInfo.NamespaceTotals)
+	}
+	// The same cache answers for another -nodelabel after a reload
+	clusterInfo = c.clusterInfo("pool=b")
+	if clusterInfo.NodeSelector != "pool=b" || clusterInfo.NamespaceTotals["default"].MemoryRequests != 2<<30 {
+		t.Errorf("Expected only the db pod for pool=b, got %+v", clusterInfo.NamespaceTotals)
+	}
+
+	// Update: the pod now requests more, it must replace not add to its old usage
+	bigger := testPod("default", "web", "node-a", "2", "4Gi")
+	c.onPod(bigger, false)
+	nodeA = c.clusterInfo("pool=a").NodeInfo["node-a"]
+	if nodeA.UsedPods != 1 || nodeA.UsedCPURequests.MilliValue() != 2000 {
+		t.Errorf("Expected the update to replace the pod usage, got %+v", nodeA)
+	}
+
+	// Succeeded pods stop counting
+	bigger.Status.Phase = corev1.PodSucceeded
+	c.onPod(bigger, false)
+	nodeA = c.clusterInfo("pool=a").NodeInfo["node-a"]
+	if nodeA.UsedPods != 0 || nodeA.UsedCPURequests.MilliValue() != 0 {
+		t.Errorf("Expected the succeeded pod to be taken off, got %+v", nodeA)
+	}
+	if _, ok := c.clusterInfo("pool=a").NamespaceTotals["default"]; ok {
+		t.Errorf("Expected no namespace totals left for pool=a")
+	}
+}
+
+func TestClusterCacheNodeDeletedFirst(t *testing.T) {
+	c := newClusterCache(fake.NewSimpleClientset())
+	nodes := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	node := testNode("node-a", nil, "4", "16Gi")
+	nodes.Add(node)
+	c.nodes = corelisters.NewNodeLister(nodes)
+	web := testPod("default", "web", "node-a", "500m", "1Gi")
+	db := testPod("kube-system", "db", "node-a", "1", "2Gi")
+	c.onPod(web, false)
+	c.onPod(db, false)
+
+	// The informer drops the node from its store before calling the handler
+	nodes.Delete(node)
+	c.onNodeDeleted(node)
+	if _, ok := c.nodeUsage["node-a"]; !ok {
+		t.Fatalf("Expected the usage kept while node-a still has pods")
+	}
+	c.onPod(web, true)
+	if c.nodeUsage["node-a"].UsedPods != 1 {
+		t.Errorf("Expected db left on node-a, got %+v", c.nodeUsage["node-a"])
+	}
+	c.onPod(db, true)
+	if len(c.nodeUsage) != 0 || len(c.namespaceUsage) != 0 {
+		t.Errorf("Expected nothing left of node-a, got %v %v", c.nodeUsage, c.namespaceUsage)
+	}
+}
+
+func TestWatchCache(t *testing.T) {
+	w := newWatchCache(fake.NewSimpleClientset(testNode("node-a", nil, "4", "16Gi")))
+	w.stop()
+	first, err := w.get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, _ := w.get()
+	if again != first {
+		t.Errorf("Expected the running cache back")
+	}
+	w.stop()
+	if w.cache != nil {
+		t.Errorf("Expected no cache after stop")
+	}
+	restarted, err := w.get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restarted == first || len(restarted.clusterInfo("").NodeInfo) != 1 {
+		t.Errorf("Expected a fresh cache with node-a")
+	}
+	w.stop()
+}
+
+func TestClusterCacheInformers(t *testing.T) {
+	clientset := fake.NewSimpleClientset(
+		testNode("node-a", nil, "4", "16Gi"),
+		testPod("default", "web", "node-a", "500m", "1Gi"),
+		&corev1.ResourceQuota{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "quota"},
+			Spec: corev1.ResourceQuotaSpec{Hard: corev1.ResourceList{
+				corev1.ResourceRequestsCPU: resource.MustParse("2"),
+			}},
+		},
+	)
+	c := newClusterCache(clientset)
+	stop := make(chan struct{})
+	defer close(stop)
+	err := c.start(stop, 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clusterInfo := c.clusterInfo("")
+	if clusterInfo.NodeInfo["node-a"].UsedPods != 1 {
+		t.Errorf("Expected the initial list to be cached, got %+v", clusterInfo.NodeInfo)
+	}
+	if clusterInfo.RqclusterAllocatedRequestsCPU.Value() != 2 {
+		t.Errorf("Expected the quota to be cached, got %s", &clusterInfo.RqclusterAllocatedRequestsCPU)
+	}
+
+	_, err = clientset.CoreV1().Pods("default").Create(testPod("default", "api", "node-a", "1", "1Gi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for c.clusterInfo("").NodeInfo["node-a"].UsedPods != 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the watch to add the new pod")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
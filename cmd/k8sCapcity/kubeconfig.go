@@ -0,0 +1,145 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// stringList : A flag that may be given more than once
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringList) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// kubeconfigFlags : Which kubeconfig, context and identity to talk to the cluster with
+type kubeconfigFlags struct {
+	kubeconfig     string
+	context        string
+	cluster        string
+	user           string
+	as             string
+	asGroups       stringList
+	requestTimeout string
+	inCluster      bool
+	// namespace replaces the context's, from the namespace command
+	// argument or the plugin's -n rather than a flag of its own
+	namespace string
+}
+
+func addKubeconfigFlags(flags *flag.FlagSet) *kubeconfigFlags {
+	k := &kubeconfigFlags{}
+	flags.StringVar(&k.kubeconfig, "kubeconfig", "", "Path to the kubeconfig file, by default the KUBECONFIG path list or ~/.kube/config")
+	flags.StringVar(&k.context, "context", "", "The kubeconfig context to use instead of the current one")
+	flags.StringVar(&k.cluster, "cluster", "", "The kubeconfig cluster to use")
+	flags.StringVar(&k.user, "user", "", "The kubeconfig user to use")
+	flags.StringVar(&k.as, "as", "", "Username to impersonate")
+	flags.Var(&k.asGroups, "as-group", "Group to impersonate, can be repeated")
+	flags.StringVar(&k.requestTimeout, "request-timeout", "0", "How long to wait for a single api request, e.g. 30s. 0 waits forever")
+	flags.BoolVar(&k.inCluster, "in-cluster", false, "Use the service account of the pod we run in instead of a kubeconfig")
+	return k
+}
+
+func (k *kubeconfigFlags) loadingRules() *clientcmd.ClientConfigLoadingRules {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = k.kubeconfig
+	return rules
+}
+
+func (k *kubeconfigFlags) overrides() *clientcmd.ConfigOverrides {
+	return &clientcmd.ConfigOverrides{
+		CurrentContext: k.context,
+		Context: clientcmdapi.Context{
+			Cluster:   k.cluster,
+			AuthInfo:  k.user,
+			Namespace: k.namespace,
+		},
+		AuthInfo: clientcmdapi.AuthInfo{
+			Impersonate:       k.as,
+			ImpersonateGroups: k.asGroups,
+		},
+		Timeout: k.requestTimeout,
+	}
+}
+
+// restConfig follows the same loading rules as kubectl. Only when there is no
+// kubeconfig at all, or -in-cluster is given, is the pod service account used
+func (k *kubeconfigFlags) restConfig() (*rest.Config, error) {
+	if k.inCluster {
+		log.Info("Using in-cluster config")
+		return k.inClusterConfig()
+	}
+	rules := k.loadingRules()
+	rawConfig, err := rules.Load()
+	if err != nil {
+		return nil, err
+	}
+	overrides := k.overrides()
+	config, err := clientcmd.NewNonInteractiveClientConfig(*rawConfig, overrides.CurrentContext, overrides, rules).ClientConfig()
+	if clientcmd.IsEmptyConfig(err) {
+		log.Info("No kubeconfig found, falling back to in-cluster config")
+		config, err = k.inClusterConfig()
+		if err != nil {
+			return nil, fmt.Errorf("no kubeconfig found and not running in a cluster: %s", err)
+		}
+		return config, nil
+	}
+	return config, err
+}
+
+// defaultNamespace is the namespace override, else the namespace of the
+// context as kubectl picks it, the pod's own in a cluster or default
+func (k *kubeconfigFlags) defaultNamespace() (string, error) {
+	if k.namespace != "" {
+		return k.namespace, nil
+	}
+	if k.inCluster {
+		return podNamespace(), nil
+	}
+	namespace, _, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(k.loadingRules(), k.overrides()).Namespace()
+	return namespace, err
+}
+
+// inClusterConfig applies the identity and timeout overrides that make sense
+// without a kubeconfig
+func (k *kubeconfigFlags) inClusterConfig() (*rest.Config, error) {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, err
+	}
+	config.Impersonate.UserName = k.as
+	config.Impersonate.Groups = k.asGroups
+	if k.requestTimeout != "" && k.requestTimeout != "0" {
+		timeout, err := parseRequestTimeout(k.requestTimeout)
+		if err != nil {
+			return nil, err
+		}
+		config.Timeout = timeout
+	}
+	return config, nil
+}
+
+// parseRequestTimeout reads a duration like kubectl does, a bare number is seconds
+func parseRequestTimeout(value string) (time.Duration, error) {
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second, nil
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid -request-timeout %q, use a duration like 30s", value)
+	}
+	return timeout, nil
+}
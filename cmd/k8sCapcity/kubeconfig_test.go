@@ -0,0 +1,129 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKubeconfigFlags(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubeconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	first := filepath.Join(dir, "first")
+	second := filepath.Join(dir, "second")
+	err = ioutil.WriteFile(first, []byte(strings.Replace(strings.Replace(testKubeconfig, "%s", "first", 1), "%s", "first-only", 1)), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(second, []byte(`apiVersion: v1
+kind: Config
+clusters:
+- name: second
+  cluster:
+    server: https://second.example.com
+contexts:
+- name: second
+  context: {cluster: second, user: u}
+`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kubeconfigEnv := os.Getenv("KUBECONFIG")
+	defer os.Setenv("KUBECONFIG", kubeconfigEnv)
+	os.Setenv("KUBECONFIG", first+string(os.PathListSeparator)+second)
+
+	k := &kubeconfigFlags{context: "second", as: "jane", asGroups: stringList{"ops", "sre"}, requestTimeout: "30"}
+	config, err := k.restConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareString(config.Host, "https://second.example.com", t)
+	compareString(config.BearerToken, "abc", t)
+	compareString(config.Impersonate.UserName, "jane", t)
+	compareString(strings.Join(config.Impersonate.Groups, ","), "ops,sre", t)
+	if config.Timeout != 30*time.Second {
+		t.Errorf("Expected a 30s request timeout, got %s", config.Timeout)
+	}
+
+	k = &kubeconfigFlags{kubeconfig: first, cluster: "missing"}
+	_, err = k.restConfig()
+	if err == nil {
+		t.Errorf("Expected an unknown -cluster to fail instead of falling back")
+	}
+}
+
+func TestKubeconfigNoConfig(t *testing.T) {
+	kubeconfigEnv := os.Getenv("KUBECONFIG")
+	defer os.Setenv("KUBECONFIG", kubeconfigEnv)
+	home := os.Getenv("HOME")
+	defer os.Setenv("HOME", home)
+	serviceHost := os.Getenv("KUBERNETES_SERVICE_HOST")
+	defer os.Setenv("KUBERNETES_SERVICE_HOST", serviceHost)
+	os.Setenv("KUBECONFIG", "/nonexistent/kubeconfig")
+	os.Setenv("HOME", "/nonexistent")
+	os.Setenv("KUBERNETES_SERVICE_HOST", "")
+
+	_, err := (&kubeconfigFlags{}).restConfig()
+	if err == nil || !strings.Contains(err.Error(), "not running in a cluster") {
+		t.Errorf("Expected the in-cluster fallback to be tried and fail, got %v", err)
+	}
+}
+
+func TestParseRequestTimeout(t *testing.T) {
+	for value, expected := range map[string]time.Duration{"0": 0, "15": 15 * time.Second, "2m": 2 * time.Minute} {
+		timeout, err := parseRequestTimeout(value)
+		if err != nil || timeout != expected {
+			t.Errorf("%s: expected %s, got %s %v", value, expected, timeout, err)
+		}
+	}
+	_, err := parseRequestTimeout("soon")
+	if err == nil {
+		t.Errorf("Expected an error for soon")
+	}
+}
+
+func TestKubeconfigDefaultNamespace(t *testing.T) {
+	file, err := ioutil.TempFile("", "kubeconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString(`apiVersion: v1
+kind: Config
+clusters:
+- name: c
+  cluster: {server: https://c.example.com}
+contexts:
+- name: team
+  context: {cluster: c, namespace: team-a}
+- name: plain
+  context: {cluster: c}
+current-context: team
+`)
+	file.Close()
+	podNamespaceEnv := os.Getenv("POD_NAMESPACE")
+	defer os.Setenv("POD_NAMESPACE", podNamespaceEnv)
+	os.Setenv("POD_NAMESPACE", "k8scapcity")
+
+	for _, test := range []struct {
+		k        kubeconfigFlags
+		expected string
+	}{
+		{kubeconfigFlags{kubeconfig: file.Name()}, "team-a"},
+		{kubeconfigFlags{kubeconfig: file.Name(), context: "plain"}, "default"},
+		{kubeconfigFlags{kubeconfig: file.Name(), namespace: "kube-system"}, "kube-system"},
+		{kubeconfigFlags{inCluster: true}, "k8scapcity"},
+	} {
+		namespace, err := test.k.defaultNamespace()
+		if err != nil {
+			t.Fatal(err)
+		}
+		compareString(namespace, test.expected, t)
+	}
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// snapshotKey is where in the snapshot ConfigMap the gzipped json goes
+const snapshotKey = "snapshot.json.gz"
+
+// maxSnapshotSize is the most data the api server takes in a ConfigMap
+const maxSnapshotSize = 1024 * 1024
+
+// serviceAccountNamespace is the namespace of an in-cluster pod
+const serviceAccountNamespace = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// leaderOptions : Lease based leader election between daemon replicas
+type leaderOptions struct {
+	enabled       bool
+	namespace     string
+	name          string
+	leaseDuration time.Duration
+	renewDeadline time.Duration
+	retryPeriod   time.Duration
+}
+
+func addLeaderFlags(flags *flag.FlagSet) *leaderOptions {
+	o := &leaderOptions{}
+	flags.BoolVar(&o.enabled, "leader-elect", false, "In daemon mode, only the replica holding a Lease collects and emits, the others serve its last snapshot")
+	flags.StringVar(&o.namespace, "leader-elect-namespace", "", "Namespace of the Lease and snapshot ConfigMap, defaults to POD_NAMESPACE or the pod's own")
+	flags.StringVar(&o.name, "leader-elect-name", "k8scapcity", "Name of the Lease, the snapshot ConfigMap is this with -snapshot")
+	flags.DurationVar(&o.leaseDuration, "leader-elect-lease-duration", 15*time.Second, "How long standbys wait after the leader last renewed before taking over")
+	flags.DurationVar(&o.renewDeadline, "leader-elect-renew-deadline", 10*time.Second, "How long the leader keeps trying to renew before it stops leading")
+	flags.DurationVar(&o.retryPeriod, "leader-elect-retry-period", 2*time.Second, "Time between attempts to acquire or renew the Lease")
+	return o
+}
+
+func (o *leaderOptions) validate() error {
+	if !o.enabled {
+		return nil
+	}
+	if o.retryPeriod <= 0 || o.renewDeadline <= o.retryPeriod || o.leaseDuration <= o.renewDeadline {
+		return fmt.Errorf("-leader-elect-lease-duration must be longer than -leader-elect-renew-deadline, which must be longer than -leader-elect-retry-period")
+	}
+	return nil
+}
+
+// leaderNamespace is -leader-elect-namespace, else podNamespace
+func (o *leaderOptions) leaderNamespace() string {
+	if o.namespace != "" {
+		return o.namespace
+	}
+	return podNamespace()
+}
+
+// podNamespace is POD_NAMESPACE, the pod's own namespace or default, the
+// first that is set
+func podNamespace() string {
+	if namespace := os.Getenv("POD_NAMESPACE"); namespace != "" {
+		return namespace
+	}
+	if data, err := ioutil.ReadFile(serviceAccountNamespace); err == nil && len(bytes.TrimSpace(data)) > 0 {
+		return string(bytes.TrimSpace(data))
+	}
+	return "default"
+}
+
+// leaderIdentity is POD_NAME, else the hostname, which is the pod name too
+func leaderIdentity() (string, error) {
+	if name := os.Getenv("POD_NAME"); name != "" {
+		return name, nil
+	}
+	return os.Hostname()
+}
+
+// leaderElection : Whether this replica leads, and the snapshot it shares with the others
+type leaderElection struct {
+	sync.Mutex
+	// isLeading is set by the callbacks rather than asked of elector, whose
+	// record stays ours when renewing fails for want of the api
+	isLeading bool
+	holder    string
+	// started is signalled when this replica starts leading, so it collects
+	// at once instead of at its next scheduled cycle
+	started   chan struct{}
+	elector   *leaderelection.LeaderElector
+	identity  string
+	namespace string
+	snapshot  string
+	clientset kubernetes.Interface
+	// leaseDuration is as long as start waits for a holder
+	leaseDuration time.Duration
+}
+
+// newLeaderElection calls stopped, when not nil, each time this replica
+// stops leading
+func newLeaderElection(o leaderOptions, clientset kubernetes.Interface, stopped func()) (*leaderElection, error) {
+	identity, err := leaderIdentity()
+	if err != nil {
+		return nil, err
+	}
+	l := &leaderElection{
+		identity:      identity,
+		namespace:     o.leaderNamespace(),
+		snapshot:      o.name + "-snapshot",
+		started:       make(chan struct{}, 1),
+		clientset:     clientset,
+		leaseDuration: o.leaseDuration,
+	}
+	l.elector, err = leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock: &resourcelock.LeaseLock{
+			LeaseMeta:  metav1.ObjectMeta{Namespace: l.namespace, Name: o.name},
+			Client:     clientset.CoordinationV1(),
+			LockConfig: resourcelock.ResourceLockConfig{Identity: identity},
+		},
+		LeaseDuration:   o.leaseDuration,
+		RenewDeadline:   o.renewDeadline,
+		RetryPeriod:     o.retryPeriod,
+		ReleaseOnCancel: true,
+		Name:            o.name,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				if ctx.Err() != nil {
+					return
+				}
+				l.setLeading(true)
+				log.Infof("%s is now leading, collecting and emitting", identity)
+				select {
+				case l.started <- struct{}{}:
+				default:
+				}
+			},
+			OnStoppedLeading: func() {
+				// Also called when Run gives up without ever leading
+				if l.setLeading(false) {
+					log.Infof("%s stopped leading, serving the leader's snapshot from the next cycle", identity)
+					if stopped != nil {
+						stopped()
+					}
+				}
+			},
+			OnNewLeader: func(leader string) {
+				l.Lock()
+				l.holder = leader
+				l.Unlock()
+				if leader != identity {
+					log.Infof("%s leads %s/%s", leader, l.namespace, o.name)
+				}
+			},
+		},
+	})
+	return l, err
+}
+
+// start campaigns for the Lease until the returned stop is called, which
+// gives the Lease up so a standby can take over without waiting for it to
+// expire. It returns once the Lease has a holder, or after a lease duration,
+// so the first cycle knows whether to lead
+func (l *leaderElection) start() (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// Run returns when leadership is lost, campaign again
+		for ctx.Err() == nil {
+			l.elector.Run(ctx)
+		}
+	}()
+	for deadline := time.Now().Add(l.leaseDuration); time.Now().Before(deadline); time.Sleep(100 * time.Millisecond) {
+		if holder := l.currentHolder(); holder != "" && (holder != l.identity || l.leading()) {
+			break
+		}
+	}
+	// The first cycle follows right away, it needs no extra one
+	select {
+	case <-l.started:
+	default:
+	}
+	return func() {
+		cancel()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+// setLeading says whether this replica leads, returning whether it did before
+func (l *leaderElection) setLeading(leading bool) bool {
+	l.Lock()
+	defer l.Unlock()
+	was := l.isLeading
+	l.isLeading = leading
+	return was
+}
+
+func (l *leaderElection) leading() bool {
+	l.Lock()
+	defer l.Unlock()
+	return l.isLeading
+}
+
+func (l *leaderElection) currentHolder() string {
+	l.Lock()
+	defer l.Unlock()
+	return l.holder
+}
+
+// daemonSnapshot : What the leader last collected, for standbys to serve
+type daemonSnapshot struct {
+	Leader      string      `json:"leader"`
+	Refreshed   time.Time   `json:"refreshed"`
+	ClusterInfo ClusterInfo `json:"cluster_info"`
+	Capacity    Capcity     `json:"capacity"`
+}
+
+// snapshotClusterInfo is clusterInfo without the nodes -nodelabel left out,
+// the api only answers for the selected ones
+func snapshotClusterInfo(clusterInfo ClusterInfo) ClusterInfo {
+	selected := make(map[string]NodeInfo)
+	for name, node := range clusterInfo.NodeInfo {
+		if node.PrintOutput {
+			selected[name] = node
+		}
+	}
+	clusterInfo.NodeInfo = selected
+	return clusterInfo
+}
+
+// writeSnapshot replaces the snapshot ConfigMap with state. A snapshot too
+// large for a ConfigMap is not written, standbys keep serving the last one
+func (l *leaderElection) writeSnapshot(state *apiState) error {
+	data, err := json.Marshal(daemonSnapshot{Leader: l.identity, Refreshed: state.refreshed, ClusterInfo: snapshotClusterInfo(state.clusterInfo), Capacity: state.capCity})
+	if err != nil {
+		return err
+	}
+	compressed := &bytes.Buffer{}
+	zw := gzip.NewWriter(compressed)
+	zw.Write(data)
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	if compressed.Len() > maxSnapshotSize {
+		return fmt.Errorf("the snapshot is %d bytes compressed, more than the %d a ConfigMap holds", compressed.Len(), maxSnapshotSize)
+	}
+	configMaps := l.clientset.CoreV1().ConfigMaps(l.namespace)
+	configMap, err := configMaps.Get(l.snapshot, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		configMap = &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: l.namespace, Name: l.snapshot,
+			Labels: map[string]string{"app.kubernetes.io/managed-by": "k8sCapcity"}}}
+		configMap.BinaryData = map[string][]byte{snapshotKey: compressed.Bytes()}
+		_, err = configMaps.Create(configMap)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	configMap.BinaryData = map[string][]byte{snapshotKey: compressed.Bytes()}
+	_, err = configMaps.Update(configMap)
+	return err
+}
+
+// readSnapshot is the leader's last state, as this replica serves it
+func (l *leaderElection) readSnapshot(eventLabels []string) (*apiState, error) {
+	configMap, err := l.clientset.CoreV1().ConfigMaps(l.namespace).Get(l.snapshot, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	compressed, ok := configMap.BinaryData[snapshotKey]
+	if !ok {
+		return nil, fmt.Errorf("%s/%s has no %s", l.namespace, l.snapshot, snapshotKey)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, err
+	}
+	var snapshot daemonSnapshot
+	if err := json.NewDecoder(zr).Decode(&snapshot); err != nil {
+		return nil, fmt.Errorf("unable to read the snapshot in %s/%s, %s", l.namespace, l.snapshot, strings.TrimSpace(err.Error()))
+	}
+	return newAPIState(snapshot.ClusterInfo, snapshot.Capacity, nil, eventLabels, snapshot.Refreshed), nil
+}
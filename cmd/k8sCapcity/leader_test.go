@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func leaderTestElection(t *testing.T) *leaderElection {
+	return leaderTestReplica(t, "k8scapcity-0", fake.NewSimpleClientset(), 15*time.Second, nil)
+}
+
+// leaderTestReplica is one replica campaigning for the Lease in clientset,
+// renewing and retrying in fractions of leaseDuration
+func leaderTestReplica(t *testing.T, identity string, clientset *fake.Clientset, leaseDuration time.Duration, stopped func()) *leaderElection {
+	os.Setenv("POD_NAME", identity)
+	defer os.Unsetenv("POD_NAME")
+	l, err := newLeaderElection(leaderOptions{namespace: "k8scapcity", name: "k8scapcity",
+		leaseDuration: leaseDuration, renewDeadline: leaseDuration * 2 / 3, retryPeriod: leaseDuration / 10}, clientset, stopped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func TestLeaderElectionFailover(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	stoppedLeading := make(chan struct{}, 1)
+	first := leaderTestReplica(t, "k8scapcity-0", clientset, time.Second, func() { stoppedLeading <- struct{}{} })
+	stopFirst := first.start()
+	if !first.leading() {
+		t.Fatal("Expected the first replica to acquire the Lease")
+	}
+	select {
+	case <-first.started:
+		t.Error("Expected the lead taken before the first cycle not to ask for another")
+	default:
+	}
+	lease, err := clientset.CoordinationV1().Leases("k8scapcity").Get("k8scapcity", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareString(*lease.Spec.HolderIdentity, "k8scapcity-0", t)
+
+	second := leaderTestReplica(t, "k8scapcity-1", clientset, time.Second, nil)
+	stopSecond := second.start()
+	defer stopSecond()
+	if second.leading() {
+		t.Fatal("Expected the second replica to stand by")
+	}
+	compareString(second.currentHolder(), "k8scapcity-0", t)
+
+	// Stopping the leader releases the Lease, the standby takes over and
+	// asks for a cycle
+	stopFirst()
+	select {
+	case <-second.started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the standby to take over")
+	}
+	if !second.leading() || first.leading() {
+		t.Errorf("Expected only the second replica to lead, got %t and %t", first.leading(), second.leading())
+	}
+	// The old leader was told, it stops its watch cache
+	select {
+	case <-stoppedLeading:
+	default:
+		t.Error("Expected the first replica's stopped callback")
+	}
+}
+
+func TestWriteSnapshotSize(t *testing.T) {
+	l := leaderTestElection(t)
+	clusterInfo, _ := metricsTestCluster()
+	node := clusterInfo.NodeInfo["node-b"]
+	node.PrintOutput = false
+	clusterInfo.NodeInfo["node-b"] = node
+	state := newAPIState(clusterInfo, calculateCapcity(clusterInfo), nil, nil, time.Now())
+	if err := l.writeSnapshot(state); err != nil {
+		t.Fatal(err)
+	}
+	read, err := l.readSnapshot(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := read.clusterInfo.NodeInfo["node-b"]; ok || len(read.clusterInfo.NodeInfo) != 1 {
+		t.Errorf("Expected only the selected node in the snapshot, got %v", read.clusterInfo.NodeInfo)
+	}
+
+	// Labels that do not compress push the snapshot over what a ConfigMap holds
+	node = clusterInfo.NodeInfo["node-a"]
+	node.Labels = make(map[string]string)
+	random := rand.New(rand.NewSource(1))
+	for i := 0; i < 80000; i++ {
+		node.Labels[strconv.FormatUint(random.Uint64(), 36)] = strconv.FormatUint(random.Uint64(), 36)
+	}
+	clusterInfo.NodeInfo["node-a"] = node
+	err = l.writeSnapshot(newAPIState(clusterInfo, calculateCapcity(clusterInfo), nil, nil, time.Now()))
+	if err == nil || !strings.Contains(err.Error(), "more than the 1048576 a ConfigMap holds") {
+		t.Errorf("Expected an oversized snapshot to be refused, got %v", err)
+	}
+	if read, _ := l.readSnapshot(nil); read == nil || len(read.clusterInfo.NodeInfo["node-a"].Labels) > 2 {
+		t.Error("Expected the previous snapshot to stay")
+	}
+}
+
+func TestLeaderSnapshot(t *testing.T) {
+	l := leaderTestElection(t)
+	compareString(l.identity, "k8scapcity-0", t)
+	if _, err := l.readSnapshot(nil); err == nil {
+		t.Error("Expected no snapshot before the leader wrote one")
+	}
+	clusterInfo, _ := metricsTestCluster()
+	refreshed := time.Date(2020, 3, 6, 0, 0, 0, 0, time.UTC)
+	for _, pods := range []int64{40, 41} {
+		node := clusterInfo.NodeInfo["node-b"]
+		node.UsedPods = pods
+		clusterInfo.NodeInfo["node-b"] = node
+		if err := l.writeSnapshot(newAPIState(clusterInfo, calculateCapcity(clusterInfo), nil, nil, refreshed)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	state, err := l.readSnapshot(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareString(state.refreshed.Format(time.RFC3339), "2020-03-06T00:00:00Z", t)
+	compareString(strconv.FormatInt(state.clusterInfo.NodeInfo["node-b"].UsedPods, 10), "41", t)
+	compareString(state.capCity.Timestamp, "2020-03-06T00:00:00Z", t)
+	compareString(strconv.FormatInt(state.capCity.AllocatableCPUTotal, 10), "16", t)
+	compareString(strconv.Itoa(len(state.nodes)), "2", t)
+}
+
+func TestDaemonCycleLeader(t *testing.T) {
+	l := leaderTestElection(t)
+	clusterInfo, _ := metricsTestCluster()
+	nodeLabel := ""
+	collected := 0
+	d := &daemon{
+		nodeLabel: &nodeLabel,
+		health:    &healthState{},
+		api:       &apiServer{},
+		leader:    l,
+		collect: func(ctx context.Context) ClusterInfo {
+			collected++
+			return clusterInfo
+		},
+	}
+
+	// A standby without a snapshot to serve fails its cycle
+	func() {
+		defer func() { recover() }()
+		d.cycle(context.Background())
+	}()
+	compareString(strconv.Itoa(collected), "0", t)
+	if d.api.current() != nil {
+		t.Error("Expected a standby to serve nothing before the leader wrote a snapshot")
+	}
+
+	l.setLeading(true)
+	d.cycle(context.Background())
+	compareString(strconv.Itoa(collected), "1", t)
+	leaderState := d.api.current()
+	if leaderState == nil {
+		t.Fatal("Expected the leader to serve what it collected")
+	}
+
+	// The standby serves the leader's snapshot, and is ready
+	l.setLeading(false)
+	d.api = &apiServer{}
+	d.cycle(context.Background())
+	compareString(strconv.Itoa(collected), "1", t)
+	state := d.api.current()
+	if state == nil {
+		t.Fatal("Expected a standby to serve the leader's snapshot")
+	}
+	compareString(state.refreshed.UTC().Format(time.RFC3339Nano), leaderState.refreshed.UTC().Format(time.RFC3339Nano), t)
+	if d.health.ready(time.Now()) != nil {
+		t.Error("Expected a standby that read the snapshot to be ready")
+	}
+}
+
+func TestLeaderOptionsValidate(t *testing.T) {
+	o := leaderOptions{enabled: true, leaseDuration: 15 * time.Second, renewDeadline: 10 * time.Second, retryPeriod: 2 * time.Second}
+	if err := o.validate(); err != nil {
+		t.Error(err)
+	}
+	o.renewDeadline = 20 * time.Second
+	if o.validate() == nil {
+		t.Error("Expected a renew deadline longer than the lease duration to be rejected")
+	}
+}
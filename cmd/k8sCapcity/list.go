@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// nonTerminatedPodSelector leaves Failed and Succeeded pods to the api server
+const nonTerminatedPodSelector = "status.phase!=Failed,status.phase!=Succeeded"
+
+// listPageSize is how many objects one List call asks the api server for
+const listPageSize = 500
+
+// maxNodeScopedPodLists is how many selected nodes we list pods for one
+// node at a time, past that a single cluster wide list is cheaper
+const maxNodeScopedPodLists = 100
+
+// pageOptions is options for the next page, or ctx's error once it is done.
+// The typed List calls of this client-go take no context, so what is left of
+// its deadline goes to the api server as the timeout of the call
+func pageOptions(ctx context.Context, options metav1.ListOptions) (metav1.ListOptions, error) {
+	if err := ctx.Err(); err != nil {
+		return options, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		seconds := int64(math.Ceil(time.Until(deadline).Seconds()))
+		if seconds < 1 {
+			seconds = 1
+		}
+		options.TimeoutSeconds = &seconds
+	}
+	return options, nil
+}
+
+// listNodes hands every node matching labelSelector to each, a page at a time
+func listNodes(ctx context.Context, clientset kubernetes.Interface, labelSelector string, each func(node corev1.Node)) error {
+	options := metav1.ListOptions{LabelSelector: labelSelector, Limit: listPageSize}
+	for {
+		page, err := pageOptions(ctx, options)
+		if err != nil {
+			return err
+		}
+		nodes, err := clientset.CoreV1().Nodes().List(page)
+		if err != nil {
+			return err
+		}
+		for _, node := range nodes.Items {
+			each(node)
+		}
+		if nodes.Continue == "" {
+			return nil
+		}
+		options.Continue = nodes.Continue
+	}
+}
+
+func listResourceQuotas(ctx context.Context, clientset kubernetes.Interface, each func(quota corev1.ResourceQuota)) error {
+	options := metav1.ListOptions{Limit: listPageSize}
+	for {
+		page, err := pageOptions(ctx, options)
+		if err != nil {
+			return err
+		}
+		quotas, err := clientset.CoreV1().ResourceQuotas("").List(page)
+		if err != nil {
+			return err
+		}
+		for _, quota := range quotas.Items {
+			each(quota)
+		}
+		if quotas.Continue == "" {
+			return nil
+		}
+		options.Continue = quotas.Continue
+	}
+}
+
+// listPods hands every non-terminated pod in namespace ("" for all) to each,
+// a page at a time. A non blank nodeName limits the list to that node
+func listPods(ctx context.Context, clientset kubernetes.Interface, namespace, nodeName string, each func(pod *corev1.Pod)) error {
+	fieldSelector := nonTerminatedPodSelector
+	if nodeName != "" {
+		fieldSelector = fmt.Sprintf("spec.nodeName=%s,%s", nodeName, fieldSelector)
+	}
+	options := metav1.ListOptions{FieldSelector: fieldSelector, Limit: listPageSize}
+	for {
+		page, err := pageOptions(ctx, options)
+		if err != nil {
+			return err
+		}
+		pods, err := clientset.CoreV1().Pods(namespace).List(page)
+		if err != nil {
+			return err
+		}
+		for i := range pods.Items {
+			each(&pods.Items[i])
+		}
+		if pods.Continue == "" {
+			return nil
+		}
+		options.Continue = pods.Continue
+	}
+}
+
+// listSelectedPods lists pods on the given nodes only, node by node and
+// concurrently, unless there are too many nodes for that to beat one cluster
+// wide list. each is never called from two lists at once
+func listSelectedPods(ctx context.Context, clientset kubernetes.Interface, nodeNames []string, each func(pod *corev1.Pod)) error {
+	if nodeNames == nil || len(nodeNames) > maxNodeScopedPodLists {
+		return listPods(ctx, clientset, "", "", each)
+	}
+	var eachLock sync.Mutex
+	calls := []apiCall{}
+	for _, nodeName := range nodeNames {
+		nodeName := nodeName
+		calls = append(calls, apiCall{"pods/" + nodeName, func(ctx context.Context) error {
+			return listPods(ctx, clientset, "", nodeName, func(pod *corev1.Pod) {
+				eachLock.Lock()
+				defer eachLock.Unlock()
+				each(pod)
+			})
+		}})
+	}
+	return runConcurrently(ctx, calls...)
+}
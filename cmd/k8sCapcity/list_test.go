@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// pagedPods serves pods two at a time and records the options of every call
+func pagedPods(clientset *fake.Clientset, pods []corev1.Pod, calls *[]metav1.ListOptions) {
+	clientset.PrependReactor("list", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		restrictions := action.(k8stesting.ListAction).GetListRestrictions()
+		options := metav1.ListOptions{
+			LabelSelector: restrictions.Labels.String(),
+			FieldSelector: restrictions.Fields.String(),
+		}
+		*calls = append(*calls, options)
+		start := 2 * (countCalls(*calls, options.FieldSelector) - 1)
+		list := &corev1.PodList{}
+		end := start + 2
+		if end >= len(pods) {
+			end = len(pods)
+		} else {
+			list.Continue = "more"
+		}
+		list.Items = pods[start:end]
+		return true, list, nil
+	})
+}
+
+func countCalls(calls []metav1.ListOptions, fieldSelector string) (count int) {
+	for _, call := range calls {
+		if call.FieldSelector == fieldSelector {
+			count++
+		}
+	}
+	return count
+}
+
+func TestListPodsPaginates(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	var pods []corev1.Pod
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		pods = append(pods, *testPod("default", name, "node-a", "100m", "1Gi"))
+	}
+	var calls []metav1.ListOptions
+	pagedPods(clientset, pods, &calls)
+
+	var names []string
+	err := listPods(context.Background(), clientset, "default", "node-a", func(pod *corev1.Pod) {
+		names = append(names, pod.Name)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 5 || len(calls) != 3 {
+		t.Errorf("Expected 5 pods over 3 pages, got %v over %d", names, len(calls))
+	}
+	for _, call := range calls {
+		compareString(call.FieldSelector, "spec.nodeName=node-a,status.phase!=Failed,status.phase!=Succeeded", t)
+	}
+}
+
+func TestListPodsStopsWithContext(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	var pods []corev1.Pod
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		pods = append(pods, *testPod("default", name, "node-a", "100m", "1Gi"))
+	}
+	var calls []metav1.ListOptions
+	pagedPods(clientset, pods, &calls)
+
+	// The deadline passes during the first page, no further page is asked for
+	ctx, cancel := context.WithCancel(context.Background())
+	err := listPods(ctx, clientset, "default", "", func(pod *corev1.Pod) { cancel() })
+	if err != context.Canceled || len(calls) != 1 {
+		t.Errorf("Expected to stop after one page with %s, got %v after %d", context.Canceled, err, len(calls))
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 90*time.Second)
+	defer cancel()
+	page, err := pageOptions(ctx, metav1.ListOptions{})
+	if err != nil || page.TimeoutSeconds == nil {
+		t.Fatalf("Expected a server side timeout, got %v", err)
+	}
+	compareString(strconv.FormatInt(*page.TimeoutSeconds, 10), "90", t)
+}
+
+func TestListSelectedPods(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	var calls []metav1.ListOptions
+	pagedPods(clientset, nil, &calls)
+
+	err := listSelectedPods(context.Background(), clientset, []string{"node-a", "node-b"}, func(pod *corev1.Pod) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(calls) != 2 || countCalls(calls, "spec.nodeName=node-b,status.phase!=Failed,status.phase!=Succeeded") != 1 {
+		t.Fatalf("Expected one list per node, got %+v", calls)
+	}
+
+	calls = nil
+	err = listSelectedPods(context.Background(), clientset, nil, func(pod *corev1.Pod) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(calls) != 1 {
+		t.Fatalf("Expected one cluster wide list, got %+v", calls)
+	}
+	compareString(calls[0].FieldSelector, nonTerminatedPodSelector, t)
+}
+
+func TestListNodesLabelSelector(t *testing.T) {
+	clientset := fake.NewSimpleClientset(
+		testNode("node-a", map[string]string{"pool": "a"}, "4", "16Gi"),
+		testNode("node-b", map[string]string{"pool": "b"}, "4", "16Gi"),
+	)
+	var names []string
+	err := listNodes(context.Background(), clientset, "pool=a", func(node corev1.Node) {
+		names = append(names, node.Name)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 1 || names[0] != "node-a" {
+		t.Errorf("Expected only node-a, got %v", names)
+	}
+}
+
+func TestNodeSelected(t *testing.T) {
+	selector, err := parseNodeSelector("pool in (a,b),!spot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareString(selectorKeys(selector), "pool,spot", t)
+	for _, node := range []struct {
+		labels   map[string]string
+		selected bool
+	}{
+		{map[string]string{"pool": "a"}, true},
+		{map[string]string{"pool": "b", "spot": "true"}, false},
+		{map[string]string{"pool": "c"}, false},
+	} {
+		if nodeSelected(*testNode("node", node.labels, "4", "16Gi"), selector) != node.selected {
+			t.Errorf("Expected %v selected to be %t", node.labels, node.selected)
+		}
+	}
+	flag := nodeSelectorFlag("")
+	if flag.Set("pool=a=b") == nil {
+		t.Error("Expected -nodelabel to refuse a selector that does not parse")
+	}
+}
@@ -3,16 +3,10 @@ package main
 import (
 	"flag"
 	"os"
-	"path/filepath"
 
-	"encoding/json"
 	"fmt"
 	log "github.com/sirupsen/logrus"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/rest"
-	"k8s.io/client-go/tools/clientcmd"
-	"time"
+	"strings"
 	// Support gcp and other authentication schemes
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 )
@@ -23,58 +17,74 @@ func check(err error) {
 	}
 }
 
-func homeDir() string {
-	if h := os.Getenv("HOME"); h != "" {
-		return h
+func main() {
+	// Installed as kubectl-capacity, behave like a kubectl command
+	if isPlugin(os.Args[0]) {
+		err := runPlugin(os.Args[1:], os.Stdout)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s\n", err)
+			os.Exit(1)
+		}
+		return
 	}
-	return os.Getenv("USERPROFILE") // windows
-}
 
-func main() {
 	// Log as JSON instead of the default ASCII formatter.
 	log.SetFormatter(&log.JSONFormatter{})
 
 	// Output to stdout instead of the default stderr
 	log.SetOutput(os.Stdout)
-	var kubeconfig *string
-	if home := homeDir(); home != "" {
-		kubeconfig = flag.String("kubeconfig", filepath.Join(home, ".kube", "config"), "(optional) absolute path to the kubeconfig file")
-	} else {
-		kubeconfig = flag.String("kubeconfig", "", "absolute path to the kubeconfig file")
+
+	// k8sCapcity <command> ..., anything starting with a flag is the original flag mode
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		os.Exit(runCommand(os.Args[1:]))
 	}
-	nodeLabel := flag.String("nodelabel", "", "Label to match for nodes, if blank grab all nodes")
-	nameSpace := flag.String("namespace", "", "Namespace to grab capacity usage from")
-	daemonMode := flag.Bool("daemon", false, "Run in daemon mode")
-	jsonMode := flag.Bool("json", false, "Output information in json format")
-	checkMode := flag.Bool("check", false, "Check kubernetes connection")
-	flag.Parse()
-
-	// use the current context in kubeconfig
-	config, err := clientcmd.BuildConfigFromFlags("", *kubeconfig)
-	if err != nil {
-		// no config, maybe we are inside a kubernetes cluster.
-		config, err = rest.InClusterConfig()
-		check(err)
+
+	legacy, err := parseLegacyFlags(flag.CommandLine, os.Args[1:])
+	check(err)
+	cluster := legacy.settings.cluster
+	historyFile := legacy.settings.historyFile
+
+	check(checkOutput(legacy.output))
+	cluster.setUp()
+	warnIgnoredFlags(legacy.checkMode, legacy.nameSpace, legacy.daemonMode)
+
+	// Answer history queries from the file, no cluster needed
+	if legacy.historyQuery != "" {
+		if historyFile == "" {
+			log.Fatal("-history-query requires -history")
+		}
+		legacy.query.run(historyFile, legacy.historyQuery)
+		return
 	}
 
-	// create the clientset
-	clientset, err := kubernetes.NewForConfig(config)
+	// Many clusters, each with its own result plus a fleet roll-up
+	if legacy.multi.enabled() {
+		if legacy.daemonMode || legacy.nameSpace != "" || legacy.checkMode {
+			log.Fatal("-contexts and -kubeconfig-dir only work with the cluster wide report")
+		}
+		legacy.multi.run(cluster, legacy.jsonMode)
+		return
+	}
+
+	// Same kubeconfig loading rules as kubectl
+	clientset, err := cluster.clientset()
 	check(err)
 
-	if *checkMode {
-		_, err := clientset.CoreV1().Nodes().List(metav1.ListOptions{})
-		check(err)
+	if legacy.checkMode {
+		check(checkConnection(clientset))
 		fmt.Println("ok")
 		return
 	}
 
 	// BreakOut to namespace if asked
-	if *nameSpace != "" {
-		nsInfo := gatherNamespaceInfo(clientset, nameSpace)
-		if *jsonMode {
-			result, err := json.Marshal(nsInfo)
-			check(err)
-			fmt.Println(string(result))
+	if legacy.nameSpace != "" {
+		nsInfo := cluster.gatherNamespaceInfo(clientset, legacy.nameSpace)
+		if legacy.jsonMode {
+			printJSON(nsInfo)
+			return
+		}
+		if legacy.output != "" {
+			check(render(os.Stdout, legacy.output, namespaceView(nsInfo)))
 			return
 		}
 		output := namespaceHumanMode(nsInfo)
@@ -85,18 +95,80 @@ func main() {
 	}
 
 	// Gather info
-	if *daemonMode {
-		for {
-			clusterInfo := gatherInfo(clientset, nodeLabel)
-			getCapcity(clusterInfo)
-			time.Sleep(300 * time.Second)
-		}
-	} else if *jsonMode {
-		clusterInfo := gatherInfo(clientset, nodeLabel)
-		getCapcity(clusterInfo)
-
+	if legacy.daemonMode {
+		// SIGHUP parses the same flags again, with the config file and environment read afresh
+		check(legacy.settings.run(clientset, func() (*daemonSettings, error) {
+			reloaded, err := parseLegacyFlags(flag.NewFlagSet(binaryName, flag.ContinueOnError), os.Args[1:])
+			if err != nil {
+				return nil, err
+			}
+			return reloaded.settings, nil
+		}))
+	} else if legacy.output != "" {
+		check(renderCluster(os.Stdout, legacy.output, cluster.gatherInfo(clientset), historyFile, legacy.settings.forecast.forecasts(historyFile)))
 	} else {
-		clusterInfo := gatherInfo(clientset, nodeLabel)
-		humanMode(clusterInfo)
+		printCluster(cluster.gatherInfo(clientset), legacy.jsonMode, historyFile, legacy.settings.forecast.forecasts(historyFile))
+	}
+}
+
+// legacyOptions : Every flag of the original flag mode, k8sCapcity -json ...
+type legacyOptions struct {
+	settings     *daemonSettings
+	multi        *multiClusterOptions
+	query        *historyQueryOptions
+	config       *string
+	nameSpace    string
+	daemonMode   bool
+	jsonMode     bool
+	checkMode    bool
+	historyQuery string
+	output       string
+}
+
+func addLegacyFlags(flags *flag.FlagSet) *legacyOptions {
+	o := &legacyOptions{
+		settings: addDaemonSettingsFlags(flags, "File to record every daemon cycle in, and to read -history-query from"),
+		multi:    addMultiClusterFlags(flags),
+		query:    addHistoryQueryFlags(flags),
+		config:   addConfigFlag(flags),
+	}
+	flags.StringVar(&o.nameSpace, "namespace", "", "Namespace to grab capacity usage from")
+	flags.BoolVar(&o.daemonMode, "daemon", false, "Run in daemon mode")
+	flags.BoolVar(&o.jsonMode, "json", false, "Output information in json format")
+	flags.BoolVar(&o.checkMode, "check", false, "Check kubernetes connection")
+	flags.StringVar(&o.output, "o", "", "Output format: "+strings.Join(outputFormats(), ", "))
+	flags.StringVar(&o.historyQuery, "history-query", "", "Print the trend of a field from -history, e.g. utilization_factor.memory_request.nminusone")
+	return o
+}
+
+// parseLegacyFlags parses args, then fills what they leave out from the
+// K8SCAPCITY_* environment and -config
+func parseLegacyFlags(flags *flag.FlagSet, args []string) (*legacyOptions, error) {
+	o := addLegacyFlags(flags)
+	err := flags.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+	return o, loadConfig(flags, *o.config)
+}
+
+// warnIgnoredFlags points out flag mode combinations where one flag wins
+// silently, the subcommands reject these outright
+func warnIgnoredFlags(checkMode bool, nameSpace string, daemonMode bool) {
+	set := flagsSet(flag.CommandLine)
+	others := 0
+	for name := range set {
+		if !strings.HasPrefix(name, "test.") && name != "check" {
+			others++
+		}
+	}
+	if checkMode && others > 0 {
+		log.Warn("-check ignores every other flag, see k8sCapcity check -h")
+	}
+	if nameSpace != "" && (daemonMode || set["nodelabel"]) {
+		log.Warn("-namespace ignores -daemon and -nodelabel, see k8sCapcity namespace -h and k8sCapcity daemon -h")
+	}
+	if daemonMode && set["json"] {
+		log.Warn("-daemon always prints json, -json is not needed")
 	}
 }
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// metricSink : Somewhere the daemon writes the gauges of every cycle
+type metricSink interface {
+	write(groups []metricGroup, now time.Time) error
+	String() string
+}
+
+// metricAttribute : A dimension of a metricGroup, a tag or resource attribute
+type metricAttribute struct {
+	key, value string
+}
+
+// metricGauge : One value of a metricGroup, named after its json path
+type metricGauge struct {
+	name  string
+	value float64
+}
+
+// metricGroup : Gauges that share their dimensions, those of the cluster,
+// of one node or of one namespace
+type metricGroup struct {
+	kind       string
+	name       string
+	prefix     string
+	attributes []metricAttribute
+	gauges     []metricGauge
+}
+
+// capacityMetrics is what the metric sinks publish. The cluster gauges are
+// the -event-version 2 fields, the node gauges those of the node events and
+// the namespace gauges the requests and limits of every namespace
+func capacityMetrics(clusterInfo ClusterInfo, capCity Capcity) []metricGroup {
+	var cluster []metricAttribute
+	if capCity.OrchestratorClusterName != "" {
+		cluster = []metricAttribute{{"k8s.cluster.name", capCity.OrchestratorClusterName}}
+	}
+	groups := []metricGroup{{
+		kind:       "cluster",
+		prefix:     "k8s_quota.",
+		attributes: cluster,
+		gauges: metricGauges(capacityEvent(capCity).K8sQuota, "", func(path string) bool {
+			return strings.HasSuffix(path, ".nodes")
+		}),
+	}}
+	for _, node := range nodeEvents(clusterInfo, nil) {
+		attributes := append([]metricAttribute{{"k8s.node.name", node.Name}}, cluster...)
+		for _, attribute := range []metricAttribute{{"cloud.availability_zone", node.CloudAvailabilityZone},
+			{"cloud.region", node.CloudRegion}, {"host.type", node.CloudMachineType}, {"k8s_quota.node_pool", node.NodePool}} {
+			if attribute.value != "" {
+				attributes = append(attributes, attribute)
+			}
+		}
+		groups = append(groups, metricGroup{
+			kind:       "node",
+			name:       node.Name,
+			prefix:     "k8s_quota.node.",
+			attributes: attributes,
+			gauges: metricGauges(node, "k8s_quota.node.", func(path string) bool {
+				return !strings.HasPrefix(path, "k8s_quota.node.")
+			}),
+		})
+	}
+	var namespaces []string
+	for name := range clusterInfo.NamespaceTotals {
+		namespaces = append(namespaces, name)
+	}
+	sort.Strings(namespaces)
+	for _, name := range namespaces {
+		groups = append(groups, metricGroup{
+			kind:       "namespace",
+			name:       name,
+			prefix:     "k8s_quota.namespace.",
+			attributes: append([]metricAttribute{{"k8s.namespace.name", name}}, cluster...),
+			gauges:     metricGauges(clusterInfo.NamespaceTotals[name], "", nil),
+		})
+	}
+	return groups
+}
+
+// metricGauges turns the numbers and booleans of v's json form into gauges
+// named after their path less trim, leaving out what skip says to
+func metricGauges(v interface{}, trim string, skip func(path string) bool) (gauges []metricGauge) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	var object interface{}
+	json.Unmarshal(data, &object)
+	var walk func(path string, value interface{})
+	walk = func(path string, value interface{}) {
+		if path != "" && skip != nil && skip(path) {
+			return
+		}
+		switch value := value.(type) {
+		case float64:
+			gauges = append(gauges, metricGauge{strings.TrimPrefix(path, trim), value})
+		case bool:
+			gauge := metricGauge{strings.TrimPrefix(path, trim), 0}
+			if value {
+				gauge.value = 1
+			}
+			gauges = append(gauges, gauge)
+		case map[string]interface{}:
+			for key, child := range value {
+				if path != "" {
+					key = path + "." + key
+				}
+				walk(key, child)
+			}
+		}
+	}
+	walk("", object)
+	sort.Slice(gauges, func(i, j int) bool { return gauges[i].name < gauges[j].name })
+	return gauges
+}
+
+var unsafePathSegment = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+
+// metricPath is the dotted name StatsD and Graphite know a gauge by, e.g.
+// k8scapcity.prod.node.ip-10-0-0-1_ec2_internal.allocatable.cpu.millicores.
+// The cluster, node and namespace names are path segments, with whatever
+// would split them turned into _
+func metricPath(prefix string, group metricGroup, gauge metricGauge, dimensions bool) string {
+	var segments []string
+	if prefix != "" {
+		segments = append(segments, prefix)
+	}
+	for _, attribute := range group.attributes {
+		if dimensions && attribute.key == "k8s.cluster.name" {
+			segments = append(segments, unsafePathSegment.ReplaceAllString(attribute.value, "_"))
+		}
+	}
+	segments = append(segments, group.kind)
+	if dimensions && group.name != "" {
+		segments = append(segments, unsafePathSegment.ReplaceAllString(group.name, "_"))
+	}
+	return strings.Join(append(segments, gauge.name), ".")
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func metricsTestGroups() []metricGroup {
+	cluster := metricAttribute{"k8s.cluster.name", "prod"}
+	return []metricGroup{
+		{kind: "cluster", prefix: "k8s_quota.", attributes: []metricAttribute{cluster},
+			gauges: []metricGauge{{"allocatable.pods.total", 220}, {"available.cpu_cores.nminusone", -1.5}}},
+		{kind: "node", name: "ip-10-0-0-1.ec2.internal", prefix: "k8s_quota.node.",
+			attributes: []metricAttribute{{"k8s.node.name", "ip-10-0-0-1.ec2.internal"}, cluster, {"k8s_quota.node_pool", "spot pool"}},
+			gauges:     []metricGauge{{"ready", 1}}},
+		{kind: "namespace", name: "default", prefix: "k8s_quota.namespace.",
+			attributes: []metricAttribute{{"k8s.namespace.name", "default"}, cluster},
+			gauges:     []metricGauge{{"pods", 3}}},
+	}
+}
+
+func TestCapacityMetrics(t *testing.T) {
+	groups := capacityMetrics(metricsTestCluster())
+	if len(groups) != 4 {
+		t.Fatalf("Expected a cluster, two node and one namespace group, got %d", len(groups))
+	}
+	compareString(groups[1].kind+" "+groups[1].prefix, "node k8s_quota.node.", t)
+	for _, gauge := range groups[1].gauges {
+		if strings.HasPrefix(gauge.name, "k8s_quota") {
+			t.Errorf("Expected gauge names without the group prefix, got %s", gauge.name)
+		}
+	}
+	compareString(groups[3].name, "default", t)
+}
+
+func TestInfluxLines(t *testing.T) {
+	lines := strings.Split(string(influxLines(metricsTestGroups(), time.Unix(1583452800, 0))), "\n")
+	compareString(lines[0], "k8s_quota,k8s.cluster.name=prod allocatable.pods.total=220,available.cpu_cores.nminusone=-1.5 1583452800000000000", t)
+	compareString(lines[1], `k8s_quota.node,k8s.cluster.name=prod,k8s.node.name=ip-10-0-0-1.ec2.internal,k8s_quota.node_pool=spot\ pool ready=1 1583452800000000000`, t)
+	compareString(lines[2], "k8s_quota.namespace,k8s.cluster.name=prod,k8s.namespace.name=default pods=3 1583452800000000000", t)
+}
+
+func TestInfluxSink(t *testing.T) {
+	var query, auth string
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query, auth = r.URL.RawQuery, r.Header.Get("Authorization")
+		body, _ = ioutil.ReadAll(r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+	s := newInfluxSink(influxOptions{output: server.URL + "/api/v2/write?bucket=capacity&org=ops", token: "secret", timeout: time.Second})
+	if err := s.write(metricsTestGroups(), time.Unix(1583452800, 0)); err != nil {
+		t.Fatal(err)
+	}
+	compareString(query, "bucket=capacity&org=ops&precision=ns", t)
+	compareString(auth, "Token secret", t)
+	compareString(strconv.Itoa(strings.Count(string(body), "\n")), "3", t)
+
+	stdout := &bytes.Buffer{}
+	s = newInfluxSink(influxOptions{output: "-"})
+	s.stdout = stdout
+	if err := s.write(metricsTestGroups()[2:], time.Unix(1583452800, 0)); err != nil {
+		t.Fatal(err)
+	}
+	compareString(stdout.String(), "k8s_quota.namespace,k8s.cluster.name=prod,k8s.namespace.name=default pods=3 1583452800000000000\n", t)
+}
+
+func TestStatsdLines(t *testing.T) {
+	lines := statsdLines(statsdOptions{prefix: "k8scapcity"}, metricsTestGroups())
+	compareString(strings.Join(lines, "\n"), strings.Join([]string{
+		"k8scapcity.prod.cluster.allocatable.pods.total:220|g",
+		"k8scapcity.prod.cluster.available.cpu_cores.nminusone:0|g",
+		"k8scapcity.prod.cluster.available.cpu_cores.nminusone:-1.5|g",
+		"k8scapcity.prod.node.ip-10-0-0-1_ec2_internal.ready:1|g",
+		"k8scapcity.prod.namespace.default.pods:3|g",
+	}, "\n"), t)
+	lines = statsdLines(statsdOptions{prefix: "k8scapcity", tags: true}, metricsTestGroups()[2:])
+	compareString(lines[0], "k8scapcity.namespace.pods:3|g|#k8s.namespace.name:default,k8s.cluster.name:prod", t)
+}
+
+func TestStatsdSink(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	s := &statsdSink{statsdOptions{address: conn.LocalAddr().String(), prefix: "k8scapcity"}}
+	if err := s.write(metricsTestGroups(), time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	packet := make([]byte, statsdPacketSize)
+	n, _, err := conn.ReadFrom(packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareString(strconv.Itoa(strings.Count(string(packet[:n]), "\n")), "4", t)
+
+	long := make([]string, 100)
+	for i := range long {
+		long[i] = strings.Repeat("x", 40) + ":1|g"
+	}
+	for _, packet := range statsdPackets(long) {
+		if len(packet) > statsdPacketSize {
+			t.Errorf("Expected packets of at most %d bytes, got %d", statsdPacketSize, len(packet))
+		}
+	}
+}
+
+func TestGraphiteSink(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	received := make(chan []string)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			close(received)
+			return
+		}
+		defer conn.Close()
+		var lines []string
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+		received <- lines
+	}()
+	s := &graphiteSink{graphiteOptions{address: listener.Addr().String(), prefix: "k8scapcity", timeout: time.Second}}
+	if err := s.write(metricsTestGroups(), time.Unix(1583452800, 0)); err != nil {
+		t.Fatal(err)
+	}
+	lines := <-received
+	compareString(strconv.Itoa(len(lines)), "4", t)
+	compareString(lines[2], "k8scapcity.prod.node.ip-10-0-0-1_ec2_internal.ready 1 1583452800", t)
+	compareString(lines[3], "k8scapcity.prod.namespace.default.pods 3 1583452800", t)
+}
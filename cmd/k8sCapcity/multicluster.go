@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// fleetClusterName tags the roll-up of every cluster in multi-cluster mode
+const fleetClusterName = "fleet"
+
+// clusterTarget : One kubeconfig context to collect from
+type clusterTarget struct {
+	name    string
+	context string
+	file    string
+	config  *rest.Config
+}
+
+// clusterResult : What collecting from one cluster produced
+type clusterResult struct {
+	name        string
+	clusterInfo ClusterInfo
+	err         error
+}
+
+// matchContexts picks the context names matching a comma separated list of
+// names and globs, "all" matches every context. A pattern matching nothing is an error
+func matchContexts(names []string, patterns string) ([]string, error) {
+	matched := make(map[string]bool)
+	for _, pattern := range strings.Split(patterns, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		found := false
+		for _, name := range names {
+			ok, err := path.Match(pattern, name)
+			if err != nil {
+				return nil, fmt.Errorf("bad context pattern %q: %s", pattern, err)
+			}
+			if ok || pattern == "all" {
+				matched[name] = true
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("no kubeconfig context matches %q", pattern)
+		}
+	}
+	result := make([]string, 0, len(matched))
+	for name := range matched {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// kubeconfigFiles lists the kubeconfigs in dir, hidden files and
+// subdirectories are skipped
+func kubeconfigFiles(dir string) ([]string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		files = append(files, filepath.Join(dir, entry.Name()))
+	}
+	return files, nil
+}
+
+// loadClusterTargets resolves -contexts against the merged kubeconfig, or
+// against every kubeconfig in -kubeconfig-dir (where blank -contexts means all
+// of them). A context name found in more than one file is prefixed with its
+// file name. The -cluster, -user, -as and timeout overrides apply to every target
+func loadClusterTargets(k *kubeconfigFlags, kubeconfigDir, contexts string) ([]clusterTarget, error) {
+	configs := make(map[string]*clientcmdapi.Config)
+	if kubeconfigDir != "" {
+		files, err := kubeconfigFiles(kubeconfigDir)
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			config, err := clientcmd.LoadFromFile(file)
+			if err != nil {
+				return nil, fmt.Errorf("unable to load kubeconfig %s: %s", file, err)
+			}
+			err = clientcmd.ResolveLocalPaths(config)
+			if err != nil {
+				return nil, err
+			}
+			configs[file] = config
+		}
+		if contexts == "" {
+			contexts = "all"
+		}
+	} else {
+		config, err := k.loadingRules().Load()
+		if err != nil {
+			return nil, err
+		}
+		configs[""] = config
+	}
+
+	var candidates []clusterTarget
+	seen := make(map[string]int)
+	for file, config := range configs {
+		for name := range config.Contexts {
+			candidates = append(candidates, clusterTarget{name: name, context: name, file: file})
+			seen[name]++
+		}
+	}
+	var names []string
+	byName := make(map[string]clusterTarget)
+	for _, candidate := range candidates {
+		if seen[candidate.context] > 1 {
+			candidate.name = fmt.Sprintf("%s/%s", filepath.Base(candidate.file), candidate.context)
+		}
+		names = append(names, candidate.name)
+		byName[candidate.name] = candidate
+	}
+
+	matched, err := matchContexts(names, contexts)
+	if err != nil {
+		return nil, err
+	}
+	overrides := k.overrides()
+	overrides.CurrentContext = ""
+	targets := make([]clusterTarget, 0, len(matched))
+	for _, name := range matched {
+		target := byName[name]
+		clientConfig := clientcmd.NewNonInteractiveClientConfig(*configs[target.file], target.context, overrides, nil)
+		target.config, err = clientConfig.ClientConfig()
+		if err != nil {
+			return nil, fmt.Errorf("context %s: %s", target.name, err)
+		}
+		targets = append(targets, target)
+	}
+	return targets, nil
+}
+
+// collectClusters collects every target concurrently, at most
+// -parallelism at a time. A failing or unfinished cluster is logged and
+// reported in its result without stopping the others
+func collectClusters(ctx context.Context, targets []clusterTarget, collect func(ctx context.Context, clientset *kubernetes.Clientset) ClusterInfo) []clusterResult {
+	type indexed struct {
+		index  int
+		result clusterResult
+	}
+	done := make(chan indexed, len(targets))
+	slots := collectSlots()
+	for i, target := range targets {
+		go func(i int, target clusterTarget) {
+			result := clusterResult{name: target.name}
+			select {
+			case slots <- struct{}{}:
+				defer func() { <-slots }()
+				result.err = runCall(ctx, apiCall{"cluster/" + target.name, func(ctx context.Context) error {
+					clientset, err := kubernetes.NewForConfig(target.config)
+					if err != nil {
+						return err
+					}
+					result.clusterInfo = collect(ctx, clientset)
+					return nil
+				}})
+			case <-ctx.Done():
+				result.err = ctx.Err()
+			}
+			done <- indexed{i, result}
+		}(i, target)
+	}
+
+	results := make([]clusterResult, len(targets))
+	finished := make([]bool, len(targets))
+	for range targets {
+		select {
+		case d := <-done:
+			results[d.index] = d.result
+			finished[d.index] = true
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	for i, target := range targets {
+		if !finished[i] {
+			results[i] = clusterResult{name: target.name, err: fmt.Errorf("collection did not finish: %s", ctx.Err())}
+		}
+		if results[i].err != nil {
+			log.WithFields(log.Fields{"cluster": target.name}).Errorf("Unable to collect from cluster, Error: %s", results[i].err)
+		}
+		results[i].clusterInfo.ClusterName = target.name
+	}
+	return results
+}
+
+// fleetClusterInfo adds every collected cluster up. Node names are prefixed
+// with their cluster, and N-1 leaves out the single largest node of the fleet
+func fleetClusterInfo(results []clusterResult) (fleet ClusterInfo) {
+	fleet.ClusterName = fleetClusterName
+	fleet.NodeInfo = make(map[string]NodeInfo)
+	fleet.NamespaceTotals = make(map[string]NamespaceTotals)
+	for _, result := range results {
+		if result.err != nil {
+			continue
+		}
+		clusterInfo := result.clusterInfo
+		fleet.NodeLabel = clusterInfo.NodeLabel
+		fleet.NodeSelector = clusterInfo.NodeSelector
+		for name, node := range clusterInfo.NodeInfo {
+			fleet.NodeInfo[result.name+"/"+name] = node
+		}
+		for namespace, usage := range clusterInfo.NamespaceTotals {
+			nsTotals := fleet.NamespaceTotals[namespace]
+			nsTotals.CPURequestsMilliCores = nsTotals.CPURequestsMilliCores + usage.CPURequestsMilliCores
+			nsTotals.CPULimitsMilliCores = nsTotals.CPULimitsMilliCores + usage.CPULimitsMilliCores
+			nsTotals.MemoryRequests = nsTotals.MemoryRequests + usage.MemoryRequests
+			nsTotals.MemoryLimits = nsTotals.MemoryLimits + usage.MemoryLimits
+			nsTotals.Pods = nsTotals.Pods + usage.Pods
+			fleet.NamespaceTotals[namespace] = nsTotals
+		}
+		if clusterInfo.NminusCPU.Cmp(fleet.NminusCPU) > 0 {
+			fleet.NminusCPU = clusterInfo.NminusCPU
+			fleet.NminusMemory = clusterInfo.NminusMemory
+			fleet.NminusPods = clusterInfo.NminusPods
+		}
+		fleet.ClusterAllocatableMemory.Add(clusterInfo.ClusterAllocatableMemory)
+		fleet.ClusterAllocatableCPU.Add(clusterInfo.ClusterAllocatableCPU)
+		fleet.ClusterAllocatablePods.Add(clusterInfo.ClusterAllocatablePods)
+		fleet.RqclusterAllocatedLimitsMemory.Add(clusterInfo.RqclusterAllocatedLimitsMemory)
+		fleet.RqclusterAllocatedLimitsCPU.Add(clusterInfo.RqclusterAllocatedLimitsCPU)
+		fleet.RqclusterAllocatedPods.Add(clusterInfo.RqclusterAllocatedPods)
+		fleet.RqclusterAllocatedRequestsMemory.Add(clusterInfo.RqclusterAllocatedRequestsMemory)
+		fleet.RqclusterAllocatedRequestsCPU.Add(clusterInfo.RqclusterAllocatedRequestsCPU)
+	}
+	return fleet
+}
+
+// fleetCapcity is the roll-up event, listing the clusters it covers and the ones that failed
+func fleetCapcity(results []clusterResult) Capcity {
+	capCity := calculateCapcity(fleetClusterInfo(results))
+	for _, result := range results {
+		if result.err != nil {
+			capCity.FleetFailedClusters = append(capCity.FleetFailedClusters, result.name)
+			continue
+		}
+		capCity.FleetClusters = append(capCity.FleetClusters, result.name)
+	}
+	return capCity
+}
+
+// runMultiCluster prints every cluster followed by the fleet roll-up and
+// returns how many clusters failed
+func runMultiCluster(ctx context.Context, targets []clusterTarget, nodeLabel *string, jsonMode bool) (failed int) {
+	results := collectClusters(ctx, targets, func(ctx context.Context, clientset *kubernetes.Clientset) ClusterInfo {
+		return gatherInfo(ctx, clientset, nodeLabel)
+	})
+	for _, result := range results {
+		if result.err != nil {
+			failed++
+			if !jsonMode {
+				fmt.Println("================")
+				fmt.Printf("Cluster: %s\n", result.name)
+				fmt.Printf("Unable to collect, Error: %s\n", result.err)
+			}
+			continue
+		}
+		if jsonMode {
+			printCapcity(calculateCapcity(result.clusterInfo))
+			continue
+		}
+		fmt.Println("================")
+		fmt.Printf("Cluster: %s\n", result.name)
+		humanMode(result.clusterInfo)
+	}
+	if jsonMode {
+		printCapcity(fleetCapcity(results))
+		return failed
+	}
+	fmt.Println("================")
+	fmt.Printf("Fleet: %d clusters, %d failed\n", len(results), failed)
+	humanMode(fleetClusterInfo(results))
+	return failed
+}
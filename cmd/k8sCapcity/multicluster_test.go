@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+const testKubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: c
+  cluster:
+    server: https://%s.example.com
+users:
+- name: u
+  user:
+    token: abc
+contexts:
+- name: prod-east
+  context: {cluster: c, user: u}
+- name: prod-west
+  context: {cluster: c, user: u}
+- name: %s
+  context: {cluster: c, user: u}
+current-context: prod-east
+`
+
+func TestMatchContexts(t *testing.T) {
+	names := []string{"dev", "prod-east", "prod-west"}
+	matched, err := matchContexts(names, "prod-*, dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareString(strings.Join(matched, ","), "dev,prod-east,prod-west", t)
+	matched, err = matchContexts(names, "all")
+	if err != nil || len(matched) != 3 {
+		t.Errorf("Expected every context, got %v %v", matched, err)
+	}
+	_, err = matchContexts(names, "staging")
+	if err == nil {
+		t.Errorf("Expected an error for a context that does not exist")
+	}
+}
+
+func TestLoadClusterTargets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubeconfigs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"one", "two"} {
+		content := strings.Replace(strings.Replace(testKubeconfig, "%s", name, 1), "%s", name+"-only", 1)
+		err = ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	targets, err := loadClusterTargets(&kubeconfigFlags{}, dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, target := range targets {
+		names = append(names, target.name)
+	}
+	compareString(strings.Join(names, ","), "one-only,one/prod-east,one/prod-west,two-only,two/prod-east,two/prod-west", t)
+	compareString(targets[0].config.Host, "https://one.example.com", t)
+
+	targets, err = loadClusterTargets(&kubeconfigFlags{kubeconfig: filepath.Join(dir, "two")}, "", "prod-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(targets) != 2 || targets[1].name != "prod-west" {
+		t.Errorf("Expected prod-east and prod-west, got %+v", targets)
+	}
+}
+
+func TestCollectClusters(t *testing.T) {
+	targets := []clusterTarget{
+		{name: "a", config: &rest.Config{Host: "https://a.example.com"}},
+		{name: "b", config: &rest.Config{Host: "https://b.example.com"}},
+		{name: "c", config: &rest.Config{Host: "https://c.example.com"}},
+	}
+	collect := func(ctx context.Context, clientset *kubernetes.Clientset) ClusterInfo {
+		if strings.Contains(clientset.RESTClient().Get().URL().Host, "b.") {
+			panic("connection refused")
+		}
+		clusterInfo := ClusterInfo{NodeInfo: map[string]NodeInfo{"node": {PrintOutput: true}}}
+		clusterInfo.ClusterAllocatableCPU = resource.MustParse("4")
+		clusterInfo.NminusCPU = resource.MustParse("2")
+		return clusterInfo
+	}
+	results := collectClusters(context.Background(), targets, collect)
+	if results[0].err != nil || results[2].err != nil {
+		t.Fatalf("Expected a and c to be collected, got %v %v", results[0].err, results[2].err)
+	}
+	if results[1].err == nil || !strings.Contains(results[1].err.Error(), "connection refused") {
+		t.Errorf("Expected b to fail on its own, got %v", results[1].err)
+	}
+	compareString(results[2].clusterInfo.ClusterName, "c", t)
+
+	fleet := fleetClusterInfo(results)
+	if fleet.ClusterAllocatableCPU.Value() != 8 || len(fleet.NodeInfo) != 2 || fleet.NminusCPU.Value() != 2 {
+		t.Errorf("Expected a and c added up, got %+v", fleet)
+	}
+	capCity := fleetCapcity(results)
+	compareString(capCity.ClusterName, "fleet", t)
+	compareString(strings.Join(capCity.FleetClusters, ","), "a,c", t)
+	compareString(strings.Join(capCity.FleetFailedClusters, ","), "b", t)
+}
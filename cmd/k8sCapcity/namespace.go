@@ -1,25 +1,28 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	corev1 "k8s.io/api/core/v1"
 	resource "k8s.io/apimachinery/pkg/api/resource"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	metricsv1b1 "k8s.io/metrics/pkg/apis/metrics/v1beta1"
 )
 
-func getPodMetrics(clientset *kubernetes.Clientset) (podMetricList *metricsv1b1.PodMetricsList) {
-	data, err := clientset.RESTClient().Get().AbsPath("apis/metrics.k8s.io/v1beta1/pods").DoRaw()
+func getPodMetrics(ctx context.Context, clientset *kubernetes.Clientset) (podMetricList *metricsv1b1.PodMetricsList) {
+	data, err := clientset.RESTClient().Get().AbsPath("apis/metrics.k8s.io/v1beta1/pods").Context(ctx).DoRaw()
 	check(err)
 	err = json.Unmarshal(data, &podMetricList)
 	check(err)
 	return podMetricList
 }
 
-func getPodList(clientset *kubernetes.Clientset, nameSpace *string) (pods *corev1.PodList) {
-	pods, err := clientset.CoreV1().Pods(*nameSpace).List(metav1.ListOptions{})
+func getPodList(ctx context.Context, clientset *kubernetes.Clientset, nameSpace *string) (pods *corev1.PodList) {
+	pods = &corev1.PodList{}
+	err := listPods(ctx, clientset, *nameSpace, "", func(pod *corev1.Pod) {
+		pods.Items = append(pods.Items, *pod)
+	})
 	check(err)
 	return pods
 }
@@ -48,15 +51,72 @@ func gatherPodSpecInfo(pod corev1.Pod, nsInfo NamespaceInfo) NamespaceInfo {
 	return nsInfo
 }
 
-func gatherNamespaceInfo(clientset *kubernetes.Clientset, nameSpace *string) NamespaceInfo {
+func gatherNamespaceInfo(ctx context.Context, clientset *kubernetes.Clientset, nameSpace *string) NamespaceInfo {
+	var podMetricList *metricsv1b1.PodMetricsList
+	var podList *corev1.PodList
+	err := runConcurrently(ctx,
+		apiCall{"podmetrics", func(ctx context.Context) error {
+			podMetricList = getPodMetrics(ctx, clientset)
+			return nil
+		}},
+		apiCall{"pods", func(ctx context.Context) error {
+			podList = getPodList(ctx, clientset, nameSpace)
+			return nil
+		}},
+	)
+	check(err)
+	return namespaceInfo(*nameSpace, podMetricList, podList)
+}
+
+// gatherNamespaceInfos is gatherNamespaceInfo for every namespace with pod
+// metrics, from one list of pods and one of pod metrics
+func gatherNamespaceInfos(ctx context.Context, clientset *kubernetes.Clientset) map[string]NamespaceInfo {
+	allNamespaces := ""
+	var podMetricList *metricsv1b1.PodMetricsList
+	var podList *corev1.PodList
+	err := runConcurrently(ctx,
+		apiCall{"podmetrics", func(ctx context.Context) error {
+			podMetricList = getPodMetrics(ctx, clientset)
+			return nil
+		}},
+		apiCall{"pods", func(ctx context.Context) error {
+			podList = getPodList(ctx, clientset, &allNamespaces)
+			return nil
+		}},
+	)
+	check(err)
+	metricsByNamespace := make(map[string]*metricsv1b1.PodMetricsList)
+	for _, metricPod := range podMetricList.Items {
+		if metricsByNamespace[metricPod.Namespace] == nil {
+			metricsByNamespace[metricPod.Namespace] = &metricsv1b1.PodMetricsList{}
+		}
+		metricsByNamespace[metricPod.Namespace].Items = append(metricsByNamespace[metricPod.Namespace].Items, metricPod)
+	}
+	podsByNamespace := make(map[string]*corev1.PodList)
+	for _, pod := range podList.Items {
+		if podsByNamespace[pod.Namespace] == nil {
+			podsByNamespace[pod.Namespace] = &corev1.PodList{}
+		}
+		podsByNamespace[pod.Namespace].Items = append(podsByNamespace[pod.Namespace].Items, pod)
+	}
+	nsInfos := make(map[string]NamespaceInfo)
+	for name, metrics := range metricsByNamespace {
+		pods := podsByNamespace[name]
+		if pods == nil {
+			pods = &corev1.PodList{}
+		}
+		nsInfos[name] = namespaceInfo(name, metrics, pods)
+	}
+	return nsInfos
+}
 
+// namespaceInfo adds up the pods of nameSpace that have metrics
+func namespaceInfo(nameSpace string, podMetricList *metricsv1b1.PodMetricsList, podList *corev1.PodList) NamespaceInfo {
 	nsInfo := NamespaceInfo{}
-	podMetricList := getPodMetrics(clientset)
-	podList := getPodList(clientset, nameSpace)
 	nsInfo.NamespacePods = make(map[string]*Pod)
 	namespacePods := make(map[string]bool)
 	for _, metricPod := range podMetricList.Items {
-		if *nameSpace == metricPod.Namespace {
+		if nameSpace == metricPod.Namespace {
 			containerArray := make(map[string]ContainerInfo)
 			for _, container := range metricPod.Containers {
 				uniqueContainerName := fmt.Sprintf("%s-%s", metricPod.Name, container.Name)
@@ -76,7 +136,7 @@ func gatherNamespaceInfo(clientset *kubernetes.Clientset, nameSpace *string) Nam
 		}
 	}
 	for _, pod := range podList.Items {
-		if namespacePods[pod.Name] {
+		if pod.Namespace == nameSpace && namespacePods[pod.Name] {
 			if pod.Status.Phase != "Failed" {
 				if pod.Status.Phase != "Succeeded" {
 					nsInfo = gatherPodSpecInfo(pod, nsInfo)
@@ -90,7 +150,7 @@ func gatherNamespaceInfo(clientset *kubernetes.Clientset, nameSpace *string) Nam
 	nsInfo.NamespaceMemoryLimitsGiB = toGibFromByte(nsInfo.NamespaceMemoryLimits)
 	nsInfo.NamespaceMemoryRequestsGiB = toGibFromByte(nsInfo.NamespaceMemoryRequests)
 	nsInfo.NamespaceMemoryUsedGiB = toGibFromByte(nsInfo.NamespaceMemoryUsed)
-	nsInfo.Name = *nameSpace
+	nsInfo.Name = nameSpace
 	return nsInfo
 }
 
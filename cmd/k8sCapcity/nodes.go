@@ -0,0 +1,215 @@
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+// nodeEventVersion is the event.version of NodeCapacity
+const nodeEventVersion = "1"
+
+// NodeCapacity : Allocatable, requested and available resources of one selected node
+type NodeCapacity struct {
+	ECS
+	EventKind                     string            `json:"event.kind" description:"Always metric"`
+	EventModule                   string            `json:"event.module" description:"Always k8s_quota"`
+	EventProvider                 string            `json:"event.provider" description:"Always k8sCapcity"`
+	EventType                     string            `json:"event.type" description:"Always info"`
+	EventVersion                  string            `json:"event.version" description:"Version of this event's fields, see the schema $id"`
+	ClusterName                   string            `json:"k8s_quota.cluster_name,omitempty" description:"Kubeconfig context the event was collected from"`
+	NodeLabel                     string            `json:"k8s_quota.node_label" description:"The -nodelabel the nodes were selected with, blank for every node"`
+	Name                          string            `json:"k8s_quota.node.name" description:"Node name"`
+	HostName                      string            `json:"host.name" description:"Node name"`
+	CloudAvailabilityZone         string            `json:"cloud.availability_zone,omitempty" description:"topology.kubernetes.io/zone, or the beta failure-domain label"`
+	CloudRegion                   string            `json:"cloud.region,omitempty" description:"topology.kubernetes.io/region, or the beta failure-domain label"`
+	CloudMachineType              string            `json:"cloud.machine.type,omitempty" description:"node.kubernetes.io/instance-type, or the beta label"`
+	NodePool                      string            `json:"k8s_quota.node.node_pool,omitempty" description:"The first of the usual cloud node pool labels the node has"`
+	Labels                        map[string]string `json:"k8s_quota.node.labels,omitempty" description:"The -event-labels the node has"`
+	Ready                         bool              `json:"k8s_quota.node.ready" description:"The Ready condition is True"`
+	Unschedulable                 bool              `json:"k8s_quota.node.unschedulable" description:"The node is cordoned"`
+	Conditions                    map[string]string `json:"k8s_quota.node.conditions,omitempty" description:"Status (True, False or Unknown) of every node condition, by type"`
+	AllocatableCPUMilliCores      int64             `json:"k8s_quota.node.allocatable.cpu.millicores" description:"Millicores of allocatable cpu"`
+	AllocatableMemory             int64             `json:"k8s_quota.node.allocatable.memory.bytes" description:"Bytes of allocatable memory"`
+	AllocatablePods               int64             `json:"k8s_quota.node.allocatable.pods" description:"Allocatable pods"`
+	CPURequestMilliCores          int64             `json:"k8s_quota.node.container_resource.cpu_request.millicores" description:"Millicores of cpu requested by non-terminated pods"`
+	MemoryRequest                 int64             `json:"k8s_quota.node.container_resource.memory_request.bytes" description:"Bytes of memory requested by non-terminated pods"`
+	MemoryLimit                   int64             `json:"k8s_quota.node.container_resource.memory_limit.bytes" description:"Bytes of memory limits of non-terminated pods"`
+	Pods                          int64             `json:"k8s_quota.node.container_resource.pods" description:"Non-terminated pods"`
+	UsedCPUMilliCores             int64             `json:"k8s_quota.node.used.cpu.millicores" description:"Millicores of cpu in use, from metrics-server"`
+	UsedMemory                    int64             `json:"k8s_quota.node.used.memory.bytes" description:"Bytes of memory in use, from metrics-server"`
+	AvailableCPURequestMilliCores int64             `json:"k8s_quota.node.available.cpu_request.millicores" description:"Millicores of allocatable cpu not requested"`
+	AvailableMemoryRequest        int64             `json:"k8s_quota.node.available.memory_request.bytes" description:"Bytes of allocatable memory not requested"`
+	AvailablePods                 int64             `json:"k8s_quota.node.available.pods" description:"Allocatable pods not scheduled"`
+	UtilizationFactorCPURequest   float64           `json:"k8s_quota.node.utilization_factor.cpu_request" description:"Cpu requests / allocatable cpu"`
+	UtilizationFactorMemory       float64           `json:"k8s_quota.node.utilization_factor.memory_request" description:"Memory requests / allocatable memory"`
+	UtilizationFactorPods         float64           `json:"k8s_quota.node.utilization_factor.pods" description:"Pods / allocatable pods"`
+	UtilizationFactorCPUUsed      float64           `json:"k8s_quota.node.utilization_factor.cpu_used" description:"Cpu in use / allocatable cpu"`
+	UtilizationFactorMemoryUsed   float64           `json:"k8s_quota.node.utilization_factor.memory_used" description:"Memory in use / allocatable memory"`
+}
+
+// firstLabel is the value of the first of keys the node has
+func firstLabel(labels map[string]string, keys ...string) string {
+	for _, key := range keys {
+		if value, ok := labels[key]; ok {
+			return value
+		}
+	}
+	return ""
+}
+
+func ratio(used, allocatable int64) float64 {
+	if allocatable == 0 {
+		return 0
+	}
+	return float64(used) / float64(allocatable)
+}
+
+// nodeCapacities lists the selected nodes by name
+func nodeCapacities(clusterInfo ClusterInfo) (nodes []NodeCapacity) {
+	now := time.Now()
+	for name, node := range clusterInfo.NodeInfo {
+		if !node.PrintOutput {
+			continue
+		}
+		n := NodeCapacity{
+			ECS:                      newECS("k8s_quota.node", clusterInfo.ClusterName, now),
+			EventKind:                "metric",
+			EventModule:              "k8s_quota",
+			EventProvider:            "k8sCapcity",
+			EventType:                "info",
+			EventVersion:             nodeEventVersion,
+			ClusterName:              clusterInfo.ClusterName,
+			NodeLabel:                clusterInfo.NodeLabel,
+			Name:                     name,
+			HostName:                 name,
+			CloudAvailabilityZone:    firstLabel(node.Labels, "topology.kubernetes.io/zone", "failure-domain.beta.kubernetes.io/zone"),
+			CloudRegion:              firstLabel(node.Labels, "topology.kubernetes.io/region", "failure-domain.beta.kubernetes.io/region"),
+			CloudMachineType:         firstLabel(node.Labels, "node.kubernetes.io/instance-type", "beta.kubernetes.io/instance-type"),
+			NodePool:                 firstLabel(node.Labels, nodePoolLabels...),
+			Ready:                    node.Conditions["Ready"] == "True",
+			Unschedulable:            node.Unschedulable,
+			Conditions:               node.Conditions,
+			AllocatableCPUMilliCores: node.AllocatableCPU.MilliValue(),
+			AllocatableMemory:        node.AllocatableMemory.Value(),
+			AllocatablePods:          node.AllocatablePods.Value(),
+			CPURequestMilliCores:     node.UsedCPURequests.MilliValue(),
+			MemoryRequest:            node.UsedMemoryRequests.Value(),
+			MemoryLimit:              node.UsedMemoryLimits.Value(),
+			Pods:                     node.UsedPods,
+			UsedCPUMilliCores:        node.UsedCPU.MilliValue(),
+			UsedMemory:               node.UsedMemory.Value(),
+		}
+		n.AvailableCPURequestMilliCores = n.AllocatableCPUMilliCores - n.CPURequestMilliCores
+		n.AvailableMemoryRequest = n.AllocatableMemory - n.MemoryRequest
+		n.AvailablePods = n.AllocatablePods - n.Pods
+		n.UtilizationFactorCPURequest = ratio(n.CPURequestMilliCores, n.AllocatableCPUMilliCores)
+		n.UtilizationFactorMemory = ratio(n.MemoryRequest, n.AllocatableMemory)
+		n.UtilizationFactorPods = ratio(n.Pods, n.AllocatablePods)
+		n.UtilizationFactorCPUUsed = ratio(n.UsedCPUMilliCores, n.AllocatableCPUMilliCores)
+		n.UtilizationFactorMemoryUsed = ratio(n.UsedMemory, n.AllocatableMemory)
+		nodes = append(nodes, n)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Name < nodes[j].Name })
+	return nodes
+}
+
+// nodeEvents is nodeCapacities with the labels named in eventLabels copied in
+func nodeEvents(clusterInfo ClusterInfo, eventLabels []string) []NodeCapacity {
+	nodes := nodeCapacities(clusterInfo)
+	for i := range nodes {
+		labels := clusterInfo.NodeInfo[nodes[i].Name].Labels
+		for _, key := range eventLabels {
+			if value, ok := labels[key]; ok {
+				if nodes[i].Labels == nil {
+					nodes[i].Labels = make(map[string]string)
+				}
+				nodes[i].Labels[key] = value
+			}
+		}
+	}
+	return nodes
+}
+
+// nodePoolLabels are the labels cloud providers put the node pool name in,
+// tried in order when no -pool-label is given
+var nodePoolLabels = []string{
+	"cloud.google.com/gke-nodepool",
+	"eks.amazonaws.com/nodegroup",
+	"kubernetes.azure.com/agentpool",
+	"agentpool",
+	"node.kubernetes.io/instance-type",
+}
+
+// NodePoolCapacity : Capacity of the selected nodes sharing a pool label value
+type NodePoolCapacity struct {
+	Name                                   string  `json:"k8s_quota.node_pool.name"`
+	Nodes                                  int64   `json:"k8s_quota.node_pool.nodes"`
+	AllocatableCPUMilliCores               int64   `json:"k8s_quota.node_pool.allocatable.cpu.millicores"`
+	AllocatableMemory                      int64   `json:"k8s_quota.node_pool.allocatable.memory.bytes"`
+	AllocatablePods                        int64   `json:"k8s_quota.node_pool.allocatable.pods"`
+	CPURequestMilliCores                   int64   `json:"k8s_quota.node_pool.container_resource.cpu_request.millicores"`
+	MemoryRequest                          int64   `json:"k8s_quota.node_pool.container_resource.memory_request.bytes"`
+	Pods                                   int64   `json:"k8s_quota.node_pool.container_resource.pods"`
+	AvailableCPURequestMilliCores          int64   `json:"k8s_quota.node_pool.available.cpu_request.millicores"`
+	AvailableMemoryRequest                 int64   `json:"k8s_quota.node_pool.available.memory_request.bytes"`
+	AvailablePods                          int64   `json:"k8s_quota.node_pool.available.pods"`
+	AvailableCPURequestNminusoneMilliCores int64   `json:"k8s_quota.node_pool.available.cpu_request.nminusone.millicores"`
+	AvailableMemoryRequestNminusone        int64   `json:"k8s_quota.node_pool.available.memory_request.nminusone.bytes"`
+	AvailablePodsNminusone                 int64   `json:"k8s_quota.node_pool.available.pods.nminusone"`
+	UtilizationFactorCPURequest            float64 `json:"k8s_quota.node_pool.utilization_factor.cpu_request"`
+	UtilizationFactorMemory                float64 `json:"k8s_quota.node_pool.utilization_factor.memory_request"`
+	UtilizationFactorPods                  float64 `json:"k8s_quota.node_pool.utilization_factor.pods"`
+}
+
+// nodePool is the value of poolLabel, or of the first nodePoolLabels the node has
+func nodePool(labels map[string]string, poolLabel string) string {
+	candidates := nodePoolLabels
+	if poolLabel != "" {
+		candidates = []string{poolLabel}
+	}
+	for _, label := range candidates {
+		if value, ok := labels[label]; ok {
+			return value
+		}
+	}
+	return "<none>"
+}
+
+// nodePoolCapacities sums the selected nodes per pool, by name. N-1 loses the
+// node with the most cpu in the pool, like the cluster wide N-1
+func nodePoolCapacities(clusterInfo ClusterInfo, poolLabel string) (pools []NodePoolCapacity) {
+	byName := make(map[string]*NodePoolCapacity)
+	largest := make(map[string]NodeCapacity)
+	for _, node := range nodeCapacities(clusterInfo) {
+		name := nodePool(clusterInfo.NodeInfo[node.Name].Labels, poolLabel)
+		pool, ok := byName[name]
+		if !ok {
+			pool = &NodePoolCapacity{Name: name}
+			byName[name] = pool
+		}
+		pool.Nodes++
+		pool.AllocatableCPUMilliCores += node.AllocatableCPUMilliCores
+		pool.AllocatableMemory += node.AllocatableMemory
+		pool.AllocatablePods += node.AllocatablePods
+		pool.CPURequestMilliCores += node.CPURequestMilliCores
+		pool.MemoryRequest += node.MemoryRequest
+		pool.Pods += node.Pods
+		if node.AllocatableCPUMilliCores > largest[name].AllocatableCPUMilliCores {
+			largest[name] = node
+		}
+	}
+	for name, pool := range byName {
+		pool.AvailableCPURequestMilliCores = pool.AllocatableCPUMilliCores - pool.CPURequestMilliCores
+		pool.AvailableMemoryRequest = pool.AllocatableMemory - pool.MemoryRequest
+		pool.AvailablePods = pool.AllocatablePods - pool.Pods
+		pool.AvailableCPURequestNminusoneMilliCores = pool.AvailableCPURequestMilliCores - largest[name].AllocatableCPUMilliCores
+		pool.AvailableMemoryRequestNminusone = pool.AvailableMemoryRequest - largest[name].AllocatableMemory
+		pool.AvailablePodsNminusone = pool.AvailablePods - largest[name].AllocatablePods
+		pool.UtilizationFactorCPURequest = ratio(pool.CPURequestMilliCores, pool.AllocatableCPUMilliCores)
+		pool.UtilizationFactorMemory = ratio(pool.MemoryRequest, pool.AllocatableMemory)
+		pool.UtilizationFactorPods = ratio(pool.Pods, pool.AllocatablePods)
+		pools = append(pools, *pool)
+	}
+	sort.Slice(pools, func(i, j int) bool { return pools[i].Name < pools[j].Name })
+	return pools
+}
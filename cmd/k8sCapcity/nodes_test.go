@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestNodeCapacities(t *testing.T) {
+	clusterInfo := ClusterInfo{NodeInfo: map[string]NodeInfo{
+		"node-b": {PrintOutput: true, AllocatableCPU: resource.MustParse("4"), AllocatableMemory: resource.MustParse("16Gi"),
+			AllocatablePods: resource.MustParse("110"), UsedCPURequests: resource.MustParse("1"), UsedPods: 11},
+		"node-a": {PrintOutput: true, AllocatablePods: resource.MustParse("110")},
+		"node-c": {},
+	}}
+	nodes := nodeCapacities(clusterInfo)
+	if len(nodes) != 2 || nodes[0].Name != "node-a" {
+		t.Fatalf("Expected node-a and node-b, got %+v", nodes)
+	}
+	if nodes[1].AvailableCPURequestMilliCores != 3000 || nodes[1].AvailablePods != 99 || nodes[1].UtilizationFactorCPURequest != 0.25 {
+		t.Errorf("Unexpected node-b %+v", nodes[1])
+	}
+	if nodes[0].UtilizationFactorCPURequest != 0 {
+		t.Errorf("Expected 0 utilization without allocatable cpu, got %v", nodes[0].UtilizationFactorCPURequest)
+	}
+}
+
+func TestNodeEvents(t *testing.T) {
+	clusterInfo := ClusterInfo{NodeLabel: "role=compute", NodeInfo: map[string]NodeInfo{
+		"node-a": {PrintOutput: true, AllocatableCPU: resource.MustParse("4"), UsedCPU: resource.MustParse("1"), Unschedulable: true,
+			Labels: map[string]string{"topology.kubernetes.io/zone": "us-east-1a", "beta.kubernetes.io/instance-type": "m5.xlarge",
+				"eks.amazonaws.com/nodegroup": "general", "team": "web"},
+			Conditions: map[string]string{"Ready": "True", "MemoryPressure": "False"}},
+		"node-b": {PrintOutput: true, Conditions: map[string]string{"Ready": "Unknown"}},
+	}}
+	nodes := nodeEvents(clusterInfo, []string{"team", "missing"})
+	if len(nodes) != 2 {
+		t.Fatalf("Expected 2 node events, got %+v", nodes)
+	}
+	a, b := nodes[0], nodes[1]
+	compareString(a.EventDataset, "k8s_quota.node", t)
+	compareString(a.NodeLabel, "role=compute", t)
+	compareString(strings.Join([]string{a.HostName, a.CloudAvailabilityZone, a.CloudRegion, a.CloudMachineType, a.NodePool}, ","), "node-a,us-east-1a,,m5.xlarge,general", t)
+	if !a.Ready || !a.Unschedulable || a.UtilizationFactorCPUUsed != 0.25 || len(a.Labels) != 1 || a.Labels["team"] != "web" {
+		t.Errorf("Unexpected node-a %+v", a)
+	}
+	if b.Ready || b.Labels != nil || b.Conditions["Ready"] != "Unknown" {
+		t.Errorf("Unexpected node-b %+v", b)
+	}
+}
+
+func TestNamespacesTable(t *testing.T) {
+	var out bytes.Buffer
+	namespacesTable(&out, map[string]NamespaceTotals{
+		"web":     {Pods: 2, CPURequestsMilliCores: 500, MemoryRequests: 1 << 30},
+		"default": {Pods: 1},
+	})
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[1], "default") {
+		t.Fatalf("Expected namespaces sorted by name, got %q", out.String())
+	}
+	compareString(strings.Join(strings.Fields(lines[2]), " "), "web 2 500m 0m 1024Mi 0Mi", t)
+}
+
+func TestNodePoolCapacities(t *testing.T) {
+	node := func(cpu string, labels map[string]string) NodeInfo {
+		return NodeInfo{PrintOutput: true, AllocatableCPU: resource.MustParse(cpu), AllocatableMemory: resource.MustParse("8Gi"),
+			AllocatablePods: resource.MustParse("110"), UsedCPURequests: resource.MustParse("1"), UsedPods: 10, Labels: labels}
+	}
+	clusterInfo := ClusterInfo{NodeInfo: map[string]NodeInfo{
+		"gke-a": node("4", map[string]string{"cloud.google.com/gke-nodepool": "general", "pool": "x"}),
+		"gke-b": node("8", map[string]string{"cloud.google.com/gke-nodepool": "general"}),
+		"other": node("2", nil),
+	}}
+	pools := nodePoolCapacities(clusterInfo, "")
+	if len(pools) != 2 || pools[0].Name != "<none>" || pools[1].Name != "general" {
+		t.Fatalf("Expected <none> and general, got %+v", pools)
+	}
+	general := pools[1]
+	if general.Nodes != 2 || general.AvailableCPURequestMilliCores != 10000 || general.AvailableCPURequestNminusoneMilliCores != 2000 || general.AvailablePodsNminusone != 90 {
+		t.Errorf("Unexpected general pool %+v", general)
+	}
+
+	pools = nodePoolCapacities(clusterInfo, "pool")
+	if len(pools) != 2 || pools[0].Name != "<none>" || pools[0].Nodes != 2 || pools[1].Name != "x" {
+		t.Errorf("Expected -pool-label to group by pool, got %+v", pools)
+	}
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"sigs.k8s.io/yaml"
+)
+
+// NotifyConfig : Contents of the -notify-config file, yaml or json
+type NotifyConfig struct {
+	SummaryInterval string           `json:"summaryInterval,omitempty"`
+	Endpoints       []NotifyEndpoint `json:"endpoints"`
+}
+
+// NotifyEndpoint : One http endpoint alerts and summaries are POSTed to
+type NotifyEndpoint struct {
+	Name        string            `json:"name"`
+	URL         string            `json:"url"`
+	Events      []string          `json:"events,omitempty"`
+	Template    string            `json:"template,omitempty"`
+	ContentType string            `json:"contentType,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	Secret      string            `json:"secret,omitempty"`
+	Retries     int               `json:"retries,omitempty"`
+	Backoff     string            `json:"backoff,omitempty"`
+	Timeout     string            `json:"timeout,omitempty"`
+}
+
+// NotifyMessage : What endpoint templates are rendered with, Alert or Summary is set depending on Kind
+type NotifyMessage struct {
+	Kind      string
+	Timestamp time.Time
+	Alert     *AlertEvent
+	Summary   *Capcity
+}
+
+// notifySignatureHeader carries the hex HMAC-SHA256 of the body when an endpoint has a secret
+const notifySignatureHeader = "X-K8sCapcity-Signature"
+
+// notifyQueueSize bounds the notifications waiting to be delivered, more are dropped
+const notifyQueueSize = 100
+
+type notifyTarget struct {
+	NotifyEndpoint
+	template *template.Template
+	events   map[string]bool
+	backoff  time.Duration
+	client   *http.Client
+}
+
+type notifyDelivery struct {
+	target  notifyTarget
+	message NotifyMessage
+}
+
+// notifier delivers in the background, so retries never hold up a daemon cycle
+type notifier struct {
+	targets         []notifyTarget
+	summaryInterval time.Duration
+	lastSummary     time.Time
+	dryRun          bool
+	out             io.Writer
+	sleep           func(time.Duration)
+
+	lock    sync.Mutex
+	started bool
+	stopped bool
+	queue   chan notifyDelivery
+	done    chan struct{}
+}
+
+var notifyTemplateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+	"percent": func(v float64) string {
+		return fmt.Sprintf("%.1f%%", v*100)
+	},
+	"toGibFromByte": toGibFromByte,
+}
+
+func loadNotifyConfig(path string) (NotifyConfig, error) {
+	config := NotifyConfig{}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return config, err
+	}
+	err = yaml.Unmarshal(data, &config)
+	if err != nil {
+		return config, fmt.Errorf("%s: %s", path, err)
+	}
+	return config, nil
+}
+
+func newNotifier(config NotifyConfig, dryRun bool, out io.Writer) (*notifier, error) {
+	n := &notifier{
+		dryRun: dryRun,
+		out:    out,
+		sleep:  time.Sleep,
+		queue:  make(chan notifyDelivery, notifyQueueSize),
+		done:   make(chan struct{}),
+	}
+	var err error
+	if config.SummaryInterval != "" {
+		n.summaryInterval, err = parseRetention(config.SummaryInterval)
+		if err != nil {
+			return nil, fmt.Errorf("summaryInterval: %s", err)
+		}
+	}
+	for i, endpoint := range config.Endpoints {
+		if endpoint.Name == "" {
+			endpoint.Name = fmt.Sprintf("endpoint-%d", i)
+		}
+		if endpoint.URL == "" {
+			return nil, fmt.Errorf("endpoint %s has no url", endpoint.Name)
+		}
+		if len(endpoint.Events) == 0 {
+			endpoint.Events = []string{"alert", "summary"}
+		}
+		target := notifyTarget{
+			NotifyEndpoint: endpoint,
+			events:         make(map[string]bool),
+			backoff:        time.Second,
+		}
+		for _, event := range endpoint.Events {
+			if event != "alert" && event != "summary" {
+				return nil, fmt.Errorf("endpoint %s: unknown event %q, expected alert or summary", endpoint.Name, event)
+			}
+			target.events[event] = true
+		}
+		if endpoint.Template != "" {
+			target.template, err = template.New(endpoint.Name).Funcs(notifyTemplateFuncs).Parse(endpoint.Template)
+			if err != nil {
+				return nil, fmt.Errorf("endpoint %s: %s", endpoint.Name, err)
+			}
+		}
+		if target.ContentType == "" {
+			target.ContentType = "application/json"
+		}
+		if endpoint.Backoff != "" {
+			target.backoff, err = time.ParseDuration(endpoint.Backoff)
+			if err != nil {
+				return nil, fmt.Errorf("endpoint %s: backoff: %s", endpoint.Name, err)
+			}
+		}
+		timeout := 10 * time.Second
+		if endpoint.Timeout != "" {
+			timeout, err = time.ParseDuration(endpoint.Timeout)
+			if err != nil {
+				return nil, fmt.Errorf("endpoint %s: timeout: %s", endpoint.Name, err)
+			}
+		}
+		target.client = &http.Client{Timeout: timeout}
+		n.targets = append(n.targets, target)
+	}
+	return n, nil
+}
+
+func (n *notifier) notifyAlerts(events []AlertEvent, now time.Time) {
+	for i := range events {
+		n.send(NotifyMessage{Kind: "alert", Timestamp: now, Alert: &events[i]})
+	}
+}
+
+// notifySummary sends capCity when summaryInterval has passed since the last summary
+func (n *notifier) notifySummary(capCity Capcity, now time.Time) {
+	if n.summaryInterval <= 0 || now.Sub(n.lastSummary) < n.summaryInterval {
+		return
+	}
+	n.lastSummary = now
+	n.send(NotifyMessage{Kind: "summary", Timestamp: now, Summary: &capCity})
+}
+
+// send queues message for every target that wants it, dropping it for a
+// target when the queue is full
+func (n *notifier) send(message NotifyMessage) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	if n.stopped {
+		return
+	}
+	if !n.started {
+		n.started = true
+		go n.run()
+	}
+	for _, target := range n.targets {
+		if !target.events[message.Kind] {
+			continue
+		}
+		select {
+		case n.queue <- notifyDelivery{target: target, message: message}:
+		default:
+			log.Errorf("Unable to notify %s, %d notifications are already waiting, dropping this %s", target.Name, cap(n.queue), message.Kind)
+		}
+	}
+}
+
+func (n *notifier) run() {
+	defer close(n.done)
+	for delivery := range n.queue {
+		err := n.deliver(delivery.target, delivery.message)
+		if err != nil {
+			log.Errorf("Unable to notify %s, Error: %s", delivery.target.Name, err)
+		}
+	}
+}
+
+// stop takes no more notifications, those queued are still delivered
+func (n *notifier) stop() {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	if !n.stopped {
+		n.stopped = true
+		close(n.queue)
+	}
+}
+
+// flush stops and waits for what was queued to be delivered
+func (n *notifier) flush() {
+	n.stop()
+	n.lock.Lock()
+	started := n.started
+	n.lock.Unlock()
+	if started {
+		<-n.done
+	}
+}
+
+func renderNotifyBody(target notifyTarget, message NotifyMessage) ([]byte, error) {
+	if target.template == nil {
+		if message.Alert != nil {
+			return json.Marshal(message.Alert)
+		}
+		return json.Marshal(message.Summary)
+	}
+	body := &bytes.Buffer{}
+	err := target.template.Execute(body, message)
+	return body.Bytes(), err
+}
+
+func signNotifyBody(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (n *notifier) deliver(target notifyTarget, message NotifyMessage) error {
+	body, err := renderNotifyBody(target, message)
+	if err != nil {
+		return err
+	}
+	if n.dryRun {
+		fmt.Fprintf(n.out, "dry-run: POST %s %s\n", target.URL, strings.TrimSpace(string(body)))
+		return nil
+	}
+	backoff := target.backoff
+	for attempt := 0; ; attempt++ {
+		retry, err := n.post(target, body)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= target.Retries {
+			return err
+		}
+		log.Warnf("Notify %s failed (attempt %d), retrying in %s, Error: %s", target.Name, attempt+1, backoff, err)
+		n.sleep(backoff)
+		backoff = backoff * 2
+	}
+}
+
+// post reports whether a failure is worth retrying
+func (n *notifier) post(target notifyTarget, body []byte) (retry bool, err error) {
+	req, err := http.NewRequest(http.MethodPost, target.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", target.ContentType)
+	req.Header.Set("User-Agent", "k8sCapcity")
+	for key, value := range target.Headers {
+		req.Header.Set(key, value)
+	}
+	if target.Secret != "" {
+		req.Header.Set(notifySignatureHeader, signNotifyBody(target.Secret, body))
+	}
+	resp, err := target.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	retry = resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+	return retry, fmt.Errorf("%s returned %s", target.URL, resp.Status)
+}
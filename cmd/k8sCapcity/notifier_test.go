@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type notifyStandIn struct {
+	sync.Mutex
+	failures  int
+	bodies    []string
+	signature string
+	server    *httptest.Server
+}
+
+func newNotifyStandIn(failures int) *notifyStandIn {
+	s := &notifyStandIn{failures: failures}
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.Lock()
+		defer s.Unlock()
+		if s.failures > 0 {
+			s.failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		s.bodies = append(s.bodies, string(body))
+		s.signature = r.Header.Get(notifySignatureHeader)
+	}))
+	return s
+}
+
+func testNotifier(t *testing.T, config NotifyConfig, dryRun bool, out *bytes.Buffer) *notifier {
+	n, err := newNotifier(config, dryRun, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.sleep = func(time.Duration) {}
+	return n
+}
+
+func TestNotifyAlertTemplateAndSignature(t *testing.T) {
+	standIn := newNotifyStandIn(0)
+	defer standIn.server.Close()
+	n := testNotifier(t, NotifyConfig{Endpoints: []NotifyEndpoint{{
+		Name:     "slack",
+		URL:      standIn.server.URL,
+		Events:   []string{"alert"},
+		Template: `{"text": "{{ .Alert.AlertName }} is {{ .Alert.EventAction }} at {{ percent .Alert.AlertValue }}"}`,
+		Secret:   "s3cret",
+	}}}, false, nil)
+	n.notifyAlerts([]AlertEvent{{AlertName: "memory", EventAction: "firing", AlertValue: 0.95}}, time.Now())
+	n.notifySummary(Capcity{}, time.Now())
+	n.flush()
+	if len(standIn.bodies) != 1 {
+		t.Fatalf("Expected 1 POST, got %d", len(standIn.bodies))
+	}
+	compareString(standIn.bodies[0], `{"text": "memory is firing at 95.0%"}`, t)
+	compareString(standIn.signature, signNotifyBody("s3cret", []byte(standIn.bodies[0])), t)
+}
+
+func TestNotifyRetries(t *testing.T) {
+	standIn := newNotifyStandIn(2)
+	defer standIn.server.Close()
+	config := NotifyConfig{Endpoints: []NotifyEndpoint{{URL: standIn.server.URL, Retries: 2}}}
+	n := testNotifier(t, config, false, nil)
+	n.notifyAlerts([]AlertEvent{{AlertName: "memory"}}, time.Now())
+	n.flush()
+	if len(standIn.bodies) != 1 {
+		t.Fatalf("Expected delivery on the third attempt, got %d bodies", len(standIn.bodies))
+	}
+
+	standIn.failures = 3
+	err := n.deliver(n.targets[0], NotifyMessage{Kind: "alert", Alert: &AlertEvent{}})
+	if err == nil {
+		t.Errorf("Expected an error once retries are used up")
+	}
+}
+
+func TestNotifySummaryInterval(t *testing.T) {
+	standIn := newNotifyStandIn(0)
+	defer standIn.server.Close()
+	config := NotifyConfig{SummaryInterval: "1h", Endpoints: []NotifyEndpoint{{URL: standIn.server.URL, Events: []string{"summary"}}}}
+	n := testNotifier(t, config, false, nil)
+	now := time.Date(2020, 3, 2, 0, 0, 0, 0, time.UTC)
+	n.notifySummary(Capcity{EventKind: "metric"}, now)
+	n.notifySummary(Capcity{EventKind: "metric"}, now.Add(30*time.Minute))
+	n.notifySummary(Capcity{EventKind: "metric"}, now.Add(time.Hour))
+	n.flush()
+	if len(standIn.bodies) != 2 {
+		t.Fatalf("Expected 2 summaries, got %d", len(standIn.bodies))
+	}
+	if !strings.Contains(standIn.bodies[0], `"event.kind":"metric"`) {
+		t.Errorf("Expected the default json body, got %s", standIn.bodies[0])
+	}
+}
+
+func TestNotifyDryRun(t *testing.T) {
+	out := &bytes.Buffer{}
+	n := testNotifier(t, NotifyConfig{Endpoints: []NotifyEndpoint{{URL: "http://127.0.0.1:1/hook"}}}, true, out)
+	n.notifyAlerts([]AlertEvent{{AlertName: "memory"}}, time.Now())
+	n.flush()
+	if !strings.HasPrefix(out.String(), "dry-run: POST http://127.0.0.1:1/hook {") {
+		t.Errorf("Unexpected dry-run output %q", out.String())
+	}
+}
+
+func TestNotifyQueue(t *testing.T) {
+	release := make(chan bool)
+	var delivered int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		atomic.AddInt32(&delivered, 1)
+	}))
+	defer server.Close()
+	n := testNotifier(t, NotifyConfig{Endpoints: []NotifyEndpoint{{URL: server.URL}}}, false, nil)
+	n.queue = make(chan notifyDelivery, 1)
+	sent := make(chan bool)
+	go func() {
+		// At most one is being delivered and one waits, the rest are dropped
+		for i := 0; i < 5; i++ {
+			n.notifyAlerts([]AlertEvent{{AlertName: "memory"}}, time.Now())
+		}
+		sent <- true
+	}()
+	select {
+	case <-sent:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected notifying not to wait for a stuck endpoint")
+	}
+	close(release)
+	n.flush()
+	// Nothing is taken once stopped
+	n.notifyAlerts([]AlertEvent{{AlertName: "memory"}}, time.Now())
+	if delivered := atomic.LoadInt32(&delivered); delivered < 1 || delivered > 2 {
+		t.Errorf("Expected 1 or 2 deliveries, got %d", delivered)
+	}
+}
+
+func TestNewNotifierErrors(t *testing.T) {
+	bad := []NotifyConfig{
+		{Endpoints: []NotifyEndpoint{{Name: "no-url"}}},
+		{Endpoints: []NotifyEndpoint{{URL: "http://x", Events: []string{"metric"}}}},
+		{Endpoints: []NotifyEndpoint{{URL: "http://x", Template: "{{ .Broken"}}},
+		{Endpoints: []NotifyEndpoint{{URL: "http://x", Backoff: "soon"}}},
+		{SummaryInterval: "daily"},
+	}
+	for _, config := range bad {
+		_, err := newNotifier(config, false, nil)
+		if err == nil {
+			t.Errorf("Expected an error for %+v", config)
+		}
+	}
+}
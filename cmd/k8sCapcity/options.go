@@ -0,0 +1,517 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/transport"
+)
+
+// clusterOptions : How to reach the cluster and which nodes to look at
+type clusterOptions struct {
+	kubeconfig     *kubeconfigFlags
+	nodeLabel      string
+	collectTimeout time.Duration
+	parallelism    int
+	debug          bool
+	eventVersion   eventVersionFlag
+	clusterName    string
+}
+
+func addClusterFlags(flags *flag.FlagSet) *clusterOptions {
+	o := &clusterOptions{kubeconfig: addKubeconfigFlags(flags)}
+	flags.DurationVar(&o.collectTimeout, "collect-timeout", 0, "Give up on collecting nodes, pods, quotas and metrics after this long. 0 uses -cycle-timeout in daemon mode, otherwise waits forever")
+	flags.IntVar(&o.parallelism, "parallelism", 4, "Most api calls to have in flight at once while collecting")
+	flags.BoolVar(&o.debug, "debug", false, "Log debug messages, such as the latency of every api call")
+	flags.StringVar(&o.clusterName, "cluster-name", "", "Cluster name for orchestrator.cluster.name in json events, -contexts uses the context names")
+	return o
+}
+
+func (o *clusterOptions) addNodeLabelFlag(flags *flag.FlagSet) {
+	flags.Var((*nodeSelectorFlag)(&o.nodeLabel), "nodelabel", "Label selector for nodes, e.g. pool=a or 'pool in (a,b),!spot', if blank grab all nodes")
+}
+
+// nodeSelectorFlag : -nodelabel, refused unless it parses as a label selector
+type nodeSelectorFlag string
+
+func (f *nodeSelectorFlag) String() string {
+	return string(*f)
+}
+
+func (f *nodeSelectorFlag) Set(value string) error {
+	if _, err := parseNodeSelector(value); err != nil {
+		return err
+	}
+	*f = nodeSelectorFlag(value)
+	return nil
+}
+
+func (o *clusterOptions) addEventVersionFlag(flags *flag.FlagSet) {
+	o.eventVersion = 1
+	flags.Var(&o.eventVersion, "event-version", "Json event to print, 1 for the original flat fields or 2 for nested fields with corrected names, see k8sCapcity schema")
+}
+
+func (o *clusterOptions) setUp() {
+	log.SetLevel(log.InfoLevel)
+	if o.debug {
+		log.SetLevel(log.DebugLevel)
+	}
+	setSettings(runSettings{parallelism: o.parallelism, eventVersion: o.eventVersion, clusterName: o.clusterName})
+}
+
+// runSettings is what setUp takes from clusterOptions for the whole run. A
+// daemon reload sets it again while -listen answers, so it is only touched
+// through currentSettings and setSettings
+type runSettings struct {
+	// parallelism bounds how many api calls one collection has in flight
+	parallelism int
+	// eventVersion is which event -json and the daemon print
+	eventVersion eventVersionFlag
+	// clusterName is orchestrator.cluster.name when the event has none
+	clusterName string
+}
+
+var (
+	settingsLock sync.RWMutex
+	settings     = runSettings{parallelism: 4, eventVersion: 1}
+)
+
+func currentSettings() runSettings {
+	settingsLock.RLock()
+	defer settingsLock.RUnlock()
+	return settings
+}
+
+// setSettings returns the settings it replaced
+func setSettings(s runSettings) runSettings {
+	settingsLock.Lock()
+	defer settingsLock.Unlock()
+	previous := settings
+	settings = s
+	return previous
+}
+
+// restConfig is -kubeconfig and friends. Deadlines of collections and
+// cycles go through their context, Timeout would cut every watch short
+func (o *clusterOptions) restConfig() (*rest.Config, error) {
+	config, err := o.kubeconfig.restConfig()
+	if err != nil {
+		return nil, err
+	}
+	config.WrapTransport = transport.Wrappers(config.WrapTransport, instrumentTransport)
+	return config, nil
+}
+
+// clientset connects with -kubeconfig and friends
+func (o *clusterOptions) clientset() (*kubernetes.Clientset, error) {
+	config, err := o.restConfig()
+	if err != nil {
+		return nil, err
+	}
+	return kubernetes.NewForConfig(config)
+}
+
+// dynamicClient connects like clientset, for the capacity report custom resources
+func (o *clusterOptions) dynamicClient() (dynamic.Interface, error) {
+	config, err := o.restConfig()
+	if err != nil {
+		return nil, err
+	}
+	return dynamic.NewForConfig(config)
+}
+
+func (o *clusterOptions) gatherInfo(clientset *kubernetes.Clientset) ClusterInfo {
+	return o.collect(context.Background(), clientset)
+}
+
+// collect is gatherInfo, given up on when parent is done or -collect-timeout passes
+func (o *clusterOptions) collect(parent context.Context, clientset *kubernetes.Clientset) ClusterInfo {
+	ctx, cancel := collectContext(parent, o.collectTimeout)
+	defer cancel()
+	return gatherInfo(ctx, clientset, &o.nodeLabel)
+}
+
+func (o *clusterOptions) gatherNamespaceInfo(clientset *kubernetes.Clientset, nameSpace string) NamespaceInfo {
+	ctx, cancel := collectContext(context.Background(), o.collectTimeout)
+	defer cancel()
+	return gatherNamespaceInfo(ctx, clientset, &nameSpace)
+}
+
+// multiClusterOptions : Which kubeconfig contexts to collect from at once
+type multiClusterOptions struct {
+	contexts      string
+	kubeconfigDir string
+}
+
+func addMultiClusterFlags(flags *flag.FlagSet) *multiClusterOptions {
+	o := &multiClusterOptions{}
+	flags.StringVar(&o.contexts, "contexts", "", "Comma separated kubeconfig contexts or globs to collect from, \"all\" for every context")
+	flags.StringVar(&o.kubeconfigDir, "kubeconfig-dir", "", "Directory of kubeconfigs, collect from every context in them (or those matching -contexts)")
+	return o
+}
+
+func (o *multiClusterOptions) enabled() bool {
+	return o.contexts != "" || o.kubeconfigDir != ""
+}
+
+// run reports on every matching cluster and exits 1 when any of them failed
+func (o *multiClusterOptions) run(cluster *clusterOptions, jsonMode bool) {
+	targets, err := loadClusterTargets(cluster.kubeconfig, o.kubeconfigDir, o.contexts)
+	check(err)
+	ctx, cancel := collectContext(context.Background(), cluster.collectTimeout)
+	failed := runMultiCluster(ctx, targets, &cluster.nodeLabel, jsonMode)
+	cancel()
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// historyQueryOptions : How to read a trend out of the -history file
+type historyQueryOptions struct {
+	since  string
+	bucket string
+	agg    string
+}
+
+func addHistoryQueryFlags(flags *flag.FlagSet) *historyQueryOptions {
+	o := &historyQueryOptions{}
+	flags.StringVar(&o.since, "history-since", "30d", "How far back the history query looks")
+	flags.StringVar(&o.bucket, "history-bucket", "1d", "Bucket size for the history query, e.g. 1h, 1d, 1w")
+	flags.StringVar(&o.agg, "history-agg", "avg", "Aggregation for the history query buckets: min, max, avg or last")
+	return o
+}
+
+func (o *historyQueryOptions) run(historyFile, field string) {
+	since, err := parseRetention(o.since)
+	check(err)
+	bucket, err := parseRetention(o.bucket)
+	check(err)
+	records, err := readHistory(historyFile, time.Now().Add(-since))
+	check(err)
+	points, err := queryHistory(records, field, bucket, o.agg)
+	check(err)
+	for _, line := range historyHumanMode(points, field, o.agg) {
+		fmt.Println(line)
+	}
+}
+
+// forecastOptions : How much history the capacity forecast is fitted on
+type forecastOptions struct {
+	since     string
+	threshold float64
+}
+
+func addForecastFlags(flags *flag.FlagSet) *forecastOptions {
+	o := &forecastOptions{}
+	flags.StringVar(&o.since, "forecast-since", "30d", "How much of -history to fit the capacity forecast on")
+	flags.Float64Var(&o.threshold, "forecast-threshold", 0, "Share (0-1) of allocatable N-1 capacity left at which a resource counts as exhausted")
+	return o
+}
+
+// forecasts returns what to call for a fresh forecast, nothing without a history file
+func (o *forecastOptions) forecasts(historyFile string) func() []Forecast {
+	window, err := parseRetention(o.since)
+	check(err)
+	return func() []Forecast {
+		if historyFile == "" {
+			return nil
+		}
+		forecasts, err := loadForecasts(historyFile, window, o.threshold, time.Now())
+		if err != nil {
+			log.Warnf("Unable to forecast from %s, Error: %s", historyFile, err)
+		}
+		return forecasts
+	}
+}
+
+// daemonOptions : Schedule, history, alerting and notifications of daemon mode
+type daemonOptions struct {
+	historyRetention string
+	poolLabel        string
+	rulesFile        string
+	notifyConfig     string
+	notifyDryRun     bool
+	interval         time.Duration
+	jitter           time.Duration
+	align            bool
+	cronSpec         string
+	cycleTimeout     time.Duration
+	watch            bool
+	nodeEvents       bool
+	eventLabels      *stringList
+	listen           string
+	readyIntervals   *int
+}
+
+func addDaemonFlags(flags *flag.FlagSet) *daemonOptions {
+	o := &daemonOptions{}
+	flags.StringVar(&o.historyRetention, "history-retention", "30d", "Drop history records older than this, 0 keeps everything")
+	flags.StringVar(&o.poolLabel, "forecast-pool-label", "", "Node label grouping -history and the forecast by node pool, defaults to the usual cloud node pool labels")
+	flags.StringVar(&o.rulesFile, "rules", "", "Yaml or json file of alert rules evaluated every daemon cycle")
+	flags.StringVar(&o.notifyConfig, "notify-config", "", "Yaml or json file of http endpoints to POST alerts and summaries to in daemon mode")
+	flags.BoolVar(&o.notifyDryRun, "notify-dry-run", false, "Print what would be POSTed to -notify-config endpoints to stderr instead of sending it")
+	flags.DurationVar(&o.interval, "interval", 5*time.Minute, "Time between daemon cycles")
+	flags.DurationVar(&o.jitter, "jitter", 0, "Random delay up to this long added to every daemon cycle")
+	flags.BoolVar(&o.align, "align", false, "Start daemon cycles on wall clock multiples of -interval")
+	flags.StringVar(&o.cronSpec, "schedule", "", "Cron expression (minute hour day-of-month month day-of-week) for daemon cycles, overrides -interval")
+	flags.DurationVar(&o.cycleTimeout, "cycle-timeout", 0, "Give up on a daemon cycle, and its api calls, after this long. 0 waits forever")
+	flags.BoolVar(&o.watch, "watch", true, "In daemon mode, keep nodes, pods and quotas in a watch cache instead of listing them every cycle. The cache holds every pod of the cluster, even with -nodelabel")
+	flags.BoolVar(&o.nodeEvents, "node-events", false, "In daemon mode, also print one json event per selected node every cycle")
+	o.eventLabels = addEventLabelFlag(flags)
+	flags.StringVar(&o.listen, "listen", "", "In daemon mode, serve /healthz, /readyz, /metrics and the /v1 capacity api on this address, e.g. :8080")
+	o.readyIntervals = addReadyIntervalsFlag(flags)
+	return o
+}
+
+func addEventLabelFlag(flags *flag.FlagSet) *stringList {
+	labels := &stringList{}
+	flags.Var(labels, "event-label", "Node label to copy into node events, may be given more than once")
+	return labels
+}
+
+// daemonSettings : Everything a daemon is built from, parsed again on SIGHUP
+type daemonSettings struct {
+	cluster       *clusterOptions
+	daemon        *daemonOptions
+	forecast      *forecastOptions
+	elasticsearch *elasticsearchOptions
+	otlp          *otlpOptions
+	influx        *influxOptions
+	statsd        *statsdOptions
+	graphite      *graphiteOptions
+	leader        *leaderOptions
+	reports       *reportOptions
+	historyFile   string
+}
+
+func addDaemonSettingsFlags(flags *flag.FlagSet, historyUsage string) *daemonSettings {
+	s := &daemonSettings{cluster: addClusterFlags(flags)}
+	s.cluster.addNodeLabelFlag(flags)
+	s.cluster.addEventVersionFlag(flags)
+	s.daemon = addDaemonFlags(flags)
+	s.forecast = addForecastFlags(flags)
+	s.elasticsearch = addElasticsearchFlags(flags)
+	s.otlp = addOTLPFlags(flags)
+	s.influx = addInfluxFlags(flags)
+	s.statsd = addStatsdFlags(flags)
+	s.graphite = addGraphiteFlags(flags)
+	s.leader = addLeaderFlags(flags)
+	s.reports = addReportFlags(flags)
+	flags.StringVar(&s.historyFile, "history", "", historyUsage)
+	return s
+}
+
+// validate rejects flag combinations the daemon command cannot honour
+func (s *daemonSettings) validate(flags *flag.FlagSet) error {
+	err := conflicts(flags, [2]string{"schedule", "interval"}, [2]string{"schedule", "align"})
+	if err != nil {
+		return err
+	}
+	set := flagsSet(flags)
+	if set["notify-dry-run"] && s.daemon.notifyConfig == "" {
+		return fmt.Errorf("-notify-dry-run needs -notify-config")
+	}
+	if (set["history-retention"] || set["forecast-since"] || set["forecast-threshold"] || set["forecast-pool-label"]) && s.historyFile == "" {
+		return fmt.Errorf("-history-retention and -forecast-* need -history")
+	}
+	if s.daemon.jitter < 0 || s.daemon.cycleTimeout < 0 {
+		return fmt.Errorf("-jitter and -cycle-timeout cannot be negative")
+	}
+	if *s.daemon.readyIntervals < 1 {
+		return fmt.Errorf("-ready-intervals must be at least 1")
+	}
+	for _, sink := range []interface{ validate() error }{s.elasticsearch, s.otlp, s.influx, s.statsd, s.graphite, s.leader, s.reports} {
+		if err := sink.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// build makes a daemon from the settings, with -watch it collects from
+// watch. Given the daemon it replaces, pending and firing alerts of rules
+// that still exist are kept
+func (s *daemonSettings) build(clientset *kubernetes.Clientset, dynamicClient dynamic.Interface, watch *watchCache, previous *daemon) (*daemon, error) {
+	o, cluster := s.daemon, s.cluster
+	if cluster.collectTimeout == 0 {
+		cluster.collectTimeout = o.cycleTimeout
+	}
+	d := &daemon{
+		nodeLabel:    &cluster.nodeLabel,
+		poolLabel:    o.poolLabel,
+		forecasts:    s.forecast.forecasts(s.historyFile),
+		jitter:       o.jitter,
+		cycleTimeout: o.cycleTimeout,
+		nodeEvents:   o.nodeEvents,
+		eventLabels:  *o.eventLabels,
+		health:       &healthState{},
+		api:          &apiServer{},
+		collect: func(ctx context.Context) ClusterInfo {
+			return cluster.collect(ctx, clientset)
+		},
+	}
+	var err error
+	if o.cronSpec != "" {
+		d.sched, err = parseCronSchedule(o.cronSpec)
+	} else {
+		d.sched, err = newIntervalSchedule(o.interval, o.align)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if previous != nil {
+		d.health, d.api, d.leader = previous.health, previous.api, previous.leader
+	}
+	if s.historyFile != "" {
+		retention, err := parseRetention(o.historyRetention)
+		if err != nil {
+			return nil, err
+		}
+		d.history = newHistoryStore(s.historyFile, retention)
+	}
+	if o.rulesFile != "" {
+		rules, err := loadAlertRules(o.rulesFile)
+		if err != nil {
+			return nil, err
+		}
+		d.alerts = newAlertEngine(rules)
+		if previous != nil && previous.alerts != nil {
+			d.alerts.keepStates(previous.alerts)
+		}
+	}
+	if s.elasticsearch.url != "" {
+		d.elasticsearch = newBulkSink(*s.elasticsearch)
+	}
+	if s.otlp.endpoint != "" {
+		exporter, err := newOTLPExporter(*s.otlp)
+		if err != nil {
+			return nil, err
+		}
+		d.metricSinks = append(d.metricSinks, exporter)
+	}
+	if s.influx.output != "" {
+		d.metricSinks = append(d.metricSinks, newInfluxSink(*s.influx))
+	}
+	if s.statsd.address != "" {
+		d.metricSinks = append(d.metricSinks, &statsdSink{*s.statsd})
+	}
+	if s.graphite.address != "" {
+		d.metricSinks = append(d.metricSinks, &graphiteSink{*s.graphite})
+	}
+	if s.reports.enabled {
+		d.reports = &capacityReporter{client: dynamicClient, groupLabel: s.reports.groupLabel}
+	}
+	if o.notifyConfig != "" {
+		config, err := loadNotifyConfig(o.notifyConfig)
+		if err != nil {
+			return nil, err
+		}
+		// Stdout is the event stream, dry-run output goes beside the logs
+		d.notify, err = newNotifier(config, o.notifyDryRun, os.Stderr)
+		if err != nil {
+			return nil, err
+		}
+		if previous != nil && previous.notify != nil {
+			d.notify.lastSummary = previous.notify.lastSummary
+		}
+	}
+	// The cache watches the whole cluster, a reload keeps it whatever
+	// -nodelabel says. It starts with the first cycle that collects, which
+	// a standby never runs
+	if o.watch {
+		d.watch = watch
+		d.collect = func(ctx context.Context) ClusterInfo {
+			cache, err := watch.get()
+			check(err)
+			ctx, cancel := collectContext(ctx, cluster.collectTimeout)
+			defer cancel()
+			clusterInfo := cache.clusterInfo(cluster.nodeLabel)
+			addNodeMetrics(clusterInfo.NodeInfo, getNodeMetrics(ctx, clientset))
+			return clusterInfo
+		}
+	}
+	d.health.setDue(scheduleDue(d.sched, *o.readyIntervals))
+	return d, nil
+}
+
+// run builds the daemon and runs it until SIGTERM or SIGINT, failing before
+// the first cycle when -listen cannot be listened on. On SIGHUP reload
+// returns fresh settings, the clientset, kubeconfig, -listen and
+// -leader-elect-* stay as they are
+func (s *daemonSettings) run(clientset *kubernetes.Clientset, reload func() (*daemonSettings, error)) error {
+	dynamicClient, err := s.cluster.dynamicClient()
+	check(err)
+	// Stopped when this replica stops leading, a standby serves the
+	// leader's snapshot without watching anything
+	watch := newWatchCache(clientset)
+	d, err := s.build(clientset, dynamicClient, watch, nil)
+	check(err)
+	if s.daemon.listen != "" {
+		if err := serveDaemon(s.daemon.listen, d.health, d.api); err != nil {
+			return err
+		}
+	}
+	if s.leader.enabled {
+		d.leader, err = newLeaderElection(*s.leader, clientset, watch.stop)
+		check(err)
+		// Stopping releases the Lease, a standby takes over at once
+		defer d.leader.start()()
+	}
+	runDaemon(d, func(previous *daemon) (*daemon, error) {
+		next, err := reload()
+		if err != nil {
+			return nil, err
+		}
+		next.cluster.setUp()
+		return next.build(clientset, dynamicClient, watch, previous)
+	})
+	return nil
+}
+
+// printCluster is the one-shot cluster report, as json or for humans
+func printCluster(clusterInfo ClusterInfo, jsonMode bool, historyFile string, forecasts func() []Forecast) {
+	if jsonMode {
+		capCity := calculateCapcity(clusterInfo)
+		applyForecast(&capCity, forecasts(), clusterInfo.NodeSelector)
+		printCapcity(capCity)
+		return
+	}
+	humanMode(clusterInfo)
+	if historyFile != "" {
+		for _, line := range forecastHumanMode(forecasts()) {
+			fmt.Println(line)
+		}
+	}
+}
+
+// renderCluster is the one-shot cluster report as format. With a history
+// file it carries the forecast, and the html report its trends
+func renderCluster(out io.Writer, format string, clusterInfo ClusterInfo, historyFile string, forecasts func() []Forecast) error {
+	capCity := calculateCapcity(clusterInfo)
+	applyForecast(&capCity, forecasts(), clusterInfo.NodeSelector)
+	v := clusterView(clusterInfo, capCity)
+	if historyFile != "" && format == "html" {
+		records, err := readHistory(historyFile, time.Now().Add(-htmlTrendWindow))
+		if err != nil {
+			log.Warnf("Unable to read trends from %s, Error: %s", historyFile, err)
+		}
+		v.html.addTrends(records, clusterInfo.NodeSelector)
+	}
+	return render(out, format, v)
+}
+
+// checkConnection lists nodes once, enough to prove we can talk to the cluster
+func checkConnection(clientset *kubernetes.Clientset) error {
+	_, err := clientset.CoreV1().Nodes().List(metav1.ListOptions{})
+	return err
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// otlpGRPCPath is the MetricsService Export method, gRPC calls are POSTs to it
+const otlpGRPCPath = "/opentelemetry.proto.collector.metrics.v1.MetricsService/Export"
+
+// otlpOptions : Where the daemon exports its gauges with OTLP
+type otlpOptions struct {
+	endpoint           string
+	protocol           string
+	headers            *stringList
+	insecure           bool
+	caFile             string
+	certFile           string
+	keyFile            string
+	insecureSkipVerify bool
+	timeout            time.Duration
+}
+
+func addOTLPFlags(flags *flag.FlagSet) *otlpOptions {
+	o := &otlpOptions{headers: &stringList{}}
+	flags.StringVar(&o.endpoint, "otlp-endpoint", "", "In daemon mode, also export the capacity gauges to this OTLP collector, e.g. collector:4317 for grpc or http://collector:4318 for http/protobuf")
+	flags.StringVar(&o.protocol, "otlp-protocol", "grpc", "OTLP transport, grpc or http/protobuf")
+	flags.Var(o.headers, "otlp-header", "Header sent with every export, key=value, may be given more than once")
+	flags.BoolVar(&o.insecure, "otlp-insecure", false, "Talk plaintext to a grpc -otlp-endpoint given as host:port")
+	flags.StringVar(&o.caFile, "otlp-ca-file", "", "CA bundle to verify the OTLP collector with")
+	flags.StringVar(&o.certFile, "otlp-cert-file", "", "Client certificate for the OTLP collector, with -otlp-key-file")
+	flags.StringVar(&o.keyFile, "otlp-key-file", "", "Client key for the OTLP collector")
+	flags.BoolVar(&o.insecureSkipVerify, "otlp-insecure-skip-verify", false, "Do not verify the OTLP collector certificate")
+	flags.DurationVar(&o.timeout, "otlp-timeout", 10*time.Second, "Give up on an export after this long")
+	return o
+}
+
+func (o *otlpOptions) validate() error {
+	if o.endpoint == "" {
+		return nil
+	}
+	if o.protocol != "grpc" && o.protocol != "http/protobuf" {
+		return fmt.Errorf("unknown -otlp-protocol %s, use grpc or http/protobuf", o.protocol)
+	}
+	if (o.certFile == "") != (o.keyFile == "") {
+		return fmt.Errorf("-otlp-cert-file and -otlp-key-file go together")
+	}
+	for _, header := range *o.headers {
+		if !strings.Contains(header, "=") {
+			return fmt.Errorf("-otlp-header %q is not key=value", header)
+		}
+	}
+	_, err := o.url()
+	return err
+}
+
+// url is the endpoint with a scheme, and for http/protobuf the /v1/metrics path
+func (o *otlpOptions) url() (*url.URL, error) {
+	endpoint := o.endpoint
+	if !strings.Contains(endpoint, "://") {
+		scheme := "https"
+		if o.insecure {
+			scheme = "http"
+		}
+		endpoint = scheme + "://" + endpoint
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("-otlp-endpoint %q is not host:port or an http or https url", o.endpoint)
+	}
+	if o.protocol == "grpc" {
+		u.Path = otlpGRPCPath
+	} else if u.Path == "" || u.Path == "/" {
+		u.Path = "/v1/metrics"
+	}
+	return u, nil
+}
+
+func (o *otlpOptions) tlsConfig() (*tls.Config, error) {
+	config := &tls.Config{InsecureSkipVerify: o.insecureSkipVerify}
+	if o.caFile != "" {
+		pem, err := ioutil.ReadFile(o.caFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", o.caFile)
+		}
+	}
+	if o.certFile != "" {
+		cert, err := tls.LoadX509KeyPair(o.certFile, o.keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// otlpExporter : Exports every daemon cycle as OTLP gauges
+type otlpExporter struct {
+	url     string
+	grpc    bool
+	headers map[string]string
+	client  *http.Client
+}
+
+func newOTLPExporter(o otlpOptions) (*otlpExporter, error) {
+	u, err := o.url()
+	if err != nil {
+		return nil, err
+	}
+	config, err := o.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	e := &otlpExporter{url: u.String(), grpc: o.protocol == "grpc", headers: make(map[string]string)}
+	for _, header := range *o.headers {
+		parts := strings.SplitN(header, "=", 2)
+		e.headers[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	var transport http.RoundTripper = &http.Transport{TLSClientConfig: config, Proxy: http.ProxyFromEnvironment}
+	if e.grpc {
+		h2 := &http2.Transport{TLSClientConfig: config}
+		if u.Scheme == "http" {
+			// gRPC without TLS is HTTP/2 from the first byte
+			h2.AllowHTTP = true
+			h2.DialTLS = func(network, addr string, _ *tls.Config) (net.Conn, error) {
+				return net.Dial(network, addr)
+			}
+		}
+		transport = h2
+	}
+	e.client = &http.Client{Transport: transport, Timeout: o.timeout}
+	return e, nil
+}
+
+func (e *otlpExporter) String() string {
+	return e.url
+}
+
+// write sends the cluster gauges, then those of every selected node and
+// every namespace, each under its own resource
+func (e *otlpExporter) write(groups []metricGroup, now time.Time) error {
+	return e.send(otlpRequest(groups, now))
+}
+
+func (e *otlpExporter) send(message []byte) error {
+	body := message
+	contentType := "application/x-protobuf"
+	if e.grpc {
+		// Uncompressed, then the length of the message
+		body = make([]byte, 5, 5+len(message))
+		binary.BigEndian.PutUint32(body[1:], uint32(len(message)))
+		body = append(body, message...)
+		contentType = "application/grpc"
+	}
+	req, err := http.NewRequest(http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("User-Agent", "k8sCapcity")
+	if e.grpc {
+		req.Header.Set("TE", "trailers")
+	}
+	for key, value := range e.headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	ioutil.ReadAll(resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("%s returned %s", e.url, resp.Status)
+	}
+	if e.grpc {
+		// A call that fails straight away answers with headers only
+		status, message := resp.Trailer.Get("Grpc-Status"), resp.Trailer.Get("Grpc-Message")
+		if status == "" {
+			status, message = resp.Header.Get("Grpc-Status"), resp.Header.Get("Grpc-Message")
+		}
+		if status != "0" {
+			return fmt.Errorf("%s returned grpc status %s %s", e.url, status, message)
+		}
+	}
+	return nil
+}
+
+func otlpUnit(name string) string {
+	switch {
+	case strings.Contains(name, "bytes"):
+		return "By"
+	case strings.Contains(name, "millicores"):
+		return "m{cpu}"
+	case strings.Contains(name, "cores"):
+		return "{cpu}"
+	case strings.Contains(name, "pods"):
+		return "{pod}"
+	}
+	return "1"
+}
+
+// otlpRequest is an ExportMetricsServiceRequest with a resource per group
+func otlpRequest(groups []metricGroup, now time.Time) []byte {
+	var request protoMessage
+	for _, group := range groups {
+		request.message(1, otlpResourceMetrics(group, now))
+	}
+	return request.Bytes()
+}
+
+func otlpResourceMetrics(group metricGroup, now time.Time) protoMessage {
+	var resource, scope, scopeMetrics, resourceMetrics protoMessage
+	for _, attribute := range group.attributes {
+		resource.message(1, otlpKeyValue(attribute))
+	}
+	scope.string(1, "k8sCapcity")
+	scope.string(2, version)
+	scopeMetrics.message(1, scope)
+	for _, gauge := range group.gauges {
+		var point, data, metric protoMessage
+		point.fixed64(3, uint64(now.UnixNano()))
+		point.fixed64(4, math.Float64bits(gauge.value))
+		data.message(1, point)
+		metric.string(1, group.prefix+gauge.name)
+		metric.string(3, otlpUnit(gauge.name))
+		metric.message(5, data)
+		scopeMetrics.message(2, metric)
+	}
+	resourceMetrics.message(1, resource)
+	resourceMetrics.message(2, scopeMetrics)
+	return resourceMetrics
+}
+
+// otlpKeyValue is an attribute as an OTLP KeyValue with a string value
+func otlpKeyValue(a metricAttribute) protoMessage {
+	var keyValue, anyValue protoMessage
+	anyValue.string(1, a.value)
+	keyValue.string(1, a.key)
+	keyValue.message(2, anyValue)
+	return keyValue
+}
+
+// protoMessage : Protobuf wire format, just what the OTLP messages need
+type protoMessage struct {
+	bytes.Buffer
+}
+
+func (m *protoMessage) varint(v uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	m.Write(buf[:binary.PutUvarint(buf[:], v)])
+}
+
+func (m *protoMessage) tag(field, wireType int) {
+	m.varint(uint64(field)<<3 | uint64(wireType))
+}
+
+func (m *protoMessage) bytesField(field int, data []byte) {
+	m.tag(field, 2)
+	m.varint(uint64(len(data)))
+	m.Write(data)
+}
+
+func (m *protoMessage) string(field int, s string) {
+	m.bytesField(field, []byte(s))
+}
+
+func (m *protoMessage) message(field int, child protoMessage) {
+	m.bytesField(field, child.Bytes())
+}
+
+func (m *protoMessage) fixed64(field int, v uint64) {
+	m.tag(field, 1)
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], v)
+	m.Write(buf[:])
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// protoFields decodes one level of a protobuf message, length delimited
+// fields as their bytes and fixed64 fields as their 8 bytes
+func protoFields(t *testing.T, data []byte) map[int][][]byte {
+	fields := make(map[int][][]byte)
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		data = data[n:]
+		switch key & 7 {
+		case 1:
+			fields[int(key>>3)] = append(fields[int(key>>3)], data[:8])
+			data = data[8:]
+		case 2:
+			length, n := binary.Uvarint(data)
+			data = data[n:]
+			fields[int(key>>3)] = append(fields[int(key>>3)], data[:length])
+			data = data[length:]
+		default:
+			t.Fatalf("Unexpected wire type %d", key&7)
+		}
+	}
+	return fields
+}
+
+// otlpResource is one decoded ResourceMetrics, attributes and gauge values
+type otlpResource struct {
+	attributes map[string]string
+	gauges     map[string]float64
+	units      map[string]string
+}
+
+func decodeOTLPRequest(t *testing.T, data []byte) (resources []otlpResource) {
+	for _, rm := range protoFields(t, data)[1] {
+		r := otlpResource{attributes: map[string]string{}, gauges: map[string]float64{}, units: map[string]string{}}
+		fields := protoFields(t, rm)
+		for _, resource := range fields[1] {
+			for _, kv := range protoFields(t, resource)[1] {
+				kvFields := protoFields(t, kv)
+				r.attributes[string(kvFields[1][0])] = string(protoFields(t, kvFields[2][0])[1][0])
+			}
+		}
+		for _, sm := range fields[2] {
+			smFields := protoFields(t, sm)
+			compareString(string(protoFields(t, smFields[1][0])[1][0]), "k8sCapcity", t)
+			for _, metric := range smFields[2] {
+				m := protoFields(t, metric)
+				point := protoFields(t, protoFields(t, m[5][0])[1][0])
+				name := string(m[1][0])
+				r.gauges[name] = math.Float64frombits(binary.LittleEndian.Uint64(point[4][0]))
+				r.units[name] = string(m[3][0])
+			}
+		}
+		resources = append(resources, r)
+	}
+	return resources
+}
+
+func metricsTestCluster() (ClusterInfo, Capcity) {
+	clusterInfo := schemaTestCluster()
+	node := clusterInfo.NodeInfo["node-a"]
+	node.Labels = map[string]string{"topology.kubernetes.io/zone": "us-east-1a", "cloud.google.com/gke-nodepool": "compute"}
+	clusterInfo.NodeInfo["node-a"] = node
+	clusterInfo.NamespaceTotals = map[string]NamespaceTotals{"default": {CPURequestsMilliCores: 1500, MemoryRequests: 1024, Pods: 3}}
+	capCity := calculateCapcity(clusterInfo)
+	capCity.OrchestratorClusterName = "prod"
+	return clusterInfo, capCity
+}
+
+func TestOTLPRequest(t *testing.T) {
+	clusterInfo, capCity := metricsTestCluster()
+	resources := decodeOTLPRequest(t, otlpRequest(capacityMetrics(clusterInfo, capCity), time.Unix(1583452800, 0)))
+	if len(resources) != 4 {
+		t.Fatalf("Expected a cluster, two node and one namespace resource, got %d", len(resources))
+	}
+	cluster := resources[0]
+	compareString(cluster.attributes["k8s.cluster.name"], "prod", t)
+	if cluster.gauges["k8s_quota.allocatable.cpu_cores.total"] != 16 || cluster.gauges["k8s_quota.utilization_factor.pods.total"] == 0 {
+		t.Errorf("Unexpected cluster gauges %v", cluster.gauges)
+	}
+	compareString(cluster.units["k8s_quota.allocatable.memory_bytes.total"], "By", t)
+	for name := range cluster.gauges {
+		if strings.Contains(name, ".nodes.") {
+			t.Errorf("Per node factors belong to the node resources, got %s", name)
+		}
+	}
+
+	var nodeNames []string
+	for _, r := range resources[1:3] {
+		nodeNames = append(nodeNames, r.attributes["k8s.node.name"])
+	}
+	sort.Strings(nodeNames)
+	compareString(strings.Join(nodeNames, ","), "node-a,node-b", t)
+	nodeA := resources[1]
+	if nodeA.attributes["k8s.node.name"] != "node-a" {
+		nodeA = resources[2]
+	}
+	compareString(nodeA.attributes["cloud.availability_zone"], "us-east-1a", t)
+	compareString(nodeA.attributes["k8s_quota.node_pool"], "compute", t)
+	compareString(nodeA.attributes["k8s.cluster.name"], "prod", t)
+	if nodeA.gauges["k8s_quota.node.container_resource.pods"] != 20 {
+		t.Errorf("Unexpected node gauges %v", nodeA.gauges)
+	}
+
+	namespace := resources[3]
+	compareString(namespace.attributes["k8s.namespace.name"], "default", t)
+	if namespace.gauges["k8s_quota.namespace.cpu_requests.millicores"] != 1500 || namespace.gauges["k8s_quota.namespace.pods"] != 3 {
+		t.Errorf("Unexpected namespace gauges %v", namespace.gauges)
+	}
+}
+
+// otlpGolden is the ExportMetricsServiceRequest otlpRequest must encode
+// otlpGoldenGroups to, marshalled by go.opentelemetry.io/proto/otlp v1.3.1
+// with the official protos
+var otlpGolden = "" +
+	"0ac2010a1c0a1a0a106b38732e636c75737465722e6e616d6512060a0470726f6412a1010a110a0a6b38734361706369" +
+	"7479120364657612440a256b38735f71756f74612e616c6c6f63617461626c652e6370755f636f7265732e746f74616c" +
+	"1a057b6370757d2a140a12190000999eee8df91521000000000000304012460a276b38735f71756f74612e7574696c69" +
+	"7a6174696f6e5f666163746f722e706f64732e746f74616c1a057b706f647d2a140a12190000999eee8df91521000000" +
+	"000000d03f0ad5010a3d0a1a0a106b38732e636c75737465722e6e616d6512060a0470726f640a1f0a126b38732e6e61" +
+	"6d6573706163652e6e616d6512090a0764656661756c741293010a110a0a6b387343617063697479120364657612450a" +
+	"296b38735f71756f74612e6e616d6573706163652e6d656d6f72795f72657175657374732e62797465731a0242792a14" +
+	"0a12190000999eee8df91521000000000000d04112370a186b38735f71756f74612e6e616d6573706163652e706f6473" +
+	"1a057b706f647d2a140a12190000999eee8df915210000000000000000"
+
+func otlpGoldenGroups() []metricGroup {
+	return []metricGroup{
+		{prefix: "k8s_quota.", attributes: []metricAttribute{{"k8s.cluster.name", "prod"}},
+			gauges: []metricGauge{{"allocatable.cpu_cores.total", 16}, {"utilization_factor.pods.total", 0.25}}},
+		{prefix: "k8s_quota.namespace.", attributes: []metricAttribute{{"k8s.cluster.name", "prod"}, {"k8s.namespace.name", "default"}},
+			gauges: []metricGauge{{"memory_requests.bytes", 1 << 30}, {"pods", 0}}},
+	}
+}
+
+func TestOTLPRequestGolden(t *testing.T) {
+	actual := hex.EncodeToString(otlpRequest(otlpGoldenGroups(), time.Unix(1583452800, 0)))
+	compareString(actual, otlpGolden, t)
+}
+
+// otlpStandIn records what a collector receives over HTTP/2 without TLS
+func otlpStandIn(t *testing.T, grpcStatus string) (*httptest.Server, *http.Request, *[]byte) {
+	var received http.Request
+	var body []byte
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = *r
+		body, _ = ioutil.ReadAll(r.Body)
+		if r.Header.Get("Content-Type") == "application/grpc" {
+			w.Header().Set("Content-Type", "application/grpc")
+			w.Header().Set("Trailer", "Grpc-Status")
+			w.WriteHeader(http.StatusOK)
+			w.Header().Set("Grpc-Status", grpcStatus)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-protobuf")
+	})
+	return httptest.NewServer(h2c.NewHandler(handler, &http2.Server{})), &received, &body
+}
+
+func TestOTLPExporterGRPC(t *testing.T) {
+	server, received, body := otlpStandIn(t, "0")
+	defer server.Close()
+	headers := &stringList{"x-scope-orgid=capacity"}
+	e, err := newOTLPExporter(otlpOptions{endpoint: strings.TrimPrefix(server.URL, "http://"), protocol: "grpc", insecure: true, headers: headers, timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.send([]byte{0x0a, 0x00}); err != nil {
+		t.Fatal(err)
+	}
+	compareString(received.URL.Path, otlpGRPCPath, t)
+	compareString(received.Header.Get("X-Scope-Orgid"), "capacity", t)
+	compareString(string(*body), "\x00\x00\x00\x00\x02\x0a\x00", t)
+
+	failing, _, _ := otlpStandIn(t, "14")
+	defer failing.Close()
+	e, _ = newOTLPExporter(otlpOptions{endpoint: failing.URL, protocol: "grpc", headers: &stringList{}, timeout: time.Second})
+	if err := e.send([]byte{}); err == nil || !strings.Contains(err.Error(), "grpc status 14") {
+		t.Errorf("Expected the grpc status to fail the export, got %v", err)
+	}
+}
+
+func TestOTLPExporterHTTP(t *testing.T) {
+	server, received, body := otlpStandIn(t, "")
+	defer server.Close()
+	e, err := newOTLPExporter(otlpOptions{endpoint: server.URL, protocol: "http/protobuf", headers: &stringList{}, timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.send([]byte{0x0a, 0x00}); err != nil {
+		t.Fatal(err)
+	}
+	compareString(received.URL.Path, "/v1/metrics", t)
+	compareString(received.Header.Get("Content-Type"), "application/x-protobuf", t)
+	compareString(string(*body), "\x0a\x00", t)
+}
+
+func TestOTLPOptionsValidate(t *testing.T) {
+	for _, test := range []struct {
+		options otlpOptions
+		err     string
+	}{
+		{otlpOptions{}, ""},
+		{otlpOptions{endpoint: "collector:4317", protocol: "grpc"}, ""},
+		{otlpOptions{endpoint: "https://collector:4318/otlp/v1/metrics", protocol: "http/protobuf"}, ""},
+		{otlpOptions{endpoint: "collector:4317", protocol: "thrift"}, "unknown -otlp-protocol thrift, use grpc or http/protobuf"},
+		{otlpOptions{endpoint: "collector:4317", protocol: "grpc", certFile: "client.crt"}, "-otlp-cert-file and -otlp-key-file go together"},
+		{otlpOptions{endpoint: "collector:4317", protocol: "grpc", headers: &stringList{"api-key"}}, `-otlp-header "api-key" is not key=value`},
+		{otlpOptions{endpoint: "ftp://collector", protocol: "grpc"}, `-otlp-endpoint "ftp://collector" is not host:port or an http or https url`},
+	} {
+		if test.options.headers == nil {
+			test.options.headers = &stringList{}
+		}
+		err := test.options.validate()
+		actual := ""
+		if err != nil {
+			actual = err.Error()
+		}
+		compareString(actual, test.err, t)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/pflag"
+	"k8s.io/client-go/kubernetes"
+)
+
+// pluginName is the binary name kubectl looks for to run `kubectl capacity`
+const pluginName = "kubectl-capacity"
+
+// isPlugin reports whether we were started as the kubectl plugin
+func isPlugin(argv0 string) bool {
+	name := strings.TrimSuffix(filepath.Base(argv0), ".exe")
+	return name == pluginName || strings.Replace(name, "_", "-", -1) == pluginName
+}
+
+// pluginOptions : What `kubectl capacity` was asked for
+type pluginOptions struct {
+	// namespaced is -n given at all, -n "" is the namespace of the context
+	namespaced bool
+	selector   string
+	output     string
+}
+
+// newTabWriter lines tables up the way kubectl does
+func newTabWriter(out io.Writer) *tabwriter.Writer {
+	return tabwriter.NewWriter(out, 6, 4, 3, ' ', 0)
+}
+
+// parsePluginFlags takes kubectl's flags as far as addKubeconfigFlags has
+// them. --server, --token, --insecure-skip-tls-verify and the other
+// connection flags of kubectl are not among them, the kubeconfig has to
+// carry those
+func parsePluginFlags(args []string) (*pluginOptions, *kubeconfigFlags, error) {
+	options := &pluginOptions{}
+	goFlags := flag.NewFlagSet(pluginName, flag.ContinueOnError)
+	kubeconfig := addKubeconfigFlags(goFlags)
+	flags := pflag.NewFlagSet(pluginName, pflag.ContinueOnError)
+	flags.StringVarP(&kubeconfig.namespace, "namespace", "n", "", "Show the pods and containers of this namespace instead of the nodes, \"\" for the namespace of the context")
+	flags.StringVarP(&options.selector, "selector", "l", "", "Label selector for the nodes, e.g. pool=a or 'pool in (a,b),!spot', if blank every node")
+	flags.StringVarP(&options.output, "output", "o", "", "Output format: "+strings.Join(outputFormats(), ", "))
+	flags.AddGoFlagSet(goFlags)
+	err := flags.Parse(args)
+	if err != nil {
+		return nil, nil, err
+	}
+	options.namespaced = flags.Changed("namespace")
+	if options.namespaced && flags.Changed("selector") {
+		return nil, nil, fmt.Errorf("-l selects nodes, the namespace view of -n takes no selector")
+	}
+	err = checkOutput(options.output)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := parseNodeSelector(options.selector); err != nil {
+		return nil, nil, err
+	}
+	return options, kubeconfig, nil
+}
+
+// runPlugin is `kubectl capacity [-n namespace] [-l selector] [-o format]`
+func runPlugin(args []string, out io.Writer) error {
+	options, kubeconfig, err := parsePluginFlags(args)
+	if err != nil {
+		return err
+	}
+	config, err := kubeconfig.restConfig()
+	if err != nil {
+		return err
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := collectContext(context.Background(), 0)
+	defer cancel()
+
+	if options.namespaced {
+		namespace, err := kubeconfig.defaultNamespace()
+		if err != nil {
+			return err
+		}
+		nsInfo := gatherNamespaceInfo(ctx, clientset, &namespace)
+		return render(out, options.output, namespaceView(nsInfo))
+	}
+	clusterInfo := gatherInfo(ctx, clientset, &options.selector)
+	return render(out, options.output, clusterView(clusterInfo, calculateCapcity(clusterInfo)))
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestIsPlugin(t *testing.T) {
+	for argv0, expected := range map[string]bool{
+		"/usr/local/bin/kubectl-capacity": true,
+		"kubectl_capacity":                true,
+		"kubectl-capacity.exe":            true,
+		"./k8sCapcity":                    false,
+	} {
+		if isPlugin(argv0) != expected {
+			t.Errorf("%s: expected %t", argv0, expected)
+		}
+	}
+}
+
+func TestParsePluginFlags(t *testing.T) {
+	options, kubeconfig, err := parsePluginFlags([]string{"-n", "kube-system", "-o", "wide", "--context", "prod", "--as-group", "ops", "--as-group", "sre"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareString(kubeconfig.namespace, "kube-system", t)
+	if !options.namespaced {
+		t.Errorf("Expected -n to ask for the namespace view")
+	}
+	compareString(options.output, "wide", t)
+	compareString(kubeconfig.context, "prod", t)
+	compareString(strings.Join(kubeconfig.asGroups, ","), "ops,sre", t)
+
+	// -n "" is the namespace of the context, no -n the nodes
+	options, kubeconfig, err = parsePluginFlags([]string{"-n", ""})
+	if err != nil || !options.namespaced || kubeconfig.namespace != "" {
+		t.Errorf("Expected -n \"\" to ask for the context's namespace, got %+v %v", options, err)
+	}
+	options, _, err = parsePluginFlags([]string{"-l", "pool=a"})
+	if err != nil || options.namespaced {
+		t.Errorf("Expected the nodes without -n, got %+v %v", options, err)
+	}
+	compareString(options.selector, "pool=a", t)
+
+	_, _, err = parsePluginFlags([]string{"-n", "kube-system", "-l", "pool=a"})
+	if err == nil {
+		t.Errorf("Expected -l to be refused with -n")
+	}
+	// Connection flags of kubectl the plugin does not take
+	for _, flag := range []string{"--server=https://c.example.com", "--token=abc", "--insecure-skip-tls-verify"} {
+		if _, _, err := parsePluginFlags([]string{flag}); err == nil || !strings.Contains(err.Error(), "unknown flag") {
+			t.Errorf("%s: expected an unknown flag, got %v", flag, err)
+		}
+	}
+
+	_, _, err = parsePluginFlags([]string{"-o", "xml"})
+	if err == nil {
+		t.Errorf("Expected an error for -o xml")
+	}
+	_, _, err = parsePluginFlags([]string{"-l", "pool in (a"})
+	if err == nil {
+		t.Errorf("Expected an error for a selector that does not parse")
+	}
+}
+
+func TestNodeTable(t *testing.T) {
+	clusterInfo := ClusterInfo{NodeInfo: map[string]NodeInfo{
+		"node-b": {PrintOutput: true, AllocatableCPU: resource.MustParse("4"), AllocatableMemory: resource.MustParse("16Gi"),
+			AllocatablePods: resource.MustParse("110"), UsedCPURequests: resource.MustParse("1"), UsedMemoryRequests: resource.MustParse("4Gi"), UsedPods: 11},
+		"node-a": {PrintOutput: true, AllocatableCPU: resource.MustParse("2"), AllocatableMemory: resource.MustParse("8Gi"),
+			AllocatablePods: resource.MustParse("110")},
+		"node-c": {PrintOutput: false},
+	}}
+	var out bytes.Buffer
+	nodeTable(&out, clusterInfo, false)
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("Expected a header and two nodes, got %q", out.String())
+	}
+	compareString(lines[0], "NAME     CPU REQUESTS   CPU%   MEMORY REQUESTS   MEMORY%   PODS     PODS%", t)
+	compareString(lines[1], "node-a   0m/2000m       0%     0.0Gi/8.0Gi       0%        0/110    0%", t)
+	compareString(lines[2], "node-b   1000m/4000m    25%    4.0Gi/16.0Gi      25%       11/110   10%", t)
+
+	out.Reset()
+	nodeTable(&out, clusterInfo, true)
+	if !strings.Contains(out.String(), "PODS AVAILABLE") || !strings.Contains(out.String(), "3000m") {
+		t.Errorf("Expected the wide columns, got %q", out.String())
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// table : Header and rows of one part of a view. Wide columns only show in -o wide
+// and the export formats
+type table struct {
+	columns []string
+	wide    []bool
+	rows    [][]string
+}
+
+func (t *table) addColumns(wide bool, names ...string) {
+	for _, name := range names {
+		t.columns = append(t.columns, name)
+		t.wide = append(t.wide, wide)
+	}
+}
+
+func (t *table) addRow(cells ...string) {
+	t.rows = append(t.rows, cells)
+}
+
+// visible drops the wide columns unless wide is set
+func (t table) visible(wide bool) (columns []string, rows [][]string) {
+	keep := func(cells []string) (kept []string) {
+		for i, cell := range cells {
+			if wide || !t.wide[i] {
+				kept = append(kept, cell)
+			}
+		}
+		return kept
+	}
+	rows = make([][]string, 0, len(t.rows))
+	for _, row := range t.rows {
+		rows = append(rows, keep(row))
+	}
+	return keep(t.columns), rows
+}
+
+// view : What one command reports, as tables and as the object json and yaml print
+type view struct {
+	tables []table
+	object interface{}
+	// html is the full report, views without one print their tables as html
+	html *htmlReport
+	// data is what go templates and jsonpath see, object when not set
+	data interface{}
+}
+
+// renderer : Prints a view in one output format
+type renderer func(out io.Writer, v view) error
+
+var renderers = map[string]renderer{
+	"table":    alignedRenderer(false),
+	"wide":     alignedRenderer(true),
+	"json":     renderJSON,
+	"yaml":     renderYAML,
+	"csv":      delimitedRenderer(','),
+	"tsv":      delimitedRenderer('\t'),
+	"markdown": renderMarkdown,
+	"html":     renderHTML,
+}
+
+func outputFormats() []string {
+	formats := make([]string, 0, len(renderers)+len(argumentRenderers))
+	for format := range renderers {
+		formats = append(formats, format)
+	}
+	for format := range argumentRenderers {
+		formats = append(formats, format+"=...")
+	}
+	sort.Strings(formats)
+	return formats
+}
+
+func addOutputFlag(flags *flag.FlagSet) *string {
+	return flags.String("o", "", "Output format: "+strings.Join(outputFormats(), ", "))
+}
+
+// newRenderer looks format up, -o name or -o name=argument. A blank format is the table
+func newRenderer(format string) (renderer, error) {
+	if format == "" {
+		format = "table"
+	}
+	parts := strings.SplitN(format, "=", 2)
+	if r, ok := renderers[format]; ok {
+		return r, nil
+	}
+	if newArgumentRenderer, ok := argumentRenderers[parts[0]]; ok {
+		if len(parts) != 2 || parts[1] == "" {
+			return nil, fmt.Errorf("output format %s needs an argument, e.g. -o %s=...", parts[0], parts[0])
+		}
+		return newArgumentRenderer(parts[1])
+	}
+	return nil, fmt.Errorf("unable to match a printer suitable for the output format %q, allowed formats are: %s", format, strings.Join(outputFormats(), ","))
+}
+
+func checkOutput(format string) error {
+	_, err := newRenderer(format)
+	return err
+}
+
+// render prints v as format
+func render(out io.Writer, format string, v view) error {
+	r, err := newRenderer(format)
+	if err != nil {
+		return err
+	}
+	return r(out, v)
+}
+
+// writeAligned lines up the columns the way kubectl does
+func writeAligned(out io.Writer, t table, wide bool) {
+	w := newTabWriter(out)
+	columns, rows := t.visible(wide)
+	fmt.Fprintln(w, strings.Join(columns, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	w.Flush()
+}
+
+func alignedRenderer(wide bool) renderer {
+	return func(out io.Writer, v view) error {
+		for i, t := range v.tables {
+			if i > 0 {
+				fmt.Fprintln(out)
+			}
+			writeAligned(out, t, wide)
+		}
+		return nil
+	}
+}
+
+func renderJSON(out io.Writer, v view) error {
+	result, err := json.MarshalIndent(v.object, "", "    ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(out, string(result))
+	return nil
+}
+
+func renderYAML(out io.Writer, v view) error {
+	result, err := yaml.Marshal(v.object)
+	if err != nil {
+		return err
+	}
+	fmt.Fprint(out, string(result))
+	return nil
+}
+
+// delimitedRenderer writes every column, tables separated by a blank line
+func delimitedRenderer(comma rune) renderer {
+	return func(out io.Writer, v view) error {
+		for i, t := range v.tables {
+			if i > 0 {
+				fmt.Fprintln(out)
+			}
+			w := csv.NewWriter(out)
+			w.Comma = comma
+			columns, rows := t.visible(true)
+			w.Write(columns)
+			w.WriteAll(rows)
+			if err := w.Error(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// renderMarkdown writes GitHub flavoured markdown tables with every column
+func renderMarkdown(out io.Writer, v view) error {
+	escape := strings.NewReplacer("|", "\\|", "\n", " ")
+	line := func(cells []string) {
+		escaped := make([]string, len(cells))
+		for i, cell := range cells {
+			escaped[i] = escape.Replace(cell)
+		}
+		fmt.Fprintf(out, "| %s |\n", strings.Join(escaped, " | "))
+	}
+	for i, t := range v.tables {
+		if i > 0 {
+			fmt.Fprintln(out)
+		}
+		columns, rows := t.visible(true)
+		line(columns)
+		separator := make([]string, len(columns))
+		for i := range separator {
+			separator[i] = "---"
+		}
+		line(separator)
+		for _, row := range rows {
+			line(row)
+		}
+	}
+	return nil
+}
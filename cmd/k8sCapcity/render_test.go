@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func testView() view {
+	var t table
+	t.addColumns(false, "NAME", "PODS")
+	t.addColumns(true, "NOTE")
+	t.addRow("node-a", "3", "a|b")
+	t.addRow("node-b", "5", "")
+	return view{tables: []table{t, t}, object: Capcity{ECS: ECS{Timestamp: "2020-03-06T00:00:00Z"}, EventKind: "metric", ClusterName: "prod"}}
+}
+
+func TestRender(t *testing.T) {
+	for format, expected := range map[string]string{
+		"":      "NAME     PODS\nnode-a   3\nnode-b   5\n\nNAME     PODS\nnode-a   3\nnode-b   5\n",
+		"wide":  "NAME     PODS   NOTE\nnode-a   3      a|b\nnode-b   5      \n\nNAME     PODS   NOTE\nnode-a   3      a|b\nnode-b   5      \n",
+		"csv":   "NAME,PODS,NOTE\nnode-a,3,a|b\nnode-b,5,\n\nNAME,PODS,NOTE\nnode-a,3,a|b\nnode-b,5,\n",
+		"tsv":   "NAME\tPODS\tNOTE\nnode-a\t3\ta|b\nnode-b\t5\t\n\nNAME\tPODS\tNOTE\nnode-a\t3\ta|b\nnode-b\t5\t\n",
+		"yaml":  "'@timestamp': \"2020-03-06T00:00:00Z\"\n",
+		"json":  "{\n    \"@timestamp\": \"2020-03-06T00:00:00Z\",\n",
+		"table": "NAME     PODS\nnode-a   3\n",
+	} {
+		var out bytes.Buffer
+		err := render(&out, format, testView())
+		if err != nil {
+			t.Fatalf("%s: %s", format, err)
+		}
+		if !strings.HasPrefix(out.String(), expected) {
+			t.Errorf("%s: expected %q, got %q", format, expected, out.String())
+		}
+	}
+}
+
+func TestRenderYAMLObject(t *testing.T) {
+	var out bytes.Buffer
+	err := render(&out, "yaml", testView())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "k8s_quota.cluster_name: prod") {
+		t.Errorf("Expected yaml, got %q", out.String())
+	}
+}
+
+func TestRenderMarkdown(t *testing.T) {
+	var out bytes.Buffer
+	err := render(&out, "markdown", testView())
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(out.String(), "\n")
+	compareString(lines[0], "| NAME | PODS | NOTE |", t)
+	compareString(lines[1], "| --- | --- | --- |", t)
+	compareString(lines[2], `| node-a | 3 | a\|b |`, t)
+	compareString(lines[4], "", t)
+}
+
+func TestRenderUnknownFormat(t *testing.T) {
+	err := render(&bytes.Buffer{}, "xml", testView())
+	if err == nil || !strings.Contains(err.Error(), "csv,go-template-file=...,go-template=...,html,json,jsonpath-file=...,jsonpath=...,markdown,table,tsv,wide,yaml") {
+		t.Errorf("Expected the allowed formats in the error, got %v", err)
+	}
+}
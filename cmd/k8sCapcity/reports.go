@@ -0,0 +1,338 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	resource "k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+// reportAPIVersion is the group and version of the capacity report custom
+// resources, see deployments/kubernetes/capacityReportCRDs.yaml
+const reportAPIVersion = "k8scapcity.soh.re/v1alpha1"
+
+// namespaceReportName is the name of the NamespaceCapacityReport in every namespace
+const namespaceReportName = "capacity"
+
+// reportManagedBy labels the reports the daemon owns, those it no longer
+// writes are deleted
+const reportManagedBy = "app.kubernetes.io/managed-by=k8sCapcity"
+
+var (
+	clusterReportResource   = schema.GroupVersionResource{Group: "k8scapcity.soh.re", Version: "v1alpha1", Resource: "clustercapacityreports"}
+	namespaceReportResource = schema.GroupVersionResource{Group: "k8scapcity.soh.re", Version: "v1alpha1", Resource: "namespacecapacityreports"}
+)
+
+// reportNameInvalid is what a node group name cannot keep in an object name
+var reportNameInvalid = regexp.MustCompile(`[^a-z0-9.-]+`)
+
+// reportOptions : Whether the daemon keeps capacity report custom resources up to date
+type reportOptions struct {
+	enabled    bool
+	groupLabel string
+}
+
+func addReportFlags(flags *flag.FlagSet) *reportOptions {
+	o := &reportOptions{}
+	flags.BoolVar(&o.enabled, "capacity-reports", false, "In daemon mode, also write a ClusterCapacityReport per node group and a NamespaceCapacityReport per namespace every cycle")
+	flags.StringVar(&o.groupLabel, "capacity-report-group-label", "", "Node label that groups the selected nodes into ClusterCapacityReports, by default the usual node pool labels")
+	return o
+}
+
+func (o *reportOptions) validate() error {
+	if o.groupLabel != "" && !o.enabled {
+		return fmt.Errorf("-capacity-report-group-label needs -capacity-reports")
+	}
+	return nil
+}
+
+// ReportResources : Cpu, memory and pods in a capacity report
+type ReportResources struct {
+	CPU    string `json:"cpu"`
+	Memory string `json:"memory"`
+	Pods   int64  `json:"pods"`
+}
+
+// ReportLimits : Cpu and memory limits in a NamespaceCapacityReport
+type ReportLimits struct {
+	CPU    string `json:"cpu"`
+	Memory string `json:"memory"`
+}
+
+// ReportCondition : One condition of a capacity report, as in a pod's status
+type ReportCondition struct {
+	Type               string      `json:"type"`
+	Status             string      `json:"status"`
+	Reason             string      `json:"reason"`
+	Message            string      `json:"message"`
+	LastTransitionTime metav1.Time `json:"lastTransitionTime"`
+}
+
+// ClusterCapacityReportStatus : The capacity of one node group
+type ClusterCapacityReportStatus struct {
+	ClusterName        string             `json:"clusterName,omitempty"`
+	NodeGroup          string             `json:"nodeGroup"`
+	Nodes              int64              `json:"nodes"`
+	Allocatable        ReportResources    `json:"allocatable"`
+	Requested          ReportResources    `json:"requested"`
+	Available          ReportResources    `json:"available"`
+	AvailableNminusOne ReportResources    `json:"availableNminusOne"`
+	Utilization        map[string]float64 `json:"utilization"`
+	LastUpdated        metav1.Time        `json:"lastUpdated"`
+	Conditions         []ReportCondition  `json:"conditions"`
+}
+
+// NamespaceCapacityReportStatus : What one namespace requests of the selected
+// nodes. Whether the selected nodes survive losing one is in the
+// ClusterCapacityReports, a namespace has no N-1 of its own
+type NamespaceCapacityReportStatus struct {
+	ClusterName string             `json:"clusterName,omitempty"`
+	Requested   ReportResources    `json:"requested"`
+	Limits      ReportLimits       `json:"limits"`
+	Share       map[string]float64 `json:"share"`
+	LastUpdated metav1.Time        `json:"lastUpdated"`
+}
+
+// reportResources formats millicores and bytes as quantities
+func reportResources(cpuMilliCores, memory, pods int64) ReportResources {
+	return ReportResources{
+		CPU:    resource.NewMilliQuantity(cpuMilliCores, resource.DecimalSI).String(),
+		Memory: resource.NewQuantity(memory, resource.BinarySI).String(),
+		Pods:   pods,
+	}
+}
+
+// reportName makes a node group name fit an object name, <none> is none
+func reportName(group string) string {
+	name := strings.Trim(reportNameInvalid.ReplaceAllString(strings.ToLower(group), "-"), "-.")
+	if len(name) > 253 {
+		name = strings.Trim(name[:253], "-.")
+	}
+	if name == "" {
+		return "none"
+	}
+	return name
+}
+
+// nminusOneCondition is NminusOneAtRisk for what is left of the requests
+// once the largest node is gone, of what or whom
+func nminusOneCondition(of string, cpuMilliCores, memory, pods int64, now time.Time) ReportCondition {
+	condition := ReportCondition{
+		Type:               "NminusOneAtRisk",
+		Status:             "False",
+		Reason:             "FitsNminusOne",
+		Message:            fmt.Sprintf("Requests on %s still fit without its largest node", of),
+		LastTransitionTime: metav1.NewTime(now.UTC().Truncate(time.Second)),
+	}
+	var short []string
+	if cpuMilliCores < 0 {
+		short = append(short, resource.NewMilliQuantity(-cpuMilliCores, resource.DecimalSI).String()+" cpu")
+	}
+	if memory < 0 {
+		short = append(short, resource.NewQuantity(-memory, resource.BinarySI).String()+" memory")
+	}
+	if pods < 0 {
+		short = append(short, fmt.Sprintf("%d pods", -pods))
+	}
+	if len(short) > 0 {
+		condition.Status = "True"
+		condition.Reason = "RequestsExceedNminusOne"
+		condition.Message = fmt.Sprintf("Without its largest node %s is short of %s of requests", of, strings.Join(short, ", "))
+	}
+	return condition
+}
+
+// capacityReports are the ClusterCapacityReports and NamespaceCapacityReports of one cycle
+func capacityReports(clusterInfo ClusterInfo, groupLabel string, now time.Time) (clusterReports, namespaceReports []*unstructured.Unstructured, err error) {
+	updated := metav1.NewTime(now.UTC().Truncate(time.Second))
+	for _, pool := range nodePoolCapacities(clusterInfo, groupLabel) {
+		status := ClusterCapacityReportStatus{
+			ClusterName:        currentSettings().clusterName,
+			NodeGroup:          pool.Name,
+			Nodes:              pool.Nodes,
+			Allocatable:        reportResources(pool.AllocatableCPUMilliCores, pool.AllocatableMemory, pool.AllocatablePods),
+			Requested:          reportResources(pool.CPURequestMilliCores, pool.MemoryRequest, pool.Pods),
+			Available:          reportResources(pool.AvailableCPURequestMilliCores, pool.AvailableMemoryRequest, pool.AvailablePods),
+			AvailableNminusOne: reportResources(pool.AvailableCPURequestNminusoneMilliCores, pool.AvailableMemoryRequestNminusone, pool.AvailablePodsNminusone),
+			Utilization: map[string]float64{
+				"cpu":    pool.UtilizationFactorCPURequest,
+				"memory": pool.UtilizationFactorMemory,
+				"pods":   pool.UtilizationFactorPods,
+			},
+			LastUpdated: updated,
+			Conditions: []ReportCondition{nminusOneCondition("node group "+pool.Name,
+				pool.AvailableCPURequestNminusoneMilliCores, pool.AvailableMemoryRequestNminusone, pool.AvailablePodsNminusone, now)},
+		}
+		report, err := reportObject("ClusterCapacityReport", "", reportName(pool.Name), &status)
+		if err != nil {
+			return nil, nil, err
+		}
+		clusterReports = append(clusterReports, report)
+	}
+
+	allocatableCPU := clusterInfo.ClusterAllocatableCPU.MilliValue()
+	allocatableMemory := clusterInfo.ClusterAllocatableMemory.Value()
+	allocatablePods := clusterInfo.ClusterAllocatablePods.Value()
+	var names []string
+	for name := range clusterInfo.NamespaceTotals {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		totals := clusterInfo.NamespaceTotals[name]
+		status := NamespaceCapacityReportStatus{
+			ClusterName: currentSettings().clusterName,
+			Requested:   reportResources(totals.CPURequestsMilliCores, totals.MemoryRequests, totals.Pods),
+			Limits: ReportLimits{
+				CPU:    resource.NewMilliQuantity(totals.CPULimitsMilliCores, resource.DecimalSI).String(),
+				Memory: resource.NewQuantity(totals.MemoryLimits, resource.BinarySI).String(),
+			},
+			Share: map[string]float64{
+				"cpu":    ratio(totals.CPURequestsMilliCores, allocatableCPU),
+				"memory": ratio(totals.MemoryRequests, allocatableMemory),
+				"pods":   ratio(totals.Pods, allocatablePods),
+			},
+			LastUpdated: updated,
+		}
+		report, err := reportObject("NamespaceCapacityReport", name, namespaceReportName, &status)
+		if err != nil {
+			return nil, nil, err
+		}
+		namespaceReports = append(namespaceReports, report)
+	}
+	return clusterReports, namespaceReports, nil
+}
+
+// reportObject is a capacity report, status is a pointer to its status
+func reportObject(kind, namespace, name string, status interface{}) (*unstructured.Unstructured, error) {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(status)
+	if err != nil {
+		return nil, err
+	}
+	report := &unstructured.Unstructured{Object: map[string]interface{}{"status": content}}
+	report.SetAPIVersion(reportAPIVersion)
+	report.SetKind(kind)
+	report.SetNamespace(namespace)
+	report.SetName(name)
+	report.SetLabels(map[string]string{"app.kubernetes.io/managed-by": "k8sCapcity"})
+	return report, nil
+}
+
+// keepTransitionTimes copies lastTransitionTime from existing conditions
+// whose status has not changed, so it says when it last did
+func keepTransitionTimes(report, existing *unstructured.Unstructured) {
+	previous, _, _ := unstructured.NestedSlice(existing.Object, "status", "conditions")
+	conditions, found, _ := unstructured.NestedSlice(report.Object, "status", "conditions")
+	if !found {
+		return
+	}
+	for _, c := range conditions {
+		condition, ok := c.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for _, p := range previous {
+			old, ok := p.(map[string]interface{})
+			if ok && old["type"] == condition["type"] && old["status"] == condition["status"] && old["lastTransitionTime"] != nil {
+				condition["lastTransitionTime"] = old["lastTransitionTime"]
+			}
+		}
+	}
+	unstructured.SetNestedSlice(report.Object, conditions, "status", "conditions")
+}
+
+// capacityReporter : Writes the capacity reports every daemon cycle
+type capacityReporter struct {
+	client     dynamic.Interface
+	groupLabel string
+}
+
+// write creates or updates the reports of clusterInfo and deletes those of
+// node groups and namespaces it no longer has
+func (r *capacityReporter) write(clusterInfo ClusterInfo, now time.Time) error {
+	clusterReports, namespaceReports, err := capacityReports(clusterInfo, r.groupLabel, now)
+	if err != nil {
+		return err
+	}
+	var problems []string
+	for _, resource := range []struct {
+		gvr     schema.GroupVersionResource
+		reports []*unstructured.Unstructured
+	}{{clusterReportResource, clusterReports}, {namespaceReportResource, namespaceReports}} {
+		problems = append(problems, r.sync(resource.gvr, resource.reports)...)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%s", strings.Join(problems, ", "))
+	}
+	return nil
+}
+
+// reportUnchanged reports whether report says what existing does, apart
+// from when it was written
+func reportUnchanged(report, existing *unstructured.Unstructured) bool {
+	status := func(u *unstructured.Unstructured) string {
+		content, _, _ := unstructured.NestedMap(u.Object, "status")
+		delete(content, "lastUpdated")
+		// As json, so the int64 and float64 of a decoded object compare
+		data, _ := json.Marshal(content)
+		return string(data)
+	}
+	return status(report) == status(existing)
+}
+
+// sync makes the reports of one resource the ones there are, returning what
+// failed. One List finds the existing reports, those whose status did not
+// change are left alone
+func (r *capacityReporter) sync(gvr schema.GroupVersionResource, reports []*unstructured.Unstructured) (problems []string) {
+	list, err := r.client.Resource(gvr).List(metav1.ListOptions{LabelSelector: reportManagedBy})
+	if err != nil {
+		return []string{fmt.Sprintf("%s: %s", gvr.Resource, err)}
+	}
+	existing := make(map[string]*unstructured.Unstructured)
+	for i := range list.Items {
+		existing[list.Items[i].GetNamespace()+"/"+list.Items[i].GetName()] = &list.Items[i]
+	}
+	for _, report := range reports {
+		client := r.client.Resource(gvr).Namespace(report.GetNamespace())
+		key := report.GetNamespace() + "/" + report.GetName()
+		previous, ok := existing[key]
+		delete(existing, key)
+		if !ok {
+			_, err = client.Create(report, metav1.CreateOptions{})
+		} else {
+			keepTransitionTimes(report, previous)
+			if reportUnchanged(report, previous) {
+				continue
+			}
+			report.SetResourceVersion(previous.GetResourceVersion())
+			_, err = client.Update(report, metav1.UpdateOptions{})
+		}
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s %s: %s", gvr.Resource, strings.TrimPrefix(key, "/"), err))
+		}
+	}
+	gone := make([]string, 0, len(existing))
+	for key := range existing {
+		gone = append(gone, key)
+	}
+	sort.Strings(gone)
+	for _, key := range gone {
+		report := existing[key]
+		err := r.client.Resource(gvr).Namespace(report.GetNamespace()).Delete(report.GetName(), &metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			problems = append(problems, fmt.Sprintf("%s %s: %s", gvr.Resource, strings.TrimPrefix(key, "/"), err))
+		}
+	}
+	return problems
+}
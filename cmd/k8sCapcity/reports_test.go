@@ -0,0 +1,128 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic/fake"
+)
+
+func TestReportName(t *testing.T) {
+	for group, expected := range map[string]string{
+		"compute":           "compute",
+		"<none>":            "none",
+		"Standard_D4s_v3":   "standard-d4s-v3",
+		"m5.xlarge":         "m5.xlarge",
+		"pool/with spaces.": "pool-with-spaces",
+	} {
+		compareString(reportName(group), expected, t)
+	}
+}
+
+func TestCapacityReporter(t *testing.T) {
+	earlier := metav1.NewTime(time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC))
+	stale, _ := reportObject("ClusterCapacityReport", "", "old-pool", &ClusterCapacityReportStatus{NodeGroup: "old-pool"})
+	manual, _ := reportObject("ClusterCapacityReport", "", "manual", &ClusterCapacityReportStatus{NodeGroup: "manual"})
+	manual.SetLabels(nil)
+	existing, _ := reportObject("ClusterCapacityReport", "", "compute", &ClusterCapacityReportStatus{
+		NodeGroup:  "compute",
+		Conditions: []ReportCondition{{Type: "NminusOneAtRisk", Status: "True", LastTransitionTime: earlier}},
+	})
+	namespace, _ := reportObject("NamespaceCapacityReport", "default", namespaceReportName, &NamespaceCapacityReportStatus{})
+	gone, _ := reportObject("NamespaceCapacityReport", "deleted-team", namespaceReportName, &NamespaceCapacityReportStatus{})
+	client := fake.NewSimpleDynamicClient(runtime.NewScheme(), stale, manual, existing, namespace, gone)
+
+	clusterInfo, _ := metricsTestCluster()
+	now := time.Date(2020, 3, 6, 12, 0, 0, 0, time.UTC)
+	r := &capacityReporter{client: client, groupLabel: "cloud.google.com/gke-nodepool"}
+	if err := r.write(clusterInfo, now); err != nil {
+		t.Fatal(err)
+	}
+
+	reports, err := client.Resource(clusterReportResource).List(metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, report := range reports.Items {
+		names = append(names, report.GetName())
+	}
+	sort.Strings(names)
+	compareString(strings.Join(names, " "), "compute manual none", t)
+
+	compute, err := client.Resource(clusterReportResource).Get("compute", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A condition whose status did not change keeps when it last did
+	for field, expected := range map[string]string{
+		"nodeGroup":                       "compute",
+		"allocatable.cpu":                 "8",
+		"availableNminusOne.cpu":          "-2",
+		"conditions.0.type":               "NminusOneAtRisk",
+		"conditions.0.status":             "True",
+		"conditions.0.reason":             "RequestsExceedNminusOne",
+		"conditions.0.lastTransitionTime": "2020-03-01T00:00:00Z",
+	} {
+		compareString(reportField(t, compute, field), expected, t)
+	}
+	none, err := client.Resource(clusterReportResource).Get("none", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareString(reportField(t, none, "conditions.0.lastTransitionTime"), "2020-03-06T12:00:00Z", t)
+
+	namespace, err = client.Resource(namespaceReportResource).Namespace("default").Get(namespaceReportName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareString(reportField(t, namespace, "requested.cpu"), "1500m", t)
+	if _, ok := namespace.Object["status"].(map[string]interface{})["conditions"]; ok {
+		t.Error("Expected no N-1 condition on a namespace report")
+	}
+	if _, err := client.Resource(namespaceReportResource).Namespace("deleted-team").Get(namespaceReportName, metav1.GetOptions{}); err == nil {
+		t.Error("Expected the report of a namespace without pods to be deleted")
+	}
+
+	// The next cycle lists the reports and writes none that did not change
+	client.ClearActions()
+	if err := r.write(clusterInfo, now.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	var verbs []string
+	for _, action := range client.Actions() {
+		verbs = append(verbs, action.GetVerb())
+	}
+	compareString(strings.Join(verbs, " "), "list list", t)
+}
+
+// reportField is a field of a report's status, dotted, with list indexes
+func reportField(t *testing.T, report *unstructured.Unstructured, path string) string {
+	var value interface{} = report.Object["status"]
+	for _, key := range strings.Split(path, ".") {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			value = v[key]
+		case []interface{}:
+			i, _ := strconv.Atoi(key)
+			if i >= len(v) {
+				t.Fatalf("No %s in %v", path, report.Object["status"])
+			}
+			value = v[i]
+		}
+	}
+	switch v := value.(type) {
+	case string:
+		return v
+	case int64:
+		return strconv.FormatInt(v, 10)
+	}
+	t.Fatalf("No %s in %v", path, report.Object["status"])
+	return ""
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// schedule decides when the daemon runs its next cycle, given when the
+// previous one started, so the time a cycle takes does not cause drift
+type schedule interface {
+	next(last time.Time) time.Time
+}
+
+type intervalSchedule struct {
+	interval time.Duration
+	align    bool
+}
+
+// cronSchedule is a standard five field cron expression: minute hour day-of-month month day-of-week
+type cronSchedule struct {
+	minute, hour, dom, month, dow map[int]bool
+	domAny, dowAny                bool
+}
+
+func newIntervalSchedule(interval time.Duration, align bool) (*intervalSchedule, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("interval must be greater than zero")
+	}
+	return &intervalSchedule{
+		interval: interval,
+		align:    align,
+	}, nil
+}
+
+func (s *intervalSchedule) next(last time.Time) time.Time {
+	if s.align {
+		// Land on wall clock boundaries, e.g. :00, :05, :10 for 5m
+		return last.Truncate(s.interval).Add(s.interval)
+	}
+	return last.Add(s.interval)
+}
+
+// jitterDelay is a random delay below jitter, added on top of the schedule
+// without moving it, so jitter never accumulates into drift
+func jitterDelay(random *rand.Rand, jitter time.Duration) time.Duration {
+	if jitter <= 0 {
+		return 0
+	}
+	return time.Duration(random.Int63n(int64(jitter)))
+}
+
+func parseCronSchedule(spec string) (*cronSchedule, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("schedule %q needs 5 fields: minute hour day-of-month month day-of-week", spec)
+	}
+	s := &cronSchedule{}
+	var err error
+	if s.minute, err = parseCronField(fields[0], 0, 59); err != nil {
+		return nil, fmt.Errorf("minute: %s", err)
+	}
+	if s.hour, err = parseCronField(fields[1], 0, 23); err != nil {
+		return nil, fmt.Errorf("hour: %s", err)
+	}
+	if s.dom, err = parseCronField(fields[2], 1, 31); err != nil {
+		return nil, fmt.Errorf("day-of-month: %s", err)
+	}
+	if s.month, err = parseCronField(fields[3], 1, 12); err != nil {
+		return nil, fmt.Errorf("month: %s", err)
+	}
+	if s.dow, err = parseCronField(fields[4], 0, 7); err != nil {
+		return nil, fmt.Errorf("day-of-week: %s", err)
+	}
+	// 7 is sunday too
+	if s.dow[7] {
+		s.dow[0] = true
+	}
+	s.domAny = fields[2] == "*"
+	s.dowAny = fields[4] == "*"
+	return s, nil
+}
+
+// parseCronField handles *, n, a-b and lists of those, each optionally with a /step
+func parseCronField(field string, min, max int) (map[int]bool, error) {
+	values := make(map[int]bool)
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step <= 0 {
+				return nil, fmt.Errorf("invalid step in %q", part)
+			}
+			part = part[:i]
+		}
+		low, high := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			low, err = strconv.Atoi(bounds[0])
+			if err != nil {
+				return nil, fmt.Errorf("invalid value %q", part)
+			}
+			high = low
+			if len(bounds) == 2 {
+				high, err = strconv.Atoi(bounds[1])
+				if err != nil {
+					return nil, fmt.Errorf("invalid value %q", part)
+				}
+			} else if step > 1 {
+				high = max
+			}
+		}
+		if low < min || high > max || low > high {
+			return nil, fmt.Errorf("%q is outside %d-%d", part, min, max)
+		}
+		for v := low; v <= high; v += step {
+			values[v] = true
+		}
+	}
+	return values, nil
+}
+
+func (s *cronSchedule) matches(t time.Time) bool {
+	if !s.minute[t.Minute()] || !s.hour[t.Hour()] || !s.month[int(t.Month())] {
+		return false
+	}
+	dom := s.dom[t.Day()]
+	dow := s.dow[int(t.Weekday())]
+	// Like cron, when both days are restricted either may match
+	if !s.domAny && !s.dowAny {
+		return dom || dow
+	}
+	return dom && dow
+}
+
+func (s *cronSchedule) next(last time.Time) time.Time {
+	t := last.Truncate(time.Minute).Add(time.Minute)
+	// Any valid expression matches within four years (29th of february)
+	for limit := t.AddDate(4, 0, 1); t.Before(limit); t = t.Add(time.Minute) {
+		if s.matches(t) {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// cycleRunner runs one daemon cycle at a time. A cycle that overruns its
+// timeout has its context cancelled, and further cycles are skipped until it
+// has returned, so cycles never overlap.
+type cycleRunner struct {
+	timeout time.Duration
+	busy    chan struct{}
+}
+
+func newCycleRunner(timeout time.Duration) *cycleRunner {
+	return &cycleRunner{
+		timeout: timeout,
+		busy:    make(chan struct{}, 1),
+	}
+}
+
+// run turns a panic in cycle into an error. Zero timeout waits for as long as it takes
+func (r *cycleRunner) run(cycle func(ctx context.Context)) error {
+	select {
+	case r.busy <- struct{}{}:
+	default:
+		return fmt.Errorf("previous cycle is still running, skipping this one")
+	}
+	ctx, cancel := collectContext(context.Background(), r.timeout)
+	done := make(chan error, 1)
+	go func() {
+		var err error
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				err = fmt.Errorf("cycle failed: %v", recovered)
+			}
+			<-r.busy
+			done <- err
+		}()
+		cycle(ctx)
+	}()
+	defer cancel()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("cycle did not finish within %s", r.timeout)
+	}
+}
+
+// wait blocks until no cycle is running
+func (r *cycleRunner) wait() {
+	r.busy <- struct{}{}
+	<-r.busy
+}
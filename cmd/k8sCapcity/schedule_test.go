@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIntervalSchedule(t *testing.T) {
+	last := time.Date(2020, 3, 2, 10, 3, 17, 0, time.UTC)
+	s, err := newIntervalSchedule(5*time.Minute, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next := s.next(last); !next.Equal(last.Add(5 * time.Minute)) {
+		t.Errorf("Expected %s, got %s", last.Add(5*time.Minute), next)
+	}
+	s, err = newIntervalSchedule(5*time.Minute, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := time.Date(2020, 3, 2, 10, 5, 0, 0, time.UTC)
+	if next := s.next(last); !next.Equal(expected) {
+		t.Errorf("Expected aligned %s, got %s", expected, next)
+	}
+	_, err = newIntervalSchedule(0, false)
+	if err == nil {
+		t.Errorf("Expected an error for a zero interval")
+	}
+}
+
+func TestJitterDelay(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	if delay := jitterDelay(random, 0); delay != 0 {
+		t.Errorf("Expected no delay without jitter, got %s", delay)
+	}
+	for i := 0; i < 100; i++ {
+		if delay := jitterDelay(random, time.Second); delay < 0 || delay >= time.Second {
+			t.Fatalf("Expected delay within 1s, got %s", delay)
+		}
+	}
+}
+
+func TestCronSchedule(t *testing.T) {
+	cases := []struct {
+		spec     string
+		last     time.Time
+		expected time.Time
+	}{
+		{"*/15 * * * *", time.Date(2020, 3, 2, 10, 3, 0, 0, time.UTC), time.Date(2020, 3, 2, 10, 15, 0, 0, time.UTC)},
+		{"0 6 * * 1-5", time.Date(2020, 3, 6, 7, 0, 0, 0, time.UTC), time.Date(2020, 3, 9, 6, 0, 0, 0, time.UTC)},
+		{"30 2 1 * *", time.Date(2020, 3, 2, 0, 0, 0, 0, time.UTC), time.Date(2020, 4, 1, 2, 30, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 12 * * 7", time.Date(2020, 3, 2, 0, 0, 0, 0, time.UTC), time.Date(2020, 3, 8, 12, 0, 0, 0, time.UTC)},
+	}
+	for _, c := range cases {
+		s, err := parseCronSchedule(c.spec)
+		if err != nil {
+			t.Fatalf("%s: %s", c.spec, err)
+		}
+		if next := s.next(c.last); !next.Equal(c.expected) {
+			t.Errorf("%s: expected %s, got %s", c.spec, c.expected, next)
+		}
+	}
+}
+
+func TestCronScheduleErrors(t *testing.T) {
+	for _, spec := range []string{"* * * *", "60 * * * *", "* * 0 * *", "*/0 * * * *", "a * * * *", "5-1 * * * *"} {
+		_, err := parseCronSchedule(spec)
+		if err == nil {
+			t.Errorf("Expected an error for %q", spec)
+		}
+	}
+}
+
+func TestCycleRunner(t *testing.T) {
+	runner := newCycleRunner(0)
+	ran := false
+	err := runner.run(func(ctx context.Context) { ran = true })
+	if err != nil || !ran {
+		t.Errorf("Expected the cycle to run without error, got %v", err)
+	}
+	err = runner.run(func(ctx context.Context) { panic("api down") })
+	if err == nil || !strings.Contains(err.Error(), "api down") {
+		t.Errorf("Expected the panic as an error, got %v", err)
+	}
+}
+
+func TestCycleRunnerTimeout(t *testing.T) {
+	runner := newCycleRunner(10 * time.Millisecond)
+	release := make(chan struct{})
+	cancelled := make(chan struct{})
+	err := runner.run(func(ctx context.Context) {
+		<-ctx.Done()
+		close(cancelled)
+		<-release
+	})
+	if err == nil {
+		t.Fatalf("Expected a timeout error")
+	}
+	<-cancelled
+	err = runner.run(func(ctx context.Context) {})
+	if err == nil || !strings.Contains(err.Error(), "still running") {
+		t.Errorf("Expected the overlapping cycle to be skipped, got %v", err)
+	}
+	close(release)
+	runner.wait()
+	err = runner.run(func(ctx context.Context) {})
+	if err != nil {
+		t.Errorf("Expected the cycle to run once the slow one finished, got %v", err)
+	}
+}
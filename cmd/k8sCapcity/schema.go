@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// schemaBaseURL is where the published schemas live, docs/schema in the repo
+const schemaBaseURL = "https://raw.githubusercontent.com/jmainguy/k8sCapcity/master/docs/schema/"
+
+// jsonSchema : The parts of JSON Schema draft-07 the events need
+type jsonSchema struct {
+	Schema               string                 `json:"$schema,omitempty"`
+	ID                   string                 `json:"$id,omitempty"`
+	Title                string                 `json:"title,omitempty"`
+	Description          string                 `json:"description,omitempty"`
+	Type                 interface{}            `json:"type,omitempty"`
+	Const                string                 `json:"const,omitempty"`
+	Properties           map[string]*jsonSchema `json:"properties,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	AdditionalProperties interface{}            `json:"additionalProperties,omitempty"`
+	Items                *jsonSchema            `json:"items,omitempty"`
+}
+
+// schemaRevision is the version in the file name of the current schema of
+// an event. A changed event gets a new revision and file, the files already
+// published never change
+func schemaRevision(event string, version int) string {
+	if event == "capacity" {
+		return fmt.Sprintf("%d.1", version)
+	}
+	return strconv.Itoa(version)
+}
+
+// eventSchema is the published schema of the capacity event of one
+// -event-version, or of the node event
+func eventSchema(event string, version int) (*jsonSchema, error) {
+	var s *jsonSchema
+	switch {
+	case event == "capacity" && version == 1:
+		s = schemaOf(reflect.TypeOf(Capcity{}))
+		s.Properties["event.version"].Const = eventVersionV1
+		s.Description = "What k8sCapcity -json and the daemon print"
+	case event == "capacity" && version == 2:
+		s = schemaOf(reflect.TypeOf(CapacityEvent{}))
+		s.Properties["event"].Properties["version"].Const = eventVersionV2
+		s.Description = "What k8sCapcity -json and the daemon print with -event-version 2"
+	case event == "node" && version == 1:
+		s = schemaOf(reflect.TypeOf(NodeCapacity{}))
+		s.Properties["event.version"].Const = nodeEventVersion
+		s.Description = "What k8sCapcity nodes -json and the daemon with -node-events print, one per selected node"
+	case event == "capacity" || event == "node":
+		return nil, fmt.Errorf("no %s event version %d", event, version)
+	default:
+		return nil, fmt.Errorf("unknown event %s, use capacity or node", event)
+	}
+	s.Schema = "http://json-schema.org/draft-07/schema#"
+	revision := schemaRevision(event, version)
+	s.ID = fmt.Sprintf("%s%s-event-v%s.json", schemaBaseURL, event, revision)
+	s.Title = fmt.Sprintf("k8sCapcity %s event v%s", event, revision)
+	return s, nil
+}
+
+// schemaOf describes t the way encoding/json writes it. Fields without
+// omitempty are required and the description tag documents a field
+func schemaOf(t reflect.Type) *jsonSchema {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return schemaOf(t.Elem())
+	case reflect.Bool:
+		return &jsonSchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &jsonSchema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &jsonSchema{Type: "number"}
+	case reflect.String:
+		return &jsonSchema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		return &jsonSchema{Type: "array", Items: schemaOf(t.Elem())}
+	case reflect.Map:
+		return &jsonSchema{Type: "object", AdditionalProperties: schemaOf(t.Elem())}
+	case reflect.Struct:
+		s := &jsonSchema{Type: "object", Properties: make(map[string]*jsonSchema), AdditionalProperties: false}
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			// encoding/json lifts the fields of an untagged embedded struct
+			if field.Anonymous && field.Tag.Get("json") == "" && field.Type.Kind() == reflect.Struct {
+				embedded := schemaOf(field.Type)
+				for name, property := range embedded.Properties {
+					s.Properties[name] = property
+				}
+				s.Required = append(s.Required, embedded.Required...)
+				continue
+			}
+			name, omitEmpty := jsonName(field)
+			if name == "" {
+				continue
+			}
+			property := schemaOf(field.Type)
+			property.Description = field.Tag.Get("description")
+			kind := field.Type.Kind()
+			if !omitEmpty && (kind == reflect.Map || kind == reflect.Slice || kind == reflect.Ptr) {
+				property.Type = []interface{}{property.Type, "null"}
+			}
+			s.Properties[name] = property
+			if !omitEmpty {
+				s.Required = append(s.Required, name)
+			}
+		}
+		return s
+	}
+	return &jsonSchema{}
+}
+
+// jsonName is the name encoding/json gives field, blank when it is left out
+func jsonName(field reflect.StructField) (name string, omitEmpty bool) {
+	if field.PkgPath != "" {
+		return "", false
+	}
+	tag := field.Tag.Get("json")
+	if tag == "-" {
+		return "", false
+	}
+	parts := strings.Split(tag, ",")
+	name = parts[0]
+	if name == "" {
+		name = field.Name
+	}
+	for _, option := range parts[1:] {
+		if option == "omitempty" {
+			omitEmpty = true
+		}
+	}
+	return name, omitEmpty
+}
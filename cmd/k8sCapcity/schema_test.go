@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func schemaTestCluster() ClusterInfo {
+	return ClusterInfo{
+		ClusterAllocatableMemory: resource.MustParse("64Gi"),
+		ClusterAllocatableCPU:    resource.MustParse("16"),
+		ClusterAllocatablePods:   resource.MustParse("220"),
+		NminusCPU:                resource.MustParse("8"),
+		NminusMemory:             resource.MustParse("32Gi"),
+		NminusPods:               resource.MustParse("110"),
+		NodeLabel:                "role=compute",
+		NodeInfo: map[string]NodeInfo{
+			"node-a": {AllocatableCPU: resource.MustParse("8"), AllocatableMemory: resource.MustParse("32Gi"), AllocatablePods: resource.MustParse("110"),
+				UsedCPURequests: resource.MustParse("2"), UsedMemoryRequests: resource.MustParse("8Gi"), UsedPods: 20, PrintOutput: true},
+			"node-b": {AllocatableCPU: resource.MustParse("8"), AllocatableMemory: resource.MustParse("32Gi"), AllocatablePods: resource.MustParse("110"),
+				UsedCPURequests: resource.MustParse("4"), UsedMemoryRequests: resource.MustParse("16Gi"), UsedPods: 40, PrintOutput: true},
+		},
+	}
+}
+
+func TestPublishedSchemas(t *testing.T) {
+	for _, version := range []int{1, 2} {
+		s, err := eventSchema("capacity", version)
+		if err != nil {
+			t.Fatal(err)
+		}
+		generated, err := json.MarshalIndent(s, "", "  ")
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := fmt.Sprintf("../../docs/schema/capacity-event-v%s.json", schemaRevision("capacity", version))
+		published, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(published) != string(generated)+"\n" {
+			t.Errorf("%s is out of date, regenerate it with k8sCapcity schema -event-version %d", path, version)
+		}
+	}
+	// Superseded schemas stay as they were published
+	for file, eventVersion := range map[string]string{"capacity-event-v1.json": `"const": "03/06/2020-01"`, "capacity-event-v2.json": `"const": "2"`} {
+		published, err := ioutil.ReadFile("../../docs/schema/" + file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(published), eventVersion) || strings.Contains(string(published), "@timestamp") {
+			t.Errorf("docs/schema/%s changed after it was published, add a new revision instead", file)
+		}
+	}
+	s, err := eventSchema("node", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	generated, _ := json.MarshalIndent(s, "", "  ")
+	published, err := ioutil.ReadFile("../../docs/schema/node-event-v1.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(published) != string(generated)+"\n" {
+		t.Errorf("docs/schema/node-event-v1.json is out of date, regenerate it with k8sCapcity schema -event node")
+	}
+	_, err = eventSchema("capacity", 3)
+	compareString(fmt.Sprint(err), "no capacity event version 3", t)
+	_, err = eventSchema("pod", 1)
+	compareString(fmt.Sprint(err), "unknown event pod, use capacity or node", t)
+}
+
+// schemaProblems lists what in value the schema does not allow
+func schemaProblems(s *jsonSchema, value interface{}, path string) (problems []string) {
+	object, ok := value.(map[string]interface{})
+	if !ok || s.Properties == nil {
+		return nil
+	}
+	for _, name := range s.Required {
+		if _, ok := object[name]; !ok {
+			problems = append(problems, "missing "+path+name)
+		}
+	}
+	for name, v := range object {
+		property, ok := s.Properties[name]
+		if !ok {
+			problems = append(problems, "unexpected "+path+name)
+			continue
+		}
+		problems = append(problems, schemaProblems(property, v, path+name+".")...)
+	}
+	sort.Strings(problems)
+	return problems
+}
+
+func TestEventsMatchSchema(t *testing.T) {
+	capCity := calculateCapcity(schemaTestCluster())
+	days := 12.5
+	capCity.ForecastPodsNminusoneExhaustion = "2020-04-01T00:00:00Z"
+	capCity.ForecastPodsNminusoneDays = &days
+	capCity.FleetClusters = []string{"east"}
+	node := nodeEvents(schemaTestCluster(), nil)[0]
+	node.Labels = map[string]string{"team": "web"}
+	node.Conditions = map[string]string{"Ready": "True"}
+	events := []struct {
+		event   string
+		version int
+		value   interface{}
+	}{{"capacity", 1, capCity}, {"capacity", 2, capacityEvent(capCity)}, {"node", 1, node}}
+	for _, event := range events {
+		s, _ := eventSchema(event.event, event.version)
+		data, _ := json.Marshal(event.value)
+		var value interface{}
+		json.Unmarshal(data, &value)
+		compareString(strings.Join(schemaProblems(s, value, ""), ", "), "", t)
+	}
+}
+
+func TestCapacityEvent(t *testing.T) {
+	capCity := calculateCapcity(schemaTestCluster())
+	event := capacityEvent(capCity)
+	compareString(event.Event.Version, "2.1", t)
+	compareString(fmt.Sprint(event.K8sQuota.Allocatable.CPUCores), fmt.Sprint(TotalAndNminusone{capCity.AllocatableCPUTotal, capCity.AllocatableCPUNminusone}), t)
+	compareString(fmt.Sprint(event.K8sQuota.Available.Pods.Nminusone), fmt.Sprint(capCity.AvailablePodsNminusone), t)
+	if event.K8sQuota.Forecast != nil || event.K8sQuota.Fleet != nil {
+		t.Errorf("Expected no forecast or fleet, got %v %v", event.K8sQuota.Forecast, event.K8sQuota.Fleet)
+	}
+
+	capCity.ForecastMemoryRequestNminusoneExhaustion = "2020-04-01T00:00:00Z"
+	event = capacityEvent(capCity)
+	if event.K8sQuota.Forecast == nil || event.K8sQuota.Forecast.MemoryRequest == nil || event.K8sQuota.Forecast.Pods != nil {
+		t.Fatalf("Expected a memory request forecast only, got %+v", event.K8sQuota.Forecast)
+	}
+	compareString(event.K8sQuota.Forecast.MemoryRequest.Exhaustion, "2020-04-01T00:00:00Z", t)
+
+	data, _ := json.Marshal(event)
+	if !strings.Contains(string(data), `"allocatable":{"cpu_cores":`) {
+		t.Errorf("Expected nested allocatable fields, got %s", data)
+	}
+}
+
+func TestEventVersionFlag(t *testing.T) {
+	version := eventVersionFlag(1)
+	compareString(fmt.Sprint(version.Set("2")), "<nil>", t)
+	compareString(version.String(), "2", t)
+	compareString(fmt.Sprint(version.Set("v2")), "unknown event version v2, use 1 or 2", t)
+
+	defer setSettings(currentSettings())
+	capCity := calculateCapcity(schemaTestCluster())
+	setSettings(runSettings{eventVersion: 1})
+	data, _ := marshalEvent(capCity)
+	if !strings.Contains(string(data), `"event.version":"03/06/2020-02"`) {
+		t.Errorf("Expected a v1 event, got %s", data)
+	}
+	setSettings(runSettings{eventVersion: 2})
+	data, _ = marshalEvent(capCity)
+	if !strings.Contains(string(data), `"version":"2.1"`) {
+		t.Errorf("Expected a v2 event, got %s", data)
+	}
+}
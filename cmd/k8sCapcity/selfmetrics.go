@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// collectionBuckets are the upper bounds, in seconds, of the collection duration histogram
+var collectionBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120}
+
+// selfMetrics is what k8sCapcity itself did, served as /metrics
+var selfMetrics = newMetricsRegistry()
+
+// metricsRegistry : Counters, gauges and the collection duration histogram,
+// written in the Prometheus text format
+type metricsRegistry struct {
+	sync.Mutex
+	counters        map[string]map[string]float64
+	gauges          map[string]map[string]float64
+	durationBuckets []uint64
+	durationSum     float64
+	durationCount   uint64
+}
+
+func newMetricsRegistry() *metricsRegistry {
+	return &metricsRegistry{
+		counters:        make(map[string]map[string]float64),
+		gauges:          make(map[string]map[string]float64),
+		durationBuckets: make([]uint64, len(collectionBuckets)),
+	}
+}
+
+// metricHelp documents every self metric, in the order they are written
+var metricHelp = []struct{ name, kind, help string }{
+	{"k8scapcity_build_info", "gauge", "Always 1, labelled with the k8sCapcity version"},
+	{"k8scapcity_collection_duration_seconds", "histogram", "How long collecting the cluster took"},
+	{"k8scapcity_collections_total", "counter", "Collections, by result"},
+	{"k8scapcity_last_success_timestamp_seconds", "gauge", "Unix time the last successful collection finished"},
+	{"k8scapcity_objects_processed_total", "counter", "Nodes, pods and namespaces added up by successful collections"},
+	{"k8scapcity_api_requests_total", "counter", "Kubernetes api requests, by verb, resource and status code"},
+	{"k8scapcity_api_errors_total", "counter", "Kubernetes api requests that failed or were answered with an error, by verb and resource"},
+}
+
+// metricLabels renders pairs of label names and values, e.g. {verb="list",resource="pods"}
+func metricLabels(pairs ...string) string {
+	var labels []string
+	for i := 0; i+1 < len(pairs); i += 2 {
+		labels = append(labels, fmt.Sprintf("%s=%q", pairs[i], pairs[i+1]))
+	}
+	if len(labels) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(labels, ",") + "}"
+}
+
+func (m *metricsRegistry) add(name, labels string, value float64) {
+	m.Lock()
+	defer m.Unlock()
+	if m.counters[name] == nil {
+		m.counters[name] = make(map[string]float64)
+	}
+	m.counters[name][labels] += value
+}
+
+func (m *metricsRegistry) set(name, labels string, value float64) {
+	m.Lock()
+	defer m.Unlock()
+	if m.gauges[name] == nil {
+		m.gauges[name] = make(map[string]float64)
+	}
+	m.gauges[name][labels] = value
+}
+
+// observeCollection counts one collection and, when it succeeded, what it added up
+func (m *metricsRegistry) observeCollection(duration time.Duration, clusterInfo *ClusterInfo, finished time.Time) {
+	result := "failure"
+	if clusterInfo != nil {
+		result = "success"
+		var pods int64
+		for _, node := range clusterInfo.NodeInfo {
+			pods += node.UsedPods
+		}
+		m.add("k8scapcity_objects_processed_total", metricLabels("kind", "nodes"), float64(len(clusterInfo.NodeInfo)))
+		m.add("k8scapcity_objects_processed_total", metricLabels("kind", "pods"), float64(pods))
+		m.add("k8scapcity_objects_processed_total", metricLabels("kind", "namespaces"), float64(len(clusterInfo.NamespaceTotals)))
+		m.set("k8scapcity_last_success_timestamp_seconds", "", float64(finished.UnixNano())/1e9)
+	}
+	m.add("k8scapcity_collections_total", metricLabels("result", result), 1)
+	m.Lock()
+	defer m.Unlock()
+	seconds := duration.Seconds()
+	for i, bound := range collectionBuckets {
+		if seconds <= bound {
+			m.durationBuckets[i]++
+		}
+	}
+	m.durationSum += seconds
+	m.durationCount++
+}
+
+// observeRequest counts one api request, code 0 is a request that got no answer
+func (m *metricsRegistry) observeRequest(verb, resource string, code int) {
+	status := strconv.Itoa(code)
+	if code == 0 {
+		status = "error"
+	}
+	m.add("k8scapcity_api_requests_total", metricLabels("verb", verb, "resource", resource, "code", status), 1)
+	if code == 0 || code >= 400 {
+		m.add("k8scapcity_api_errors_total", metricLabels("verb", verb, "resource", resource), 1)
+	}
+}
+
+func (m *metricsRegistry) write(out io.Writer) {
+	m.set("k8scapcity_build_info", metricLabels("version", version), 1)
+	m.Lock()
+	defer m.Unlock()
+	for _, metric := range metricHelp {
+		fmt.Fprintf(out, "# HELP %s %s\n# TYPE %s %s\n", metric.name, metric.help, metric.name, metric.kind)
+		if metric.kind == "histogram" {
+			for i, bound := range collectionBuckets {
+				fmt.Fprintf(out, "%s_bucket{le=%q} %d\n", metric.name, strconv.FormatFloat(bound, 'f', -1, 64), m.durationBuckets[i])
+			}
+			fmt.Fprintf(out, "%s_bucket{le=\"+Inf\"} %d\n%s_sum %s\n%s_count %d\n", metric.name, m.durationCount,
+				metric.name, strconv.FormatFloat(m.durationSum, 'f', -1, 64), metric.name, m.durationCount)
+			continue
+		}
+		values := m.counters[metric.name]
+		if metric.kind == "gauge" {
+			values = m.gauges[metric.name]
+		}
+		var labels []string
+		for label := range values {
+			labels = append(labels, label)
+		}
+		sort.Strings(labels)
+		for _, label := range labels {
+			fmt.Fprintf(out, "%s%s %s\n", metric.name, label, strconv.FormatFloat(values[label], 'f', -1, 64))
+		}
+	}
+}
+
+func (m *metricsRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.write(w)
+}
+
+// instrumentTransport counts every kubernetes api request in selfMetrics
+func instrumentTransport(rt http.RoundTripper) http.RoundTripper {
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		verb, resource := apiRequestKind(req)
+		resp, err := rt.RoundTrip(req)
+		code := 0
+		if err == nil {
+			code = resp.StatusCode
+		}
+		selfMetrics.observeRequest(verb, resource, code)
+		return resp, err
+	})
+}
+
+// roundTripperFunc : A function as an http.RoundTripper
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// apiRequestKind is the verb and resource of a kubernetes api request, e.g.
+// list pods for GET /api/v1/namespaces/default/pods and get
+// nodes.metrics.k8s.io for GET /apis/metrics.k8s.io/v1beta1/nodes/node-a
+func apiRequestKind(req *http.Request) (verb, resource string) {
+	parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	group := ""
+	switch {
+	case len(parts) >= 2 && parts[0] == "api":
+		parts = parts[2:]
+	case len(parts) >= 3 && parts[0] == "apis":
+		group, parts = parts[1], parts[3:]
+	default:
+		return strings.ToLower(req.Method), "other"
+	}
+	if len(parts) >= 2 && parts[0] == "namespaces" && len(parts) != 2 {
+		parts = parts[2:]
+	}
+	named := len(parts) >= 2
+	resource = "discovery"
+	if len(parts) > 0 {
+		resource = parts[0]
+	}
+	if group != "" && len(parts) > 0 {
+		resource = resource + "." + group
+	}
+	switch req.Method {
+	case http.MethodGet:
+		verb = "get"
+		if req.URL.Query().Get("watch") == "true" || req.URL.Query().Get("watch") == "1" {
+			verb = "watch"
+		} else if !named {
+			verb = "list"
+		}
+	case http.MethodPost:
+		verb = "create"
+	case http.MethodPut:
+		verb = "update"
+	default:
+		verb = strings.ToLower(req.Method)
+	}
+	return verb, resource
+}
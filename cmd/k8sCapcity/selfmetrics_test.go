@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAPIRequestKind(t *testing.T) {
+	for _, test := range []struct {
+		method, url, expected string
+	}{
+		{"GET", "/api/v1/nodes?labelSelector=role%3Dcompute&limit=500", "list nodes"},
+		{"GET", "/api/v1/nodes/node-a", "get nodes"},
+		{"GET", "/api/v1/pods?watch=true&resourceVersion=10", "watch pods"},
+		{"GET", "/api/v1/namespaces/default/pods", "list pods"},
+		{"GET", "/api/v1/namespaces/default", "get namespaces"},
+		{"GET", "/apis/metrics.k8s.io/v1beta1/nodes", "list nodes.metrics.k8s.io"},
+		{"PUT", "/apis/coordination.k8s.io/v1/namespaces/k8scapcity/leases/k8scapcity", "update leases.coordination.k8s.io"},
+		{"POST", "/api/v1/namespaces/k8scapcity/configmaps", "create configmaps"},
+		{"GET", "/api", "get other"},
+		{"GET", "/apis/metrics.k8s.io/v1beta1", "list discovery"},
+	} {
+		req := httptest.NewRequest(test.method, test.url, nil)
+		verb, resource := apiRequestKind(req)
+		compareString(verb+" "+resource, test.expected, t)
+	}
+}
+
+func TestMetricsRegistry(t *testing.T) {
+	m := newMetricsRegistry()
+	clusterInfo := schemaTestCluster()
+	m.observeCollection(1500*time.Millisecond, &clusterInfo, time.Unix(1583452800, 0))
+	m.observeCollection(200*time.Millisecond, nil, time.Unix(1583452860, 0))
+	m.observeRequest("list", "pods", 200)
+	m.observeRequest("list", "pods", 0)
+	m.observeRequest("get", "nodes.metrics.k8s.io", 503)
+	out := &bytes.Buffer{}
+	m.write(out)
+	for _, line := range []string{
+		"# TYPE k8scapcity_collection_duration_seconds histogram",
+		`k8scapcity_collection_duration_seconds_bucket{le="0.25"} 1`,
+		`k8scapcity_collection_duration_seconds_bucket{le="2.5"} 2`,
+		`k8scapcity_collection_duration_seconds_bucket{le="+Inf"} 2`,
+		"k8scapcity_collection_duration_seconds_sum 1.7",
+		`k8scapcity_collections_total{result="failure"} 1`,
+		`k8scapcity_collections_total{result="success"} 1`,
+		"k8scapcity_last_success_timestamp_seconds 1583452800",
+		`k8scapcity_objects_processed_total{kind="nodes"} 2`,
+		`k8scapcity_objects_processed_total{kind="pods"} 60`,
+		`k8scapcity_api_requests_total{verb="list",resource="pods",code="200"} 1`,
+		`k8scapcity_api_requests_total{verb="list",resource="pods",code="error"} 1`,
+		`k8scapcity_api_errors_total{verb="get",resource="nodes.metrics.k8s.io"} 1`,
+		`k8scapcity_api_errors_total{verb="list",resource="pods"} 1`,
+	} {
+		if !strings.Contains(out.String(), line+"\n") {
+			t.Errorf("Expected %s in\n%s", line, out.String())
+		}
+	}
+}
+
+func TestInstrumentTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer server.Close()
+	client := &http.Client{Transport: instrumentTransport(http.DefaultTransport)}
+	resp, err := client.Get(server.URL + "/api/v1/resourcequotas")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	out := &bytes.Buffer{}
+	selfMetrics.write(out)
+	if !strings.Contains(out.String(), `k8scapcity_api_errors_total{verb="list",resource="resourcequotas"}`) {
+		t.Errorf("Expected the forbidden list to be counted, got\n%s", out.String())
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	resource "k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/client-go/kubernetes"
+)
+
+// apiState : One refresh of the cluster, what the api answers from
+type apiState struct {
+	clusterInfo ClusterInfo
+	capCity     Capcity
+	nodes       []NodeCapacity
+	namespaces  map[string]NamespaceInfo
+	refreshed   time.Time
+}
+
+// newAPIState works out the answers once per refresh, so they and their
+// ETags stay the same until the next one. namespaces is nil when only the
+// namespace totals were collected
+func newAPIState(clusterInfo ClusterInfo, capCity Capcity, namespaces map[string]NamespaceInfo, eventLabels []string, now time.Time) *apiState {
+	state := &apiState{
+		clusterInfo: clusterInfo,
+		capCity:     capCity,
+		nodes:       nodeEvents(clusterInfo, eventLabels),
+		namespaces:  namespaces,
+		refreshed:   now,
+	}
+	timestamp := now.UTC().Format(time.RFC3339Nano)
+	state.capCity.Timestamp = timestamp
+	for i := range state.nodes {
+		state.nodes[i].Timestamp = timestamp
+	}
+	return state
+}
+
+// apiServer : Answers capacity queries from the last refresh
+type apiServer struct {
+	sync.RWMutex
+	state *apiState
+}
+
+func (s *apiServer) set(state *apiState) {
+	s.Lock()
+	defer s.Unlock()
+	s.state = state
+}
+
+func (s *apiServer) current() *apiState {
+	s.RLock()
+	defer s.RUnlock()
+	return s.state
+}
+
+// handler serves /v1. Every answer is json with an ETag of its body and the
+// refresh time as Last-Modified, so clients can poll with conditional requests
+func (s *apiServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	route := func(pattern string, answer func(state *apiState, r *http.Request, name string) (interface{}, int, error)) {
+		mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet && r.Method != http.MethodHead {
+				w.Header().Set("Allow", "GET, HEAD")
+				apiError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s is not allowed", r.Method))
+				return
+			}
+			state := s.current()
+			if state == nil {
+				w.Header().Set("Retry-After", "5")
+				apiError(w, http.StatusServiceUnavailable, fmt.Errorf("no capacity collected yet"))
+				return
+			}
+			v, status, err := answer(state, r, strings.TrimPrefix(r.URL.Path, pattern))
+			if err != nil {
+				apiError(w, status, err)
+				return
+			}
+			apiReply(w, r, state.refreshed, v)
+		})
+	}
+	route("/v1/cluster", func(state *apiState, r *http.Request, name string) (interface{}, int, error) {
+		return eventObject(state.capCity), 0, nil
+	})
+	route("/v1/nodes", func(state *apiState, r *http.Request, name string) (interface{}, int, error) {
+		return state.nodes, 0, nil
+	})
+	route("/v1/nodes/", func(state *apiState, r *http.Request, name string) (interface{}, int, error) {
+		for _, node := range state.nodes {
+			if node.Name == name {
+				return node, 0, nil
+			}
+		}
+		return nil, http.StatusNotFound, fmt.Errorf("no selected node %s", name)
+	})
+	route("/v1/namespaces", func(state *apiState, r *http.Request, name string) (interface{}, int, error) {
+		return state.clusterInfo.NamespaceTotals, 0, nil
+	})
+	route("/v1/namespaces/", func(state *apiState, r *http.Request, name string) (interface{}, int, error) {
+		// NAME/totals is the one namespace answer the daemon has too
+		if namespace := strings.TrimSuffix(name, "/totals"); namespace != name {
+			totals, ok := state.clusterInfo.NamespaceTotals[namespace]
+			if !ok {
+				return nil, http.StatusNotFound, fmt.Errorf("no pods on the selected nodes in namespace %s", namespace)
+			}
+			return totals, 0, nil
+		}
+		if state.namespaces == nil {
+			return nil, http.StatusNotImplemented, fmt.Errorf("namespace details are only collected by the serve command, /v1/namespaces/%s/totals has the totals", name)
+		}
+		nsInfo, ok := state.namespaces[name]
+		if !ok {
+			return nil, http.StatusNotFound, fmt.Errorf("no pods with metrics in namespace %s", name)
+		}
+		return nsInfo, 0, nil
+	})
+	route("/v1/fit", func(state *apiState, r *http.Request, name string) (interface{}, int, error) {
+		return apiFit(state, r)
+	})
+	groups := func(state *apiState, r *http.Request, label string) (interface{}, int, error) {
+		pools := nodePoolCapacities(state.clusterInfo, label)
+		name := r.URL.Query().Get("name")
+		if name == "" {
+			return pools, 0, nil
+		}
+		for _, pool := range pools {
+			if pool.Name == name {
+				return pool, 0, nil
+			}
+		}
+		return nil, http.StatusNotFound, fmt.Errorf("no selected nodes with %s", name)
+	}
+	route("/v1/groups", groups)
+	route("/v1/groups/", groups)
+	return mux
+}
+
+// apiFit is simulate for the cpu, memory and replicas query parameters
+func apiFit(state *apiState, r *http.Request) (interface{}, int, error) {
+	query := r.URL.Query()
+	var cpu, memory resource.Quantity
+	var err error
+	if query.Get("cpu") == "" && query.Get("memory") == "" {
+		return nil, http.StatusBadRequest, fmt.Errorf("give cpu, memory or both")
+	}
+	if value := query.Get("cpu"); value != "" {
+		if cpu, err = resource.ParseQuantity(value); err != nil {
+			return nil, http.StatusBadRequest, fmt.Errorf("cpu %q: %s", value, err)
+		}
+	}
+	if value := query.Get("memory"); value != "" {
+		if memory, err = resource.ParseQuantity(value); err != nil {
+			return nil, http.StatusBadRequest, fmt.Errorf("memory %q: %s", value, err)
+		}
+	}
+	replicas := int64(1)
+	if value := query.Get("replicas"); value != "" {
+		if replicas, err = strconv.ParseInt(value, 10, 64); err != nil || replicas < 1 {
+			return nil, http.StatusBadRequest, fmt.Errorf("replicas %q is not a number of at least 1", value)
+		}
+	}
+	return simulateFit(state.clusterInfo, cpu, memory, replicas), 0, nil
+}
+
+func apiReply(w http.ResponseWriter, r *http.Request, modified time.Time, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		apiError(w, http.StatusInternalServerError, err)
+		return
+	}
+	etag := fmt.Sprintf(`"%x"`, sha256.Sum256(body))
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	w.Header().Set("Cache-Control", "no-cache")
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		if match == etag || match == "*" {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	} else if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && !modified.Truncate(time.Second).After(since) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)+1))
+	if r.Method == http.MethodHead {
+		return
+	}
+	w.Write(append(body, '\n'))
+}
+
+func apiError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
+
+// gatherAPIState collects the selected nodes and every namespace, giving up
+// once parent is done or -collect-timeout passes
+func (o *clusterOptions) gatherAPIState(parent context.Context, clientset *kubernetes.Clientset, eventLabels []string) *apiState {
+	ctx, cancel := collectContext(parent, o.collectTimeout)
+	defer cancel()
+	clusterInfo := gatherInfo(ctx, clientset, &o.nodeLabel)
+	return newAPIState(clusterInfo, calculateCapcity(clusterInfo), gatherNamespaceInfos(ctx, clientset), eventLabels, time.Now())
+}
+
+// runAPIServer serves on listen, with /healthz, /readyz and /metrics,
+// refreshing every interval until SIGTERM or SIGINT. A refresh still running
+// when the next is due is cancelled, a failed one keeps answering from the
+// previous one
+func runAPIServer(listen string, interval time.Duration, readyIntervals int, s *apiServer, gather func(ctx context.Context) *apiState) error {
+	health := &healthState{due: func(t time.Time) time.Time {
+		return t.Add(time.Duration(readyIntervals) * interval)
+	}}
+	mux := http.NewServeMux()
+	mux.Handle("/v1/", s.handler())
+	health.routes(mux)
+	server := &http.Server{Addr: listen, Handler: mux}
+	failed := make(chan error, 1)
+	go func() {
+		failed <- server.ListenAndServe()
+	}()
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGTERM, os.Interrupt)
+	defer signal.Stop(stop)
+	runner := newCycleRunner(interval)
+	refresh := func() {
+		err := runner.run(func(ctx context.Context) {
+			var state *apiState
+			health.track(func() ClusterInfo {
+				state = gather(ctx)
+				return state.clusterInfo
+			})
+			s.set(state)
+		})
+		if err != nil {
+			log.Errorf("Unable to refresh, still serving the capacity from %s, Error: %s", s.lastRefresh(), err)
+		}
+	}
+	log.Infof("Serving the capacity api on %s", listen)
+	refresh()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-failed:
+			return err
+		case sig := <-stop:
+			log.Infof("Received %s, shutting down", sig)
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			return server.Shutdown(ctx)
+		case <-ticker.C:
+			refresh()
+		}
+	}
+}
+
+func (s *apiServer) lastRefresh() string {
+	if state := s.current(); state != nil {
+		return state.refreshed.Format(time.RFC3339)
+	}
+	return "never"
+}
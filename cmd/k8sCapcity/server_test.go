@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func apiTestServer() (*apiServer, *httptest.Server) {
+	s := &apiServer{}
+	return s, httptest.NewServer(s.handler())
+}
+
+func apiGet(t *testing.T, url string, header http.Header) (*http.Response, map[string]interface{}) {
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	for key := range header {
+		req.Header.Set(key, header.Get(key))
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var body interface{}
+	json.NewDecoder(resp.Body).Decode(&body)
+	object, _ := body.(map[string]interface{})
+	if list, ok := body.([]interface{}); ok {
+		object = map[string]interface{}{"items": list}
+	}
+	return resp, object
+}
+
+func TestAPIServer(t *testing.T) {
+	s, server := apiTestServer()
+	defer server.Close()
+	resp, body := apiGet(t, server.URL+"/v1/cluster", nil)
+	compareString(strconv.Itoa(resp.StatusCode), "503", t)
+	compareString(body["error"].(string), "no capacity collected yet", t)
+
+	clusterInfo, _ := metricsTestCluster()
+	refreshed := time.Date(2020, 3, 6, 0, 0, 0, 0, time.UTC)
+	s.set(newAPIState(clusterInfo, calculateCapcity(clusterInfo), map[string]NamespaceInfo{"default": {Name: "default", NamespaceCPURequestsMilliCores: 1500}}, nil, refreshed))
+
+	for _, test := range []struct {
+		path, field, expected string
+	}{
+		{"/v1/cluster", "k8s_quota.alloctable.cpu.total", "16"},
+		{"/v1/nodes/node-a", "cloud.availability_zone", "us-east-1a"},
+		{"/v1/namespaces", "default", "map[cpu_limits.millicores:0 cpu_requests.millicores:1500 memory_limits.bytes:0 memory_requests.bytes:1024 pods:3]"},
+		{"/v1/namespaces/default", "k8s_quota.namespace.cpu_requests.millicores", "1500"},
+		{"/v1/namespaces/default/totals", "pods", "3"},
+		{"/v1/fit?cpu=2&replicas=7", "k8s_quota.simulate.fit.total", "5"},
+		{"/v1/fit?cpu=2&replicas=7", "k8s_quota.simulate.fits.nminusone", "false"},
+		{"/v1/groups/cloud.google.com/gke-nodepool?name=compute", "k8s_quota.node_pool.nodes", "1"},
+	} {
+		resp, body := apiGet(t, server.URL+test.path, nil)
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("Expected 200 from %s, got %d", test.path, resp.StatusCode)
+			continue
+		}
+		compareString(fmt.Sprint(body[test.field]), test.expected, t)
+		compareString(resp.Header.Get("Last-Modified"), "Fri, 06 Mar 2020 00:00:00 GMT", t)
+	}
+	resp, body = apiGet(t, server.URL+"/v1/nodes", nil)
+	compareString(strconv.Itoa(len(body["items"].([]interface{}))), "2", t)
+
+	for _, test := range []struct {
+		path   string
+		status int
+	}{
+		{"/v1/nodes/node-c", http.StatusNotFound},
+		{"/v1/namespaces/kube-system", http.StatusNotFound},
+		{"/v1/namespaces/kube-system/totals", http.StatusNotFound},
+		{"/v1/groups?name=spot", http.StatusNotFound},
+		{"/v1/fit", http.StatusBadRequest},
+		{"/v1/fit?cpu=lots", http.StatusBadRequest},
+		{"/v1/fit?memory=1Gi&replicas=0", http.StatusBadRequest},
+	} {
+		resp, body := apiGet(t, server.URL+test.path, nil)
+		if resp.StatusCode != test.status || body["error"] == nil {
+			t.Errorf("Expected %d and an error from %s, got %d %v", test.status, test.path, resp.StatusCode, body)
+		}
+	}
+
+	// The daemon has the totals but no namespace details
+	s.set(newAPIState(clusterInfo, calculateCapcity(clusterInfo), nil, nil, refreshed))
+	resp, _ = apiGet(t, server.URL+"/v1/namespaces/default", nil)
+	compareString(strconv.Itoa(resp.StatusCode), "501", t)
+	resp, body = apiGet(t, server.URL+"/v1/namespaces/default/totals", nil)
+	compareString(strconv.Itoa(resp.StatusCode), "200", t)
+	compareString(fmt.Sprint(body["cpu_requests.millicores"]), "1500", t)
+
+	resp, err := http.Post(server.URL+"/v1/cluster", "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	compareString(strconv.Itoa(resp.StatusCode), "405", t)
+}
+
+func TestAPIServerConditionalRequests(t *testing.T) {
+	s, server := apiTestServer()
+	defer server.Close()
+	clusterInfo, _ := metricsTestCluster()
+	s.set(newAPIState(clusterInfo, calculateCapcity(clusterInfo), nil, nil, time.Date(2020, 3, 6, 0, 0, 0, 0, time.UTC)))
+
+	resp, _ := apiGet(t, server.URL+"/v1/nodes", nil)
+	etag := resp.Header.Get("ETag")
+	if etag == "" {
+		t.Fatal("Expected an ETag")
+	}
+	resp, _ = apiGet(t, server.URL+"/v1/nodes", http.Header{"If-None-Match": {etag}})
+	compareString(strconv.Itoa(resp.StatusCode), "304", t)
+	resp, _ = apiGet(t, server.URL+"/v1/nodes", http.Header{"If-Modified-Since": {"Fri, 06 Mar 2020 00:00:00 GMT"}})
+	compareString(strconv.Itoa(resp.StatusCode), "304", t)
+	resp, _ = apiGet(t, server.URL+"/v1/nodes", http.Header{"If-Modified-Since": {"Thu, 05 Mar 2020 00:00:00 GMT"}})
+	compareString(strconv.Itoa(resp.StatusCode), "200", t)
+
+	// A refresh that changes the figures changes the ETag
+	node := clusterInfo.NodeInfo["node-b"]
+	node.UsedPods = 41
+	clusterInfo.NodeInfo["node-b"] = node
+	s.set(newAPIState(clusterInfo, calculateCapcity(clusterInfo), nil, nil, time.Date(2020, 3, 6, 0, 1, 0, 0, time.UTC)))
+	resp, _ = apiGet(t, server.URL+"/v1/nodes", http.Header{"If-None-Match": {etag}})
+	compareString(strconv.Itoa(resp.StatusCode), "200", t)
+	if resp.Header.Get("ETag") == etag {
+		t.Errorf("Expected a new ETag after the refresh, got %s again", etag)
+	}
+}
+
+// TestAPIServerReload reloads -event-version while /v1/cluster is answered,
+// run with -race
+func TestAPIServerReload(t *testing.T) {
+	defer setSettings(currentSettings())
+	s, server := apiTestServer()
+	defer server.Close()
+	clusterInfo, _ := metricsTestCluster()
+	s.set(newAPIState(clusterInfo, calculateCapcity(clusterInfo), nil, nil, time.Now()))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 20; i++ {
+			apiGet(t, server.URL+"/v1/cluster", nil)
+		}
+	}()
+	for i := 0; ; i++ {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		c, settings := newDaemonCommand()
+		if err := c.parse([]string{"-event-version", strconv.Itoa(1 + i%2)}); err != nil {
+			t.Fatal(err)
+		}
+		settings.cluster.setUp()
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+
+	resource "k8s.io/apimachinery/pkg/api/resource"
+)
+
+// FitResult : How many replicas of one pod shape still fit on the selected nodes
+type FitResult struct {
+	CPURequestMilliCores int64            `json:"k8s_quota.simulate.cpu_request.millicores"`
+	MemoryRequest        int64            `json:"k8s_quota.simulate.memory_request.bytes"`
+	Replicas             int64            `json:"k8s_quota.simulate.replicas"`
+	FitTotal             int64            `json:"k8s_quota.simulate.fit.total"`
+	FitNminusone         int64            `json:"k8s_quota.simulate.fit.nminusone"`
+	FitsTotal            bool             `json:"k8s_quota.simulate.fits.total"`
+	FitsNminusone        bool             `json:"k8s_quota.simulate.fits.nminusone"`
+	FitNodes             map[string]int64 `json:"k8s_quota.simulate.fit.nodes"`
+}
+
+// nodeFit is how many pods requesting cpu and memory the node has room for
+func nodeFit(node NodeInfo, cpu, memory resource.Quantity) int64 {
+	fit := node.AllocatablePods.Value() - node.UsedPods
+	if cpu.MilliValue() > 0 {
+		available := node.AllocatableCPU.MilliValue() - node.UsedCPURequests.MilliValue()
+		if available/cpu.MilliValue() < fit {
+			fit = available / cpu.MilliValue()
+		}
+	}
+	if memory.Value() > 0 {
+		available := node.AllocatableMemory.Value() - node.UsedMemoryRequests.Value()
+		if available/memory.Value() < fit {
+			fit = available / memory.Value()
+		}
+	}
+	if fit < 0 {
+		return 0
+	}
+	return fit
+}
+
+// simulateFit places replicas requesting cpu and memory on the selected nodes.
+// N-1 loses the node that would hold the most of them
+func simulateFit(clusterInfo ClusterInfo, cpu, memory resource.Quantity, replicas int64) (result FitResult) {
+	result.CPURequestMilliCores = cpu.MilliValue()
+	result.MemoryRequest = memory.Value()
+	result.Replicas = replicas
+	result.FitNodes = make(map[string]int64)
+	var largest int64
+	for name, node := range clusterInfo.NodeInfo {
+		if !node.PrintOutput {
+			continue
+		}
+		fit := nodeFit(node, cpu, memory)
+		result.FitNodes[name] = fit
+		result.FitTotal = result.FitTotal + fit
+		if fit > largest {
+			largest = fit
+		}
+	}
+	result.FitNminusone = result.FitTotal - largest
+	result.FitsTotal = replicas <= result.FitTotal
+	result.FitsNminusone = replicas <= result.FitNminusone
+	return result
+}
+
+func fitHumanMode(result FitResult) (output []string) {
+	output = append(output, "================")
+	output = append(output, fmt.Sprintf("Pod requesting CPU: %dm Memory: %dMiB, %d replicas", result.CPURequestMilliCores, toMibFromByte(result.MemoryRequest), result.Replicas))
+	output = append(output, "----------------")
+	output = append(output, fmt.Sprintf("Replicas that fit: %d (fits: %t)", result.FitTotal, result.FitsTotal))
+	output = append(output, fmt.Sprintf("Replicas that fit N-1: %d (fits: %t)", result.FitNminusone, result.FitsNminusone))
+	output = append(output, "================")
+	return output
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestSimulateFit(t *testing.T) {
+	clusterInfo := ClusterInfo{NodeInfo: map[string]NodeInfo{
+		"node-a": {PrintOutput: true, AllocatableCPU: resource.MustParse("4"), AllocatableMemory: resource.MustParse("16Gi"),
+			AllocatablePods: resource.MustParse("110"), UsedCPURequests: resource.MustParse("1")},
+		"node-b": {PrintOutput: true, AllocatableCPU: resource.MustParse("2"), AllocatableMemory: resource.MustParse("2Gi"),
+			AllocatablePods: resource.MustParse("3"), UsedPods: 1},
+		"node-c": {PrintOutput: false, AllocatableCPU: resource.MustParse("64"), AllocatableMemory: resource.MustParse("256Gi"),
+			AllocatablePods: resource.MustParse("110")},
+	}}
+	result := simulateFit(clusterInfo, resource.MustParse("500m"), resource.MustParse("1Gi"), 7)
+	// node-a: cpu 6, memory 16 -> 6. node-b: cpu 4, memory 2, pods 2 -> 2
+	if result.FitNodes["node-a"] != 6 || result.FitNodes["node-b"] != 2 {
+		t.Errorf("Unexpected per node fit %v", result.FitNodes)
+	}
+	if result.FitTotal != 8 || result.FitNminusone != 2 {
+		t.Errorf("Expected 8 in total and 2 N-1, got %d and %d", result.FitTotal, result.FitNminusone)
+	}
+	if !result.FitsTotal || result.FitsNminusone {
+		t.Errorf("Expected 7 replicas to fit in total but not N-1, got %+v", result)
+	}
+
+	result = simulateFit(clusterInfo, resource.MustParse("8"), resource.Quantity{}, 1)
+	if result.FitTotal != 0 || result.FitsTotal {
+		t.Errorf("Expected nothing to fit an 8 core pod, got %+v", result)
+	}
+}
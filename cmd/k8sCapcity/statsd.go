@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// statsdPacketSize keeps a datagram within one ethernet frame
+const statsdPacketSize = 1432
+
+// statsdOptions : Where the daemon sends its gauges as StatsD over UDP
+type statsdOptions struct {
+	address string
+	prefix  string
+	tags    bool
+}
+
+func addStatsdFlags(flags *flag.FlagSet) *statsdOptions {
+	o := &statsdOptions{}
+	flags.StringVar(&o.address, "statsd-address", "", "In daemon mode, also send the capacity gauges to this StatsD host:port over UDP")
+	flags.StringVar(&o.prefix, "statsd-prefix", "k8scapcity", "First segment of every StatsD gauge name")
+	flags.BoolVar(&o.tags, "statsd-tags", false, "Send the cluster, node and namespace as DogStatsD tags instead of name segments")
+	return o
+}
+
+func (o *statsdOptions) validate() error {
+	if o.address == "" {
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(o.address); err != nil {
+		return fmt.Errorf("-statsd-address %q is not host:port", o.address)
+	}
+	return nil
+}
+
+// statsdSink : Sends gauges to a StatsD server, as many as fit in each datagram
+type statsdSink struct {
+	statsdOptions
+}
+
+func (s *statsdSink) String() string {
+	return s.address
+}
+
+func (s *statsdSink) write(groups []metricGroup, now time.Time) error {
+	conn, err := net.Dial("udp", s.address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	for _, packet := range statsdPackets(statsdLines(s.statsdOptions, groups)) {
+		if _, err := conn.Write(packet); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// statsdLines is a gauge per line. A StatsD gauge given a sign is changed
+// by that much rather than set, so a negative one is first set to 0
+func statsdLines(o statsdOptions, groups []metricGroup) (lines []string) {
+	for _, group := range groups {
+		var tags string
+		if o.tags {
+			var pairs []string
+			for _, attribute := range group.attributes {
+				pairs = append(pairs, attribute.key+":"+strings.NewReplacer(",", "_", "|", "_").Replace(attribute.value))
+			}
+			if len(pairs) > 0 {
+				tags = "|#" + strings.Join(pairs, ",")
+			}
+		}
+		for _, gauge := range group.gauges {
+			name := metricPath(o.prefix, group, gauge, !o.tags)
+			if gauge.value < 0 {
+				lines = append(lines, name+":0|g"+tags)
+			}
+			lines = append(lines, name+":"+strconv.FormatFloat(gauge.value, 'f', -1, 64)+"|g"+tags)
+		}
+	}
+	return lines
+}
+
+func statsdPackets(lines []string) (packets [][]byte) {
+	packet := &bytes.Buffer{}
+	for _, line := range lines {
+		if packet.Len() > 0 && packet.Len()+1+len(line) > statsdPacketSize {
+			packets = append(packets, packet.Bytes())
+			packet = &bytes.Buffer{}
+		}
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(line)
+	}
+	if packet.Len() > 0 {
+		packets = append(packets, packet.Bytes())
+	}
+	return packets
+}
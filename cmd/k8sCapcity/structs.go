@@ -25,6 +25,18 @@ type ClusterInfo struct {
 	NminusMemory                     resource.Quantity
 	NminusPods                       resource.Quantity
 	NodeLabel                        string
+	NodeSelector                     string
+	NamespaceTotals                  map[string]NamespaceTotals
+	ClusterName                      string
+}
+
+// NamespaceTotals : Requests and limits of non-terminated pods on the selected nodes, summed per namespace
+type NamespaceTotals struct {
+	CPURequestsMilliCores int64 `json:"cpu_requests.millicores"`
+	CPULimitsMilliCores   int64 `json:"cpu_limits.millicores"`
+	MemoryRequests        int64 `json:"memory_requests.bytes"`
+	MemoryLimits          int64 `json:"memory_limits.bytes"`
+	Pods                  int64 `json:"pods"`
 }
 
 // NodeInfo : Information about the node
@@ -39,6 +51,9 @@ type NodeInfo struct {
 	UsedMemoryLimits   resource.Quantity
 	UsedCPURequests    resource.Quantity
 	PrintOutput        bool
+	Labels             map[string]string
+	Conditions         map[string]string
+	Unschedulable      bool
 }
 
 // ContainerInfo : Information about the container
@@ -61,49 +76,65 @@ type ContainerInfo struct {
 
 // Capcity : Json to print out about metrics we gathered
 type Capcity struct {
-	EventKind                                string             `json:"event.kind"`
-	EventModule                              string             `json:"event.module"`
-	EventProvider                            string             `json:"event.provider"`
-	EventType                                string             `json:"event.type"`
-	EventVersion                             string             `json:"event.version"`
-	ResourceQuotaCPURequestCores             int64              `json:"k8s_quota.resource_quota.cpu_request.cores"`
-	ResourceQuotaCPURequestMilliCores        int64              `json:"k8s_quota.resource_quota.cpu_request.millicores"`
-	ResourceQuotaMemoryRequest               int64              `json:"k8s_quota.resource_quota.memory_request"`
-	ResourceQuotaMemoryLimit                 int64              `json:"k8s_quota.resource_quota.memory_limit"`
-	ResourceQuotaPods                        int64              `json:"k8s_quota.resource_quota.pods"`
-	SubscriptionFactorMemoryRequestTotal     float64            `json:"k8s_quota.subscription_factor.memory.request.total"`
-	SubscriptionFactorMemoryRequestNminusone float64            `json:"k8s_quota.subscription_factor.memory.request.nminusone"`
-	SubscriptionFactorCPURequestTotal        float64            `json:"k8s_quota.subscription_factor.cpu.request.total"`
-	SubscriptionFactorCPURequestNminusone    float64            `json:"k8s_quota.subscription_factor.cpu.request.nminusone"`
-	SubscriptionFactorPodsTotal              float64            `json:"k8s_quota.subscription_factor.pods.total"`
-	SubscriptionFactorPodsNminusone          float64            `json:"k8s_quota.subscription_factor.pods.nminusone"`
-	AllocatableMemoryTotal                   int64              `json:"k8s_quota.alloctable.memory.total"`
-	AllocatableMemoryNminusone               int64              `json:"k8s_quota.alloctable.memory.nminusone"`
-	AllocatableCPUTotal                      int64              `json:"k8s_quota.alloctable.cpu.total"`
-	AllocatableCPUNminusone                  int64              `json:"k8s_quota.alloctable.cpu.nminusone"`
-	AllocatablePodsTotal                     int64              `json:"k8s_quota.alloctable.pods.total"`
-	AllocatablePodsNminusone                 int64              `json:"k8s_quota.alloctable.pods.nminusone"`
-	ContainerResourceCPURequestCores         int64              `json:"k8s_quota.container_resource.cpu_request.cores"`
-	ContainerResourceCPURequestMilliCores    int64              `json:"k8s_quota.container_resource.cpu_request.millicores"`
-	ContainerResourceMemoryRequest           int64              `json:"k8s_quota.container_resource.memory_request"`
-	ContainerResourceMemoryLimit             int64              `json:"k8s_quota.container_resource.memory_limit"`
-	ContainerResourcePods                    int64              `json:"k8s_quota.container_resource.pods"`
-	NodeLabel                                string             `json:"k8s_quota.node_label"`
-	UtilizationFactorPods                    map[string]float64 `json:"k8s_quota.utilization_factor.pods"`
-	UtilizationFactorPodsTotal               float64            `json:"k8s_quota.utilization_factor.pods.total"`
-	UtilizationFactorPodsNminusone           float64            `json:"k8s_quota.utilization_factor.pods.nminusone"`
-	UtilizationFactorMemoryRequests          map[string]float64 `json:"k8s_quota.utilization_factor.memory_request"`
-	UtilizationFactorMemoryRequestsTotal     float64            `json:"k8s_quota.utilization_factor.memory_request.total"`
-	UtilizationFactorMemoryRequestsNminusone float64            `json:"k8s_quota.utilization_factor.memory_request.nminusone"`
-	UtilizationFactorCPURequests             map[string]float64 `json:"k8s_quota.utilization_factor.cpu_request"`
-	UtilizationFactorCPURequestsTotal        float64            `json:"k8s_quota.utilization_factor.cpu_request.total"`
-	UtilizationFactorCPURequestsNminusone    float64            `json:"k8s_quota.utilization_factor.cpu_request.nminusone"`
-	AvailableMemoryRequestTotal              int64              `json:"k8s_quota.available.memory_request.total"`
-	AvailableMemoryRequestNminusone          int64              `json:"k8s_quota.available.memory_request.nminusone"`
-	AvailableCPURequestTotal                 int64              `json:"k8s_quota.available.cpu_request.total"`
-	AvailableCPURequestNminusone             int64              `json:"k8s_quota.available.cpu_request.nminusone"`
-	AvailablePodsTotal                       int64              `json:"k8s_quota.available.pods.total"`
-	AvailablePodsNminusone                   int64              `json:"k8s_quota.available.pods.nminusone"`
+	ECS
+	EventKind                                string             `json:"event.kind" description:"Always metric"`
+	EventModule                              string             `json:"event.module" description:"Always k8s_quota"`
+	EventProvider                            string             `json:"event.provider" description:"Always k8sCapcity"`
+	EventType                                string             `json:"event.type" description:"Always info"`
+	EventVersion                             string             `json:"event.version" description:"Version of this event's fields, see the schema $id"`
+	ResourceQuotaCPURequestCores             int64              `json:"k8s_quota.resource_quota.cpu_request.cores" description:"Cores of requests.cpu handed out by every ResourceQuota"`
+	ResourceQuotaCPURequestMilliCores        int64              `json:"k8s_quota.resource_quota.cpu_request.millicores" description:"Millicores of requests.cpu handed out by every ResourceQuota"`
+	ResourceQuotaMemoryRequest               int64              `json:"k8s_quota.resource_quota.memory_request" description:"Bytes of requests.memory handed out by every ResourceQuota"`
+	ResourceQuotaMemoryLimit                 int64              `json:"k8s_quota.resource_quota.memory_limit" description:"Bytes of limits.memory handed out by every ResourceQuota"`
+	ResourceQuotaPods                        int64              `json:"k8s_quota.resource_quota.pods" description:"Pods handed out by every ResourceQuota"`
+	SubscriptionFactorMemoryRequestTotal     float64            `json:"k8s_quota.subscription_factor.memory.request.total" description:"ResourceQuota memory requests / allocatable memory"`
+	SubscriptionFactorMemoryRequestNminusone float64            `json:"k8s_quota.subscription_factor.memory.request.nminusone" description:"ResourceQuota memory requests / allocatable N-1 memory"`
+	SubscriptionFactorCPURequestTotal        float64            `json:"k8s_quota.subscription_factor.cpu.request.total" description:"ResourceQuota cpu requests / allocatable cpu"`
+	SubscriptionFactorCPURequestNminusone    float64            `json:"k8s_quota.subscription_factor.cpu.request.nminusone" description:"ResourceQuota cpu requests / allocatable N-1 cpu"`
+	SubscriptionFactorPodsTotal              float64            `json:"k8s_quota.subscription_factor.pods.total" description:"ResourceQuota pods / allocatable pods"`
+	SubscriptionFactorPodsNminusone          float64            `json:"k8s_quota.subscription_factor.pods.nminusone" description:"ResourceQuota pods / allocatable N-1 pods"`
+	AllocatableMemoryTotal                   int64              `json:"k8s_quota.alloctable.memory.total" description:"Bytes of allocatable memory on the selected nodes"`
+	AllocatableMemoryNminusone               int64              `json:"k8s_quota.alloctable.memory.nminusone" description:"Bytes of allocatable memory without the largest node"`
+	AllocatableCPUTotal                      int64              `json:"k8s_quota.alloctable.cpu.total" description:"Cores of allocatable cpu on the selected nodes"`
+	AllocatableCPUNminusone                  int64              `json:"k8s_quota.alloctable.cpu.nminusone" description:"Cores of allocatable cpu without the largest node"`
+	AllocatablePodsTotal                     int64              `json:"k8s_quota.alloctable.pods.total" description:"Allocatable pods on the selected nodes"`
+	AllocatablePodsNminusone                 int64              `json:"k8s_quota.alloctable.pods.nminusone" description:"Allocatable pods without the largest node"`
+	ContainerResourceCPURequestCores         int64              `json:"k8s_quota.container_resource.cpu_request.cores" description:"Cores of cpu requested by non-terminated pods on the selected nodes"`
+	ContainerResourceCPURequestMilliCores    int64              `json:"k8s_quota.container_resource.cpu_request.millicores" description:"Millicores of cpu requested by non-terminated pods on the selected nodes"`
+	ContainerResourceMemoryRequest           int64              `json:"k8s_quota.container_resource.memory_request" description:"Bytes of memory requested by non-terminated pods on the selected nodes"`
+	ContainerResourceMemoryLimit             int64              `json:"k8s_quota.container_resource.memory_limit" description:"Bytes of memory limits of non-terminated pods on the selected nodes"`
+	ContainerResourcePods                    int64              `json:"k8s_quota.container_resource.pods" description:"Non-terminated pods on the selected nodes"`
+	NodeLabel                                string             `json:"k8s_quota.node_label" description:"The -nodelabel the nodes were selected with, blank for every node"`
+	ClusterName                              string             `json:"k8s_quota.cluster_name,omitempty" description:"Kubeconfig context the event was collected from, fleet for the roll-up"`
+	FleetClusters                            []string           `json:"k8s_quota.fleet.clusters,omitempty" description:"Fleet roll-up only, the clusters added up into it"`
+	FleetFailedClusters                      []string           `json:"k8s_quota.fleet.failed_clusters,omitempty" description:"Fleet roll-up only, the clusters that could not be collected"`
+	UtilizationFactorPods                    map[string]float64 `json:"k8s_quota.utilization_factor.pods" description:"Pods / allocatable pods of every selected node"`
+	UtilizationFactorPodsTotal               float64            `json:"k8s_quota.utilization_factor.pods.total" description:"Pods / allocatable pods"`
+	UtilizationFactorPodsNminusone           float64            `json:"k8s_quota.utilization_factor.pods.nminusone" description:"Pods / allocatable N-1 pods"`
+	UtilizationFactorMemoryRequests          map[string]float64 `json:"k8s_quota.utilization_factor.memory_request" description:"Memory requests / allocatable memory of every selected node"`
+	UtilizationFactorMemoryRequestsTotal     float64            `json:"k8s_quota.utilization_factor.memory_request.total" description:"Memory requests / allocatable memory"`
+	UtilizationFactorMemoryRequestsNminusone float64            `json:"k8s_quota.utilization_factor.memory_request.nminusone" description:"Memory requests / allocatable N-1 memory"`
+	UtilizationFactorCPURequests             map[string]float64 `json:"k8s_quota.utilization_factor.cpu_request" description:"Cpu requests / allocatable cpu of every selected node"`
+	UtilizationFactorCPURequestsTotal        float64            `json:"k8s_quota.utilization_factor.cpu_request.total" description:"Cpu requests / allocatable cpu"`
+	UtilizationFactorCPURequestsNminusone    float64            `json:"k8s_quota.utilization_factor.cpu_request.nminusone" description:"Cpu requests / allocatable N-1 cpu"`
+	AvailableMemoryRequestTotal              int64              `json:"k8s_quota.available.memory_request.total" description:"Bytes of allocatable memory not requested"`
+	AvailableMemoryRequestNminusone          int64              `json:"k8s_quota.available.memory_request.nminusone" description:"Bytes of allocatable N-1 memory not requested"`
+	AvailableCPURequestTotal                 int64              `json:"k8s_quota.available.cpu_request.total" description:"Cores of allocatable cpu not requested"`
+	AvailableCPURequestNminusone             int64              `json:"k8s_quota.available.cpu_request.nminusone" description:"Cores of allocatable N-1 cpu not requested"`
+	AvailablePodsTotal                       int64              `json:"k8s_quota.available.pods.total" description:"Allocatable pods not scheduled"`
+	AvailablePodsNminusone                   int64              `json:"k8s_quota.available.pods.nminusone" description:"Allocatable N-1 pods not scheduled"`
+	ForecastCPURequestNminusoneExhaustion    string             `json:"k8s_quota.forecast.cpu_request.nminusone.exhaustion,omitempty" description:"RFC3339 date available N-1 cpu requests is projected to cross -forecast-threshold"`
+	ForecastCPURequestNminusoneEarliest      string             `json:"k8s_quota.forecast.cpu_request.nminusone.earliest,omitempty" description:"RFC3339 earliest date within the confidence range"`
+	ForecastCPURequestNminusoneLatest        string             `json:"k8s_quota.forecast.cpu_request.nminusone.latest,omitempty" description:"RFC3339 latest date within the confidence range, absent when it may never cross"`
+	ForecastCPURequestNminusoneDays          *float64           `json:"k8s_quota.forecast.cpu_request.nminusone.days,omitempty" description:"Days from the last sample to the projected exhaustion"`
+	ForecastMemoryRequestNminusoneExhaustion string             `json:"k8s_quota.forecast.memory_request.nminusone.exhaustion,omitempty" description:"RFC3339 date available N-1 memory requests is projected to cross -forecast-threshold"`
+	ForecastMemoryRequestNminusoneEarliest   string             `json:"k8s_quota.forecast.memory_request.nminusone.earliest,omitempty" description:"RFC3339 earliest date within the confidence range"`
+	ForecastMemoryRequestNminusoneLatest     string             `json:"k8s_quota.forecast.memory_request.nminusone.latest,omitempty" description:"RFC3339 latest date within the confidence range, absent when it may never cross"`
+	ForecastMemoryRequestNminusoneDays       *float64           `json:"k8s_quota.forecast.memory_request.nminusone.days,omitempty" description:"Days from the last sample to the projected exhaustion"`
+	ForecastPodsNminusoneExhaustion          string             `json:"k8s_quota.forecast.pods.nminusone.exhaustion,omitempty" description:"RFC3339 date available N-1 pods is projected to cross -forecast-threshold"`
+	ForecastPodsNminusoneEarliest            string             `json:"k8s_quota.forecast.pods.nminusone.earliest,omitempty" description:"RFC3339 earliest date within the confidence range"`
+	ForecastPodsNminusoneLatest              string             `json:"k8s_quota.forecast.pods.nminusone.latest,omitempty" description:"RFC3339 latest date within the confidence range, absent when it may never cross"`
+	ForecastPodsNminusoneDays                *float64           `json:"k8s_quota.forecast.pods.nminusone.days,omitempty" description:"Days from the last sample to the projected exhaustion"`
 }
 
 // NamespaceInfo : Information about the namespace
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"text/template"
+
+	resource "k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/client-go/util/jsonpath"
+)
+
+// clusterTemplateData : What templates see for the cluster report, every
+// Capcity field plus the ClusterInfo it was calculated from
+type clusterTemplateData struct {
+	Capcity
+	ClusterInfo ClusterInfo
+}
+
+// argumentRenderers : Output formats that take an argument, -o name=argument
+var argumentRenderers = map[string]func(argument string) (renderer, error){
+	"go-template":      goTemplateRenderer,
+	"go-template-file": fileRenderer(goTemplateRenderer),
+	"jsonpath":         jsonPathRenderer,
+	"jsonpath-file":    fileRenderer(jsonPathRenderer),
+}
+
+// templateFuncs are the helpers go templates get on top of the builtins
+var templateFuncs = template.FuncMap{
+	"toGib": func(v interface{}) (float64, error) {
+		if q, ok := quantityOf(v); ok {
+			return toGib(q), nil
+		}
+		bytes, err := bytesOf(v)
+		return toGibFromByte(bytes), err
+	},
+	"toMib": func(v interface{}) (int64, error) {
+		if q, ok := quantityOf(v); ok {
+			return toMib(q), nil
+		}
+		bytes, err := bytesOf(v)
+		return toMibFromByte(bytes), err
+	},
+	"percent": func(factor float64) string {
+		return fmt.Sprintf("%.1f%%", factor*100)
+	},
+}
+
+func quantityOf(v interface{}) (resource.Quantity, bool) {
+	switch q := v.(type) {
+	case resource.Quantity:
+		return q, true
+	case *resource.Quantity:
+		return *q, true
+	}
+	return resource.Quantity{}, false
+}
+
+func bytesOf(v interface{}) (int64, error) {
+	switch n := v.(type) {
+	case int:
+		return int64(n), nil
+	case int64:
+		return n, nil
+	case float64:
+		return int64(n), nil
+	}
+	return 0, fmt.Errorf("expected a quantity or a number of bytes, got %T", v)
+}
+
+// templateData is what go templates and jsonpath run over
+func templateData(v view) interface{} {
+	if v.data != nil {
+		return v.data
+	}
+	return v.object
+}
+
+func fileRenderer(newRenderer func(string) (renderer, error)) func(string) (renderer, error) {
+	return func(path string) (renderer, error) {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		return newRenderer(string(data))
+	}
+}
+
+func goTemplateRenderer(text string) (renderer, error) {
+	tmpl, err := template.New("output").Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing template %s, %s", text, err)
+	}
+	return func(out io.Writer, v view) error {
+		return tmpl.Execute(out, templateData(v))
+	}, nil
+}
+
+// jsonPathRenderer runs kubectl style jsonpath over the json form of the data,
+// so fields go by their json names, e.g. {.k8s_quota\.available\.pods\.total}
+func jsonPathRenderer(text string) (renderer, error) {
+	if !strings.Contains(text, "{") {
+		text = "{" + text + "}"
+	}
+	path := jsonpath.New("output")
+	err := path.Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing jsonpath %s, %s", text, err)
+	}
+	return func(out io.Writer, v view) error {
+		data, err := json.Marshal(templateData(v))
+		if err != nil {
+			return err
+		}
+		var object interface{}
+		err = json.Unmarshal(data, &object)
+		if err != nil {
+			return err
+		}
+		return path.Execute(out, object)
+	}, nil
+}
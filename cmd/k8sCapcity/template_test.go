@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func templateTestView() view {
+	clusterInfo := ClusterInfo{NodeInfo: map[string]NodeInfo{
+		"node-a": {PrintOutput: true, UsedMemoryRequests: resource.MustParse("2Gi"), UsedPods: 11},
+	}}
+	addNodeAllocatable(&clusterInfo, clusterInfo.NodeInfo, *testNode("node-a", nil, "4", "16Gi"))
+	return clusterView(clusterInfo, calculateCapcity(clusterInfo))
+}
+
+func TestGoTemplate(t *testing.T) {
+	var out bytes.Buffer
+	tmpl := `{{ .AvailablePodsTotal }} pods, {{ toGib .AvailableMemoryRequestTotal }}GiB, {{ percent .UtilizationFactorPodsTotal }}` +
+		`{{ range $name, $node := .ClusterInfo.NodeInfo }} {{ $name }}={{ toMib $node.UsedMemoryRequests }}Mi{{ end }}`
+	err := render(&out, "go-template="+tmpl, templateTestView())
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareString(out.String(), "99 pods, 14GiB, 10.0% node-a=2048Mi", t)
+
+	out.Reset()
+	err = render(&out, "go-template={{ .Name }}/{{ toMib .NamespaceMemoryRequests }}", namespaceView(NamespaceInfo{Name: "web", NamespaceMemoryRequests: 512 << 20}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareString(out.String(), "web/512", t)
+}
+
+func TestGoTemplateFile(t *testing.T) {
+	file, err := ioutil.TempFile("", "k8sCapcity-template")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString("{{ .AllocatablePodsTotal }}\n")
+	file.Close()
+	var out bytes.Buffer
+	err = render(&out, "go-template-file="+file.Name(), templateTestView())
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareString(out.String(), "110\n", t)
+}
+
+func TestJSONPath(t *testing.T) {
+	var out bytes.Buffer
+	err := render(&out, `jsonpath={.k8s_quota\.available\.pods\.total} {.ClusterInfo.NodeInfo.node-a.UsedPods}`, templateTestView())
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareString(out.String(), "99 11", t)
+
+	out.Reset()
+	err = render(&out, "jsonpath=.k8s_quota\\.node_label", templateTestView())
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareString(out.String(), "", t)
+}
+
+func TestTemplateErrors(t *testing.T) {
+	for _, format := range []string{"go-template", "go-template=", "go-template={{ .Unclosed", "jsonpath={.a[}", "go-template-file=/nonexistent"} {
+		if err := checkOutput(format); err == nil {
+			t.Errorf("%s: expected an error", format)
+		}
+	}
+	err := render(&bytes.Buffer{}, "go-template={{ toGib .EventKind }}", templateTestView())
+	if err == nil || !strings.Contains(err.Error(), "expected a quantity or a number of bytes") {
+		t.Errorf("Expected toGib to reject a string, got %v", err)
+	}
+}
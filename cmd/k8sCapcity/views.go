@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func usedPercent(used, allocatable int64) string {
+	if allocatable == 0 {
+		return "<unknown>"
+	}
+	return fmt.Sprintf("%d%%", used*100/allocatable)
+}
+
+func milliCores(q resource.Quantity) string {
+	return fmt.Sprintf("%dm", q.MilliValue())
+}
+
+// clusterView is every selected node, then the cluster wide and N-1 totals
+func clusterView(clusterInfo ClusterInfo, capCity Capcity) view {
+	return view{tables: []table{nodeRows(clusterInfo), clusterRows(capCity)}, object: eventObject(capCity),
+		html: newHTMLReport(clusterInfo, capCity, time.Now()),
+		data: clusterTemplateData{Capcity: capCity, ClusterInfo: clusterInfo}}
+}
+
+func nodesView(clusterInfo ClusterInfo, eventLabels []string) view {
+	return view{tables: []table{nodeRows(clusterInfo)}, object: nodeEvents(clusterInfo, eventLabels)}
+}
+
+func nodePoolsView(clusterInfo ClusterInfo, poolLabel string) view {
+	pools := nodePoolCapacities(clusterInfo, poolLabel)
+	return view{tables: []table{nodePoolRows(pools)}, object: pools}
+}
+
+func namespaceView(nsInfo NamespaceInfo) view {
+	return view{tables: []table{namespaceRows(nsInfo)}, object: nsInfo}
+}
+
+func namespacesView(namespaceTotals map[string]NamespaceTotals) view {
+	return view{tables: []table{namespacesRows(namespaceTotals)}, object: namespaceTotals}
+}
+
+// nodeRows has one row per selected node
+func nodeRows(clusterInfo ClusterInfo) (t table) {
+	t.addColumns(false, "NAME", "CPU REQUESTS", "CPU%", "MEMORY REQUESTS", "MEMORY%", "PODS", "PODS%")
+	t.addColumns(true, "CPU USED", "MEMORY USED", "CPU AVAILABLE", "MEMORY AVAILABLE", "PODS AVAILABLE")
+	var names []string
+	for name, node := range clusterInfo.NodeInfo {
+		if node.PrintOutput {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		node := clusterInfo.NodeInfo[name]
+		availableCPU := node.AllocatableCPU.DeepCopy()
+		availableCPU.Sub(node.UsedCPURequests)
+		availableMemory := node.AllocatableMemory.DeepCopy()
+		availableMemory.Sub(node.UsedMemoryRequests)
+		t.addRow(name,
+			milliCores(node.UsedCPURequests)+"/"+milliCores(node.AllocatableCPU),
+			usedPercent(node.UsedCPURequests.MilliValue(), node.AllocatableCPU.MilliValue()),
+			fmt.Sprintf("%.1fGi/%.1fGi", toGib(node.UsedMemoryRequests), toGib(node.AllocatableMemory)),
+			usedPercent(node.UsedMemoryRequests.Value(), node.AllocatableMemory.Value()),
+			fmt.Sprintf("%d/%d", node.UsedPods, node.AllocatablePods.Value()),
+			usedPercent(node.UsedPods, node.AllocatablePods.Value()),
+			milliCores(node.UsedCPU), fmt.Sprintf("%.1fGi", toGib(node.UsedMemory)),
+			milliCores(availableCPU), fmt.Sprintf("%.1fGi", toGib(availableMemory)),
+			fmt.Sprintf("%d", node.AllocatablePods.Value()-node.UsedPods))
+	}
+	return t
+}
+
+// clusterRows has the cluster wide and N-1 totals
+func clusterRows(capCity Capcity) (t table) {
+	t.addColumns(false, "CAPACITY", "CPU AVAILABLE", "MEMORY AVAILABLE", "PODS AVAILABLE", "CPU%", "MEMORY%", "PODS%")
+	t.addColumns(true, "CPU QUOTA", "MEMORY QUOTA", "PODS QUOTA")
+	rows := []struct {
+		name                 string
+		cpu, memory, pods    int64
+		cpuUF, memoryUF, pUF float64
+		cpuSF, memorySF, pSF float64
+	}{
+		{"total", capCity.AvailableCPURequestTotal, capCity.AvailableMemoryRequestTotal, capCity.AvailablePodsTotal,
+			capCity.UtilizationFactorCPURequestsTotal, capCity.UtilizationFactorMemoryRequestsTotal, capCity.UtilizationFactorPodsTotal,
+			capCity.SubscriptionFactorCPURequestTotal, capCity.SubscriptionFactorMemoryRequestTotal, capCity.SubscriptionFactorPodsTotal},
+		{"n-1", capCity.AvailableCPURequestNminusone, capCity.AvailableMemoryRequestNminusone, capCity.AvailablePodsNminusone,
+			capCity.UtilizationFactorCPURequestsNminusone, capCity.UtilizationFactorMemoryRequestsNminusone, capCity.UtilizationFactorPodsNminusone,
+			capCity.SubscriptionFactorCPURequestNminusone, capCity.SubscriptionFactorMemoryRequestNminusone, capCity.SubscriptionFactorPodsNminusone},
+	}
+	for _, r := range rows {
+		t.addRow(r.name, fmt.Sprintf("%d", r.cpu), fmt.Sprintf("%.1fGi", toGibFromByte(r.memory)), fmt.Sprintf("%d", r.pods),
+			fmt.Sprintf("%.0f%%", r.cpuUF*100), fmt.Sprintf("%.0f%%", r.memoryUF*100), fmt.Sprintf("%.0f%%", r.pUF*100),
+			fmt.Sprintf("%.0f%%", r.cpuSF*100), fmt.Sprintf("%.0f%%", r.memorySF*100), fmt.Sprintf("%.0f%%", r.pSF*100))
+	}
+	return t
+}
+
+// nodePoolRows has one row per pool
+func nodePoolRows(pools []NodePoolCapacity) (t table) {
+	t.addColumns(false, "POOL", "NODES", "CPU REQUESTS", "CPU%", "MEMORY REQUESTS", "MEMORY%", "PODS", "PODS%")
+	t.addColumns(true, "CPU AVAILABLE", "MEMORY AVAILABLE", "PODS AVAILABLE", "CPU AVAILABLE N-1", "MEMORY AVAILABLE N-1", "PODS AVAILABLE N-1")
+	for _, pool := range pools {
+		t.addRow(pool.Name, fmt.Sprintf("%d", pool.Nodes),
+			fmt.Sprintf("%dm/%dm", pool.CPURequestMilliCores, pool.AllocatableCPUMilliCores),
+			usedPercent(pool.CPURequestMilliCores, pool.AllocatableCPUMilliCores),
+			fmt.Sprintf("%.1fGi/%.1fGi", toGibFromByte(pool.MemoryRequest), toGibFromByte(pool.AllocatableMemory)),
+			usedPercent(pool.MemoryRequest, pool.AllocatableMemory),
+			fmt.Sprintf("%d/%d", pool.Pods, pool.AllocatablePods),
+			usedPercent(pool.Pods, pool.AllocatablePods),
+			fmt.Sprintf("%dm", pool.AvailableCPURequestMilliCores), fmt.Sprintf("%.1fGi", toGibFromByte(pool.AvailableMemoryRequest)),
+			fmt.Sprintf("%d", pool.AvailablePods),
+			fmt.Sprintf("%dm", pool.AvailableCPURequestNminusoneMilliCores), fmt.Sprintf("%.1fGi", toGibFromByte(pool.AvailableMemoryRequestNminusone)),
+			fmt.Sprintf("%d", pool.AvailablePodsNminusone))
+	}
+	return t
+}
+
+// namespaceRows has one row per container, then the namespace totals
+func namespaceRows(nsInfo NamespaceInfo) (t table) {
+	t.addColumns(false, "POD", "CONTAINER", "CPU REQUESTS", "CPU LIMITS", "CPU USED", "MEMORY REQUESTS", "MEMORY LIMITS", "MEMORY USED")
+	t.addColumns(true, "CPU USED/REQUESTS", "MEMORY USED/REQUESTS")
+	var podNames []string
+	for podName := range nsInfo.NamespacePods {
+		podNames = append(podNames, podName)
+	}
+	sort.Strings(podNames)
+	for _, podName := range podNames {
+		var containerNames []string
+		for name := range nsInfo.NamespacePods[podName].Containers {
+			containerNames = append(containerNames, name)
+		}
+		sort.Strings(containerNames)
+		for _, name := range containerNames {
+			container := nsInfo.NamespacePods[podName].Containers[name]
+			t.addRow(podName, container.Name,
+				fmt.Sprintf("%dm", container.CPURequestsMilliCores), fmt.Sprintf("%dm", container.CPULimitsMilliCores), fmt.Sprintf("%dm", container.CPUUsedMilliCores),
+				fmt.Sprintf("%dMi", toMibFromByte(container.MemoryRequests)), fmt.Sprintf("%dMi", toMibFromByte(container.MemoryLimits)), fmt.Sprintf("%dMi", toMibFromByte(container.MemoryUsed)),
+				usedPercent(container.CPUUsedMilliCores, container.CPURequestsMilliCores),
+				usedPercent(container.MemoryUsed, container.MemoryRequests))
+		}
+	}
+	t.addRow("<total>", "",
+		fmt.Sprintf("%dm", nsInfo.NamespaceCPURequestsMilliCores), fmt.Sprintf("%dm", nsInfo.NamespaceCPULimitsMilliCores), fmt.Sprintf("%dm", nsInfo.NamespaceCPUUsedMilliCores),
+		fmt.Sprintf("%dMi", toMibFromByte(nsInfo.NamespaceMemoryRequests)), fmt.Sprintf("%dMi", toMibFromByte(nsInfo.NamespaceMemoryLimits)), fmt.Sprintf("%dMi", toMibFromByte(nsInfo.NamespaceMemoryUsed)),
+		usedPercent(nsInfo.NamespaceCPUUsedMilliCores, nsInfo.NamespaceCPURequestsMilliCores),
+		usedPercent(nsInfo.NamespaceMemoryUsed, nsInfo.NamespaceMemoryRequests))
+	return t
+}
+
+// namespacesRows has the requests and limits of every namespace on the selected nodes
+func namespacesRows(namespaceTotals map[string]NamespaceTotals) (t table) {
+	t.addColumns(false, "NAMESPACE", "PODS", "CPU REQUESTS", "CPU LIMITS", "MEMORY REQUESTS", "MEMORY LIMITS")
+	var names []string
+	for name := range namespaceTotals {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		totals := namespaceTotals[name]
+		t.addRow(name, fmt.Sprintf("%d", totals.Pods),
+			fmt.Sprintf("%dm", totals.CPURequestsMilliCores), fmt.Sprintf("%dm", totals.CPULimitsMilliCores),
+			fmt.Sprintf("%dMi", toMibFromByte(totals.MemoryRequests)), fmt.Sprintf("%dMi", toMibFromByte(totals.MemoryLimits)))
+	}
+	return t
+}
+
+// nodeTable prints one row per selected node
+func nodeTable(out io.Writer, clusterInfo ClusterInfo, wide bool) {
+	writeAligned(out, nodeRows(clusterInfo), wide)
+}
+
+// namespacesTable prints the requests and limits of every namespace on the selected nodes
+func namespacesTable(out io.Writer, namespaceTotals map[string]NamespaceTotals) {
+	writeAligned(out, namespacesRows(namespaceTotals), false)
+}
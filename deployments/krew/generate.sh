@@ -0,0 +1,21 @@
+#!/bin/bash
+# Fill in the krew manifest from a goreleaser dist directory
+# Usage: generate.sh <release tag> <dist dir>
+VERSION=$1
+DIST=$2
+HERE=$(dirname "$0")
+if [[ -z $VERSION || ! -f $DIST/checksums.txt ]]; then
+    echo "Usage: $0 <release tag> <dist dir with checksums.txt>"
+    exit 1
+fi
+
+sha() {
+    awk -v f="$1" '$2 == f {print $1}' "$DIST/checksums.txt"
+}
+
+sed -e "s/VERSION/$VERSION/g" \
+    -e "s/SHA256_LINUX_AMD64/$(sha kubectl-capacity_linux_amd64.tar.gz)/" \
+    -e "s/SHA256_DARWIN_AMD64/$(sha kubectl-capacity_darwin_amd64.tar.gz)/" \
+    -e "s/SHA256_WINDOWS_AMD64/$(sha kubectl-capacity_windows_amd64.zip)/" \
+    "$HERE/capacity.yaml" > "$DIST/capacity.yaml"
+echo "Wrote $DIST/capacity.yaml"
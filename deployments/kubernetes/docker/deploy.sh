@@ -1,5 +1,5 @@
 #!/bin/bash
-VERSION=v0.2.3
+VERSION=v0.3.0
 docker build -t=push.soh.re/k8scapcity:$VERSION .
 docker tag push.soh.re/k8scapcity:$VERSION push.soh.re/k8scapcity:latest
 docker push push.soh.re/k8scapcity:$VERSION
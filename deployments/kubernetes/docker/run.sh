@@ -1,7 +1,8 @@
 #!/bin/sh
 tar zxf /opt/k8sCapcity_Linux_x86_64.tar.gz -C /opt/
-if [ -z "$NODELABEL" ]; then
-    /opt/k8sCapcity --daemon
-else
-    /opt/k8sCapcity --daemon --nodelabel $NODELABEL
+# Settings come from K8SCAPCITY_* environment variables, e.g. K8SCAPCITY_NODELABEL
+if [ -n "$NODELABEL" ] && [ -z "$K8SCAPCITY_NODELABEL" ]; then
+    echo "NODELABEL is deprecated, set K8SCAPCITY_NODELABEL instead" >&2
+    export K8SCAPCITY_NODELABEL="$NODELABEL"
 fi
+exec /opt/k8sCapcity daemon